@@ -0,0 +1,74 @@
+package sdbm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditOp identifies the kind of mutation recorded in the audit log.
+type AuditOp string
+
+const (
+	// AuditStore records a key-value pair written by Store.
+	AuditStore AuditOp = "store"
+	// AuditDelete records a key removed by Delete.
+	AuditDelete AuditOp = "delete"
+)
+
+// AuditEntry is a single record of the audit log.
+//
+// Each entry is written as one line of space separated fields:
+//
+//	<time RFC3339Nano> <op> <key hex> <value length>
+//
+// The entry is written before the page holding the change is written, so a
+// committed change is never missing from the log. If the page write then
+// fails, the log contains an entry for a change that did not take effect.
+type AuditEntry struct {
+	Time   time.Time // The time the mutation was made.
+	Op     AuditOp   // The kind of mutation.
+	Key    Datum     // The key that was stored or deleted.
+	ValLen int       // The length of the stored value; 0 for deletions.
+}
+
+// String formats the entry as a single audit log line without the trailing newline.
+func (e AuditEntry) String() string {
+	return e.Time.UTC().Format(time.RFC3339Nano) + " " + string(e.Op) + " " +
+		hex.EncodeToString(e.Key) + " " + strconv.Itoa(e.ValLen)
+}
+
+// ParseAuditEntry parses a line written to Options.AuditLog.
+func ParseAuditEntry(line string) (AuditEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return AuditEntry{}, fmt.Errorf("sdbm: malformed audit entry %q", line)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("sdbm: malformed audit time: %w", err)
+	}
+	key, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("sdbm: malformed audit key: %w", err)
+	}
+	vlen, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("sdbm: malformed audit value length: %w", err)
+	}
+	return AuditEntry{Time: ts, Op: AuditOp(fields[1]), Key: key, ValLen: vlen}, nil
+}
+
+// audit writes an entry to the audit log, if one is configured.
+func (db *DBM) audit(op AuditOp, key Datum, vlen int) error {
+	if db.opts.AuditLog == nil {
+		return nil
+	}
+	e := AuditEntry{Time: time.Now(), Op: op, Key: key, ValLen: vlen}
+	if _, err := fmt.Fprintln(db.opts.AuditLog, e.String()); err != nil {
+		return fmt.Errorf("sdbm: write audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package sdbm_test
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_AuditLog(t *testing.T) {
+	var log bytes.Buffer
+	dbm, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), sdbm.Options{
+		Flags:    os.O_RDWR | os.O_CREATE,
+		Mode:     0644,
+		AuditLog: &log,
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+
+	ops := []struct {
+		op  sdbm.AuditOp
+		key string
+		val string
+	}{
+		{sdbm.AuditStore, "key1", "val1"},
+		{sdbm.AuditStore, "key2", "value2"},
+		{sdbm.AuditDelete, "key1", ""},
+	}
+	for _, o := range ops {
+		if o.op == sdbm.AuditStore {
+			if _, err := dbm.Store(sdbm.Datum(o.key), sdbm.Datum(o.val), sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+		} else {
+			if _, err := dbm.Delete(sdbm.Datum(o.key)); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+		}
+	}
+	// neither a missing key nor a SEEDUPS duplicate is a mutation.
+	if _, err := dbm.Delete(sdbm.Datum("key1")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := dbm.Store(sdbm.Datum("key2"), sdbm.Datum("x"), sdbm.StoreSEEDUPS); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	var got []sdbm.AuditEntry
+	scanner := bufio.NewScanner(&log)
+	for scanner.Scan() {
+		e, err := sdbm.ParseAuditEntry(scanner.Text())
+		if err != nil {
+			t.Fatalf("ParseAuditEntry() error = %v", err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != len(ops) {
+		t.Fatalf("audit entries got = %d, want %d", len(got), len(ops))
+	}
+	for i, o := range ops {
+		e := got[i]
+		if e.Op != o.op || e.Key.String() != o.key || e.ValLen != len(o.val) || e.Time.IsZero() {
+			t.Errorf("entry %d got = %+v, want op=%s key=%s vlen=%d", i, e, o.op, o.key, len(o.val))
+		}
+	}
+}
+
+func TestDBM_AuditLog_EncodedValues(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "InternValues", opts: sdbm.Options{InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log bytes.Buffer
+			opts := tt.opts
+			opts.Flags, opts.Mode, opts.AuditLog = os.O_RDWR|os.O_CREATE, 0644, &log
+			dbm, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, dbm)
+
+			// the lengths of the values, not of the references to them.
+			vals := []string{"hello", "a value longer than the overflow threshold"}
+			for _, v := range vals {
+				if _, err := dbm.Store(sdbm.Datum("key"), sdbm.Datum(v), sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			scanner := bufio.NewScanner(&log)
+			var got []int
+			for scanner.Scan() {
+				e, err := sdbm.ParseAuditEntry(scanner.Text())
+				if err != nil {
+					t.Fatalf("ParseAuditEntry() error = %v", err)
+				}
+				if e.Op != sdbm.AuditStore || e.Key.String() != "key" {
+					t.Errorf("audit entry got = %v %q, want store key", e.Op, e.Key)
+				}
+				got = append(got, e.ValLen)
+			}
+			if len(got) != len(vals) || got[0] != len(vals[0]) || got[1] != len(vals[1]) {
+				t.Errorf("audit value lengths got = %v, want %d and %d", got, len(vals[0]), len(vals[1]))
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package sdbm
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// WriteBatch stages Put and Delete operations to be applied to a database
+// together by Commit. A WriteBatch is obtained from DBM.NewWriteBatch and
+// can be reused after Reset.
+type WriteBatch struct {
+	db  *DBM
+	ops []batchOp
+}
+
+type batchOp struct {
+	key   Datum
+	val   Datum
+	del   bool
+	flags StoreFlags // how a put stores the pair, as passed to Store
+}
+
+// NewWriteBatch returns an empty WriteBatch for the database.
+func (db *DBM) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Put stages storing val under key, replacing any existing value as
+// StoreREPLACE does. The key and value are copied.
+func (b *WriteBatch) Put(key, val Datum) {
+	b.ops = append(b.ops, batchOp{key: cloneDatum(key), val: cloneDatum(val), flags: StoreREPLACE})
+}
+
+// Delete stages removing key. The key is copied.
+func (b *WriteBatch) Delete(key Datum) {
+	b.ops = append(b.ops, batchOp{key: cloneDatum(key), del: true})
+}
+
+// Len returns the number of staged operations.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset discards every staged operation so the batch can be reused.
+func (b *WriteBatch) Reset() {
+	clear(b.ops)
+	b.ops = b.ops[:0]
+}
+
+// Commit applies the staged operations and returns the number that changed
+// the database: every Put, and every Delete of a key that was present.
+//
+// The operations are sorted by the page they target, keeping the staged
+// order among operations on the same key, so that each page is read and
+// written once instead of once per operation. Every operation is validated
+// before any is applied. The staged operations are kept after Commit.
+// On a database opened with Options.InternValues, OverflowThreshold or WAL
+// the operations are applied one at a time by Store and Delete, which
+// encode the values and log the operations as those options require.
+func (b *WriteBatch) Commit() (int, error) {
+	db := b.db
+	if db.rdonly {
+		return 0, ErrDBMRDOnly
+	}
+	if db.blobs != nil || db.ovf != nil || db.wal != nil {
+		return b.commitEach()
+	}
+	return db.applyByPage(context.Background(), b.ops, func(op batchOp) (bool, error) {
+		if op.del {
+			return db.del(op.key)
+		}
+		return db.store(op.key, op.val, op.flags)
+	})
+}
+
+// commitEach is Commit applying the operations one at a time by Store and
+// Delete, after validating every key.
+func (b *WriteBatch) commitEach() (int, error) {
+	db := b.db
+	for _, op := range b.ops {
+		if err := db.checkKey(db.normKey(op.key)); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, op := range b.ops {
+		var ok bool
+		var err error
+		if op.del {
+			ok, err = db.Delete(op.key)
+		} else {
+			ok, err = db.Store(op.key, op.val, op.flags)
+		}
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// applyByPage validates ops, sorts them by the page they target, keeping
+// their order among operations on the same key, and applies each with
+// apply, so that each page is read and written once. It returns the number
+// of operations for which apply reported a change, once the pages are
+// written and, with Options.SyncOnWrite, synced. Once ctx is done it
+// stops before starting on the next page, writes the page it was on and
+// returns ctx.Err().
+// A split while applying moves some of the operations left for the page to
+// a new page; they are then regrouped so that each page split off during
+// the batch is also written once.
+func (db *DBM) applyByPage(ctx context.Context, ops []batchOp, apply func(op batchOp) (bool, error)) (int, error) {
+	type pending struct {
+		op   batchOp
+		hash int64
+		pagb int64
+	}
+	sorted := make([]pending, len(ops))
+	for i, op := range ops {
+		key := db.normKey(op.key)
+		if err := db.checkKey(key); err != nil {
+			return 0, err
+		}
+		if !op.del && key.Size()+op.val.Size() > db.PairMax() {
+			return 0, ErrValueTooBig
+		}
+		hash := db.exHash(key)
+		sorted[i] = pending{op: op, hash: hash, pagb: db.pageOf(hash)}
+	}
+	byPage := func(a, b pending) int {
+		return cmp.Compare(a.pagb, b.pagb)
+	}
+	slices.SortStableFunc(sorted, byPage)
+
+	n := 0
+	for i := range sorted {
+		if i == 0 || sorted[i].pagb != sorted[i-1].pagb {
+			if err := ctx.Err(); err != nil {
+				_ = db.flush()
+				return n, err
+			}
+		}
+		if pagb := db.pageOf(sorted[i].hash); pagb != sorted[i].pagb {
+			// the page was split: regroup what is left of its operations.
+			old, j := sorted[i].pagb, i
+			for ; j < len(sorted) && sorted[j].pagb == old; j++ {
+				sorted[j].pagb = db.pageOf(sorted[j].hash)
+			}
+			slices.SortStableFunc(sorted[i:j], byPage)
+		}
+		ok, err := apply(sorted[i].op)
+		if err != nil {
+			_ = db.flush()
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	if err := db.flush(); err != nil {
+		return n, err
+	}
+	if db.opts.SyncOnWrite {
+		return n, db.syncWritten()
+	}
+	return n, nil
+}
+
+// StoreMany stores pairs with flags, as Store does for each pair in turn,
+// and returns the number stored. Like WriteBatch.Commit, it groups the
+// pairs by page so each page is read and written once, which cuts the page
+// writes of a bulk load. Every pair is validated before any is stored; on
+// a later error the pairs already stored stay stored. On a database opened
+// with Options.InternValues, OverflowThreshold or WAL the pairs are stored
+// one at a time by Store.
+func (db *DBM) StoreMany(pairs []Pair, flags StoreFlags) (int, error) {
+	return db.StoreManyContext(context.Background(), pairs, flags)
+}
+
+// StoreManyContext is StoreMany returning ctx.Err() once ctx is done,
+// checked between pages, or between pairs when they are stored one at a
+// time. The pairs stored by then stay stored.
+func (db *DBM) StoreManyContext(ctx context.Context, pairs []Pair, flags StoreFlags) (int, error) {
+	if db.blobs != nil || db.ovf != nil || db.wal != nil {
+		n := 0
+		for _, p := range pairs {
+			if err := ctx.Err(); err != nil {
+				return n, err
+			}
+			ok, err := db.Store(p.Key, p.Val, flags)
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				n++
+			}
+		}
+		return n, nil
+	}
+
+	if db.rdonly {
+		return 0, ErrDBMRDOnly
+	}
+	ops := make([]batchOp, len(pairs))
+	for i, p := range pairs {
+		ops[i] = batchOp{key: p.Key, val: p.Val, flags: flags}
+	}
+	return db.applyByPage(ctx, ops, func(op batchOp) (bool, error) {
+		return db.store(op.key, op.val, op.flags)
+	})
+}
+
+// DeleteBatch removes keys and returns the number that were present. Like
+// WriteBatch.Commit, it groups the keys by page so each page is read and
+// written once, which is much faster than calling Delete for each key.
+// Absent keys are skipped. Every key is validated before any is removed.
+// On a database opened with Options.InternValues, OverflowThreshold or WAL
+// the keys are removed one at a time by Delete.
+func (db *DBM) DeleteBatch(keys []Datum) (int, error) {
+	if db.blobs != nil || db.ovf != nil || db.wal != nil {
+		n := 0
+		for _, key := range keys {
+			ok, err := db.Delete(key)
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				n++
+			}
+		}
+		return n, nil
+	}
+
+	b := &WriteBatch{db: db, ops: make([]batchOp, len(keys))}
+	for i, key := range keys {
+		b.ops[i] = batchOp{key: key, del: true}
+	}
+	return b.Commit()
+}
+
+// DeleteMany removes keys and returns the number that were present. It is
+// DeleteBatch under the name that pairs with StoreMany: the keys are grouped
+// by page so each page is written once, and absent keys are skipped.
+func (db *DBM) DeleteMany(keys []Datum) (int, error) {
+	return db.DeleteBatch(keys)
+}
@@ -0,0 +1,330 @@
+package sdbm_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestWriteBatch(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	b := dbm.NewWriteBatch()
+	for _, p := range generatePairs("new", "v", 500) {
+		b.Put(p.Key, p.Val)
+	}
+	b.Put(sdbm.Datum("tmp"), sdbm.Datum("tmp"))
+	b.Delete(sdbm.Datum("tmp"))
+	b.Delete(sdbm.Datum("key1"))
+	b.Delete(sdbm.Datum("missing"))
+	if b.Len() != 504 {
+		t.Errorf("Len() got = %d, want %d", b.Len(), 504)
+	}
+
+	n, err := b.Commit()
+	if err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if n != 503 {
+		t.Errorf("Commit() got = %d, want %d", n, 503)
+	}
+
+	for _, key := range []string{"tmp", "key1"} {
+		got, err := dbm.Fetch(sdbm.Datum(key))
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, sdbm.Nullitem) {
+			t.Errorf("Fetch(%s) got = %v, want %v", key, got, sdbm.Nullitem)
+		}
+	}
+	for _, p := range generatePairs("new", "v", 500) {
+		got, err := dbm.Fetch(p.Key)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, p.Val) {
+			t.Errorf("Fetch(%s) got = %v, want %v", p.Key, got, p.Val)
+		}
+	}
+
+	b.Reset()
+	if b.Len() != 0 {
+		t.Errorf("Len() after Reset got = %d, want %d", b.Len(), 0)
+	}
+	b.Put(sdbm.Datum("key2"), sdbm.Datum("replaced"))
+	if n, err := b.Commit(); err != nil || n != 1 {
+		t.Fatalf("Commit() got = %d, %v, want 1", n, err)
+	}
+	got, err := dbm.Fetch(sdbm.Datum("key2"))
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.String() != "replaced" {
+		t.Errorf("Fetch() got = %v, want %v", got, "replaced")
+	}
+}
+
+func TestWriteBatch_EncodedValues(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "InternValues", opts: sdbm.Options{InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+		{name: "WAL", opts: sdbm.Options{WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			pairs := append(generatePairs("key", "a value longer than the threshold", 300), Pair{Key: sdbm.Datum("k"), Val: sdbm.Datum("hello")})
+			b := db.NewWriteBatch()
+			for _, p := range pairs {
+				b.Put(p.Key, p.Val)
+			}
+			b.Delete(sdbm.Datum("key1"))
+			if n, err := b.Commit(); err != nil || n != len(pairs)+1 {
+				t.Fatalf("Commit() got = %d, %v, want %d", n, err, len(pairs)+1)
+			}
+			for _, p := range pairs[1:] {
+				if got, err := db.Fetch(p.Key); err != nil || got.String() != p.Val.String() {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+			if got, err := db.Fetch(sdbm.Datum("key1")); err != nil || got != nil {
+				t.Errorf("Fetch(key1) got = %q, %v, want nil", got, err)
+			}
+
+			// an invalid key rejects the whole batch.
+			b.Reset()
+			b.Put(sdbm.Datum("k"), sdbm.Datum("changed"))
+			b.Put(nil, sdbm.Datum("val"))
+			if _, err := b.Commit(); !errors.Is(err, sdbm.ErrInvalidArgument) {
+				t.Errorf("Commit() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+			}
+			if got, err := db.Fetch(sdbm.Datum("k")); err != nil || got.String() != "hello" {
+				t.Errorf("Fetch(k) after a rejected Commit() got = %q, %v, want hello", got, err)
+			}
+		})
+	}
+}
+
+func TestWriteBatch_SyncOnWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, SyncOnWrite: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	dirSyncs, pagSyncs := sdbm.CountSyncs(db)
+
+	b := db.NewWriteBatch()
+	for _, p := range generatePairs("key", "val", 10) {
+		b.Put(p.Key, p.Val)
+	}
+	if _, err := b.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if *dirSyncs != 0 || *pagSyncs != 1 {
+		t.Errorf("syncs after Commit() got = %d/%d, want 0/1", *dirSyncs, *pagSyncs)
+	}
+
+	// enough pairs to split pages, which writes the directory.
+	pairs := generatePairs("many", "val", 1000)
+	batch := make([]sdbm.Pair, len(pairs))
+	for i, p := range pairs {
+		batch[i] = sdbm.Pair{Key: p.Key, Val: p.Val}
+	}
+	if _, err := db.StoreMany(batch, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("StoreMany() error = %v", err)
+	}
+	if *dirSyncs != 1 || *pagSyncs != 2 {
+		t.Errorf("syncs after StoreMany() got = %d/%d, want 1/2", *dirSyncs, *pagSyncs)
+	}
+}
+
+func TestDBM_DeleteBatch(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	var keys []sdbm.Datum
+	for i, p := range pairs {
+		if i%2 == 0 {
+			keys = append(keys, p.Key)
+		}
+	}
+	keys = append(keys, sdbm.Datum("missing"), keys[0])
+
+	n, err := dbm.DeleteBatch(keys)
+	if err != nil {
+		t.Fatalf("DeleteBatch() error = %v", err)
+	}
+	if n != len(pairs)/2 {
+		t.Errorf("DeleteBatch() got = %d, want %d", n, len(pairs)/2)
+	}
+	for i, p := range pairs {
+		want := p.Val
+		if i%2 == 0 {
+			want = sdbm.Nullitem
+		}
+		got, err := dbm.Fetch(p.Key)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Fetch(%s) got = %v, want %v", p.Key, got, want)
+		}
+	}
+
+	if _, err := dbm.DeleteBatch([]sdbm.Datum{pairs[1].Key, nil}); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("DeleteBatch() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+	if got, _ := dbm.Fetch(pairs[1].Key); !reflect.DeepEqual(got, pairs[1].Val) {
+		t.Errorf("Fetch(%s) after a rejected DeleteBatch got = %v, want %v", pairs[1].Key, got, pairs[1].Val)
+	}
+}
+
+func TestDBM_StoreMany(t *testing.T) {
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+
+	pairs := generatePairs("key", "val", 3000)
+	batch := make([]sdbm.Pair, len(pairs))
+	for i, p := range pairs {
+		batch[i] = sdbm.Pair{Key: p.Key, Val: p.Val}
+	}
+	writes := sdbm.CountPagWrites(dbm)
+	n, err := dbm.StoreMany(batch, sdbm.StoreREPLACE)
+	if err != nil {
+		t.Fatalf("StoreMany() error = %v", err)
+	}
+	if n != len(pairs) {
+		t.Errorf("StoreMany() got = %d, want %d", n, len(pairs))
+	}
+	if *writes >= len(pairs)/5 {
+		t.Errorf("StoreMany() page writes got = %d, want far fewer than %d", *writes, len(pairs))
+	}
+	for _, p := range pairs {
+		if got, err := dbm.Fetch(p.Key); err != nil || !reflect.DeepEqual(got, p.Val) {
+			t.Fatalf("Fetch(%s) got = %v, %v, want %v", p.Key, got, err, p.Val)
+		}
+	}
+
+	// StoreSEEDUPS keeps the existing values.
+	n, err = dbm.StoreMany([]sdbm.Pair{
+		{Key: sdbm.Datum("key1"), Val: sdbm.Datum("changed")},
+		{Key: sdbm.Datum("new"), Val: sdbm.Datum("val")},
+	}, sdbm.StoreSEEDUPS)
+	if err != nil || n != 2 {
+		t.Errorf("StoreMany(StoreSEEDUPS) got = %d, %v, want 2, nil", n, err)
+	}
+	if got, _ := dbm.Fetch(sdbm.Datum("key1")); got.String() != "val1" {
+		t.Errorf("Fetch(key1) got = %q, want val1", got)
+	}
+	if got, _ := dbm.Fetch(sdbm.Datum("new")); got.String() != "val" {
+		t.Errorf("Fetch(new) got = %q, want val", got)
+	}
+
+	// an invalid pair rejects the whole call.
+	_, err = dbm.StoreMany([]sdbm.Pair{
+		{Key: sdbm.Datum("key2"), Val: sdbm.Datum("changed")},
+		{Key: sdbm.Datum("big"), Val: make(sdbm.Datum, sdbm.PAIRMAX)},
+	}, sdbm.StoreREPLACE)
+	if !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("StoreMany() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+	if got, _ := dbm.Fetch(sdbm.Datum("key2")); got.String() != "val2" {
+		t.Errorf("Fetch(key2) after a rejected StoreMany got = %q, want val2", got)
+	}
+}
+
+func TestDBM_StoreManyContext(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "by page"},
+		{name: "one at a time", opts: sdbm.Options{InternValues: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			pairs := generatePairs("key", "val", 1000)
+			batch := make([]sdbm.Pair, len(pairs))
+			for i, p := range pairs {
+				batch[i] = sdbm.Pair{Key: p.Key, Val: p.Val}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			n, err := db.StoreManyContext(ctx, batch, sdbm.StoreREPLACE)
+			if !errors.Is(err, context.Canceled) || n != 0 {
+				t.Errorf("StoreManyContext() canceled got = %d, %v, want 0, %v", n, err, context.Canceled)
+			}
+			if got, err := db.Count(); err != nil || got != 0 {
+				t.Errorf("Count() after a canceled StoreManyContext() got = %d, %v, want 0", got, err)
+			}
+
+			n, err = db.StoreManyContext(context.Background(), batch, sdbm.StoreREPLACE)
+			if err != nil || n != len(batch) {
+				t.Errorf("StoreManyContext() got = %d, %v, want %d", n, err, len(batch))
+			}
+		})
+	}
+}
+
+func TestDBM_DeleteMany(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	dir, dbm := setup(t, pairs...)
+
+	keys := []sdbm.Datum{sdbm.Datum("missing")}
+	for _, p := range pairs[:500] {
+		keys = append(keys, p.Key)
+	}
+	writes := sdbm.CountPagWrites(dbm)
+	n, err := dbm.DeleteMany(keys)
+	if err != nil {
+		t.Fatalf("DeleteMany() error = %v", err)
+	}
+	if n != 500 {
+		t.Errorf("DeleteMany() got = %d, want 500", n)
+	}
+	if *writes >= 500/5 {
+		t.Errorf("DeleteMany() page writes got = %d, want far fewer than 500", *writes)
+	}
+	if count, _ := dbm.Count(); count != 500 {
+		t.Errorf("Count() after DeleteMany() got = %d, want 500", count)
+	}
+	teardown(t, dbm)
+
+	ro, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, ro)
+	if _, err := ro.DeleteMany(keys[1:]); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("DeleteMany() on a read-only database error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+}
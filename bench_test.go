@@ -0,0 +1,231 @@
+package sdbm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func BenchmarkDBM_ForEachKey(b *testing.B) {
+	_, dbm := setup(b, generatePairs("key", "val", 10000)...)
+	defer teardown(b, dbm)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := dbm.ForEachKey(func(sdbm.Datum) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDBM_ForEach(b *testing.B) {
+	_, dbm := setup(b, generatePairs("key", "val", 10000)...)
+	defer teardown(b, dbm)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := dbm.ForEach(func(sdbm.Datum, sdbm.Datum) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDBM_FirstKeyNextKey(b *testing.B) {
+	_, dbm := setup(b, generatePairs("key", "val", 10000)...)
+	defer teardown(b, dbm)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key, err := dbm.FirstKey()
+		for ; key != nil && err == nil; key, err = dbm.NextKey() {
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkCursor(b *testing.B, next func(c *sdbm.Cursor) (sdbm.Datum, sdbm.Datum, bool, error)) {
+	_, dbm := setup(b, generatePairs("key", "val", 10000)...)
+	defer teardown(b, dbm)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := dbm.NewCursor()
+		for {
+			_, _, ok, err := next(c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+		}
+		c.Close()
+	}
+}
+
+func BenchmarkCursor_Next(b *testing.B) {
+	benchmarkCursor(b, (*sdbm.Cursor).Next)
+}
+
+func BenchmarkCursor_NextReuse(b *testing.B) {
+	benchmarkCursor(b, (*sdbm.Cursor).NextReuse)
+}
+
+func benchmarkDelete(b *testing.B, del func(dbm *sdbm.DBM, keys []sdbm.Datum) error) {
+	pairs := generatePairs("key", "val", 2000)
+	keys := make([]sdbm.Datum, len(pairs))
+	for i, p := range pairs {
+		keys[i] = p.Key
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		_, dbm := setup(b, pairs...)
+		b.StartTimer()
+		if err := del(dbm, keys); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		teardown(b, dbm)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkDBM_DeleteBatch(b *testing.B) {
+	benchmarkDelete(b, func(dbm *sdbm.DBM, keys []sdbm.Datum) error {
+		_, err := dbm.DeleteBatch(keys)
+		return err
+	})
+}
+
+func BenchmarkDBM_Delete(b *testing.B) {
+	benchmarkDelete(b, func(dbm *sdbm.DBM, keys []sdbm.Datum) error {
+		for _, key := range keys {
+			if _, err := dbm.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func benchmarkStore(b *testing.B, opts sdbm.Options) {
+	dir := b.TempDir()
+	opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+	dbm, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer teardown(b, dbm)
+
+	pairs := generatePairs("key", "val", 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := pairs[i%len(pairs)]
+		if _, err := dbm.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDBM_Store(b *testing.B) {
+	benchmarkStore(b, sdbm.Options{})
+}
+
+func BenchmarkDBM_Store_SyncOnWrite(b *testing.B) {
+	benchmarkStore(b, sdbm.Options{SyncOnWrite: true})
+}
+
+// BenchmarkDBM_Store_SplitHeavy stores b.N new keys, numbered in
+// increasing order, in an empty database, so that the pages keep filling up
+// and splitting as the trie grows.
+func BenchmarkDBM_Store_SplitHeavy(b *testing.B) {
+	_, dbm := setup(b)
+	defer teardown(b, dbm)
+
+	val := sdbm.Datum("a value of some thirty-two bytes")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := sdbm.Datum(fmt.Sprintf("key%012d", i))
+		if _, err := dbm.Store(key, val, sdbm.StoreREPLACE); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDBM_StoreMany(b *testing.B) {
+	pairs := generatePairs("key", "val", 10000)
+	batch := make([]sdbm.Pair, len(pairs))
+	for i, p := range pairs {
+		batch[i] = sdbm.Pair{Key: p.Key, Val: p.Val}
+	}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		_, dbm := setup(b)
+		b.StartTimer()
+		if _, err := dbm.StoreMany(batch, sdbm.StoreREPLACE); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		teardown(b, dbm)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkDBM_Fetch(b *testing.B) {
+	pairs := generatePairs("key", "val", 10000)
+	_, dbm := setup(b, pairs...)
+	defer teardown(b, dbm)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := pairs[(i*7919)%len(pairs)]
+		if _, err := dbm.Fetch(p.Key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkFetchHot fetches a hot set of 1000 of the 100000 keys, which
+// live on up to 1000 pages, and reports the .pag reads per fetch.
+func benchmarkFetchHot(b *testing.B, cacheSize int) {
+	pairs := generatePairs("key", "val", 100000)
+	dir, dbm := setup(b, pairs...)
+	teardown(b, dbm)
+
+	dbm, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDWR, Mode: 0644, PageCacheSize: cacheSize})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer teardown(b, dbm)
+	hot := pairs[:1000]
+	reads := sdbm.CountPagReads(dbm)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dbm.Fetch(hot[(i*7919)%len(hot)].Key); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(*reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkDBM_Fetch_Hot(b *testing.B) {
+	benchmarkFetchHot(b, 0)
+}
+
+func BenchmarkDBM_Fetch_Hot_PageCache(b *testing.B) {
+	benchmarkFetchHot(b, 1024)
+}
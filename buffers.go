@@ -0,0 +1,62 @@
+package sdbm
+
+import "sync"
+
+// The pools hold buffers of the default block sizes only.
+var (
+	pagePool = sync.Pool{New: func() any { return newPage(PBLKSIZ) }}
+	dirPool  = sync.Pool{New: func() any { return new([DBLKSIZ]byte) }}
+)
+
+// acquireBuffers makes sure the page and directory block buffers are
+// allocated, taking them from the shared pools if they were released.
+// A reacquired buffer holds no cached block.
+func (db *DBM) acquireBuffers() {
+	// buffers kept by Reset from files with another format are dropped.
+	if db.pag != nil && (len(db.pag.buf) != db.format.PageSize || db.pag.be != db.bigEndian()) {
+		db.pag = nil
+	}
+	if db.dirbuf != nil && len(db.dirbuf) != db.format.DirBlockSize {
+		db.dirbuf = nil
+	}
+	if db.pag == nil {
+		if db.format.PageSize == PBLKSIZ {
+			db.pag = pagePool.Get().(*Page)
+			clear(db.pag.buf)
+			db.pag.be = db.bigEndian()
+		} else {
+			db.pag = db.newPage()
+		}
+		db.pagbno = -1
+	}
+	if db.dirbuf == nil {
+		if db.format.DirBlockSize == DBLKSIZ {
+			db.dirbuf = dirPool.Get().(*[DBLKSIZ]byte)[:]
+			clear(db.dirbuf)
+		} else {
+			db.dirbuf = make([]byte, db.format.DirBlockSize)
+		}
+		db.dirbno = -1
+	}
+}
+
+// Release returns the page and directory block buffers of the database to a
+// shared pool, leaving the handle with a small footprint while it is idle.
+// The buffers are reacquired transparently by the next call that needs
+// them, at the cost of rereading the blocks they cached. Release abandons
+// an iteration in progress; restart it with FirstKey.
+func (db *DBM) Release() {
+	if db.pag != nil {
+		if len(db.pag.buf) == PBLKSIZ {
+			pagePool.Put(db.pag)
+		}
+		db.pag = nil
+	}
+	if db.dirbuf != nil {
+		if len(db.dirbuf) == DBLKSIZ {
+			dirPool.Put((*[DBLKSIZ]byte)(db.dirbuf))
+		}
+		db.dirbuf = nil
+	}
+	db.iter = nil
+}
@@ -0,0 +1,51 @@
+package sdbm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Release(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	teardown(t, dbm)
+	path := filepath.Join(dir, DBMFile)
+
+	handles := make([]*sdbm.DBM, 100)
+	for i := range handles {
+		db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDONLY, LazyBuffers: true})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		defer teardown(t, db)
+		if sdbm.BuffersHeld(db) {
+			t.Fatal("BuffersHeld() after open got = true, want false")
+		}
+		handles[i] = db
+	}
+
+	for round := 0; round < 2; round++ {
+		for i, db := range handles {
+			key := strconv.Itoa((i*7+round)%1000 + 1)
+			got, err := db.Fetch(sdbm.Datum("key" + key))
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if got.String() != "val"+key {
+				t.Fatalf("Fetch() got = %v, want %v", got, "val"+key)
+			}
+			if !sdbm.BuffersHeld(db) {
+				t.Fatal("BuffersHeld() after Fetch got = false, want true")
+			}
+		}
+		for _, db := range handles {
+			db.Release()
+			if sdbm.BuffersHeld(db) {
+				t.Fatal("BuffersHeld() after Release got = true, want false")
+			}
+		}
+	}
+}
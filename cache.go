@@ -0,0 +1,61 @@
+package sdbm
+
+import "container/list"
+
+// pageCache keeps copies of the most recently used pages for
+// Options.PageCacheSize, in addition to the page held in the page buffer.
+// Every page write goes through writePage, which updates the copy, so the
+// cache never holds a page older than the file.
+type pageCache struct {
+	size  int
+	pages map[int64]*list.Element // page number -> *cachedPage in lru
+	lru   list.List               // most recently used first
+}
+
+type cachedPage struct {
+	pagb int64
+	page Page
+}
+
+func newPageCache(size int) *pageCache {
+	return &pageCache{size: size, pages: make(map[int64]*list.Element, size)}
+}
+
+// get copies page pagb into p and reports whether it was cached.
+func (c *pageCache) get(pagb int64, p *Page) bool {
+	e, ok := c.pages[pagb]
+	if !ok {
+		return false
+	}
+	c.lru.MoveToFront(e)
+	copy(p.buf, e.Value.(*cachedPage).page.buf)
+	return true
+}
+
+// put stores a copy of p as page pagb, evicting the least recently used
+// page if the cache is full.
+func (c *pageCache) put(pagb int64, p *Page) {
+	if e, ok := c.pages[pagb]; ok {
+		c.lru.MoveToFront(e)
+		copy(e.Value.(*cachedPage).page.buf, p.buf)
+		return
+	}
+	var cp *cachedPage
+	if c.lru.Len() >= c.size {
+		// reuse the evicted entry.
+		e := c.lru.Back()
+		cp = c.lru.Remove(e).(*cachedPage)
+		delete(c.pages, cp.pagb)
+	} else {
+		cp = &cachedPage{page: Page{buf: make([]byte, len(p.buf))}}
+	}
+	cp.pagb = pagb
+	copy(cp.page.buf, p.buf)
+	c.pages[pagb] = c.lru.PushFront(cp)
+}
+
+// reset drops every cached page.
+func (c *pageCache) reset() {
+	clear(c.pages)
+	c.lru.Init()
+}
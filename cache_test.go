@@ -0,0 +1,88 @@
+package sdbm_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestOpenWithOptions_PageCacheSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, PageCacheSize: 1024})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+
+	// enough pairs to split pages many times while they are cached.
+	pairs := generatePairs("key", "val", 5000)
+	for _, p := range pairs {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	reads := sdbm.CountPagReads(db)
+	for round := 0; round < 2; round++ {
+		for _, p := range pairs {
+			got, err := db.Fetch(p.Key)
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, p.Val) {
+				t.Fatalf("Fetch(%s) got = %q, want %q", p.Key, got, p.Val)
+			}
+		}
+	}
+	if *reads != 0 {
+		t.Errorf("Fetch() of cached pages read the .pag file %d times, want 0", *reads)
+	}
+
+	// writes update the cached pages.
+	for i, p := range pairs {
+		var err error
+		if i%2 == 0 {
+			_, err = db.Store(p.Key, sdbm.Datum("changed"), sdbm.StoreREPLACE)
+		} else {
+			_, err = db.Delete(p.Key)
+		}
+		if err != nil {
+			t.Fatalf("write error = %v", err)
+		}
+	}
+	for i, p := range pairs {
+		var want sdbm.Datum
+		if i%2 == 0 {
+			want = sdbm.Datum("changed")
+		}
+		got, err := db.Fetch(p.Key)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Fetch(%s) after a write got = %q, want %q", p.Key, got, want)
+		}
+	}
+}
+
+func TestOpenWithOptions_PageCacheSize_Eviction(t *testing.T) {
+	pairs := generatePairs("key", "val", 5000)
+	dir, dbm := setup(t, pairs...)
+	teardown(t, dbm)
+
+	db, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDWR, Mode: 0644, PageCacheSize: 4})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	for round := 0; round < 2; round++ {
+		for _, p := range pairs {
+			if got, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(got, p.Val) {
+				t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sdbm
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+ * page checksum:
+ *
+ * In a database with FeatureChecksums, every page written carries a CRC-32
+ * (IEEE) in the four bytes that follow its offset table:
+ *
+ *      +---+--------+--------+-----+-----+-------------------
+ * ino  | n | keyOff | datOff | ... | crc | F R E E A R E A ...
+ *      +---+--------+--------+-----+-----+-------------------
+ *
+ * The checksum covers the whole block but itself. It moves as the table
+ * grows, so Store keeps crcSize more bytes free in every page. A page that
+ * was never written reads as zeros and needs no checksum.
+ */
+const crcSize = 4
+
+// ErrChecksumMismatch indicates that a page read from the .pag file does
+// not match its checksum. The error returned wraps it with the page number.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// crcOff returns the offset of the checksum of p, and false if the offset
+// table leaves no room for it.
+func (p *Page) crcOff() (int, bool) {
+	off := (int(p.getN()) + 1) * SHORTSIZE
+	return off, off+crcSize <= len(p.buf)
+}
+
+// sum returns the checksum of p, skipping the checksum itself at off.
+func (p *Page) sum(off int) uint32 {
+	crc := crc32.ChecksumIEEE(p.buf[:off])
+	return crc32.Update(crc, crc32.IEEETable, p.buf[off+crcSize:])
+}
+
+// seal stores the checksum of p in it.
+func (p *Page) seal() {
+	if off, ok := p.crcOff(); ok {
+		p.order().PutUint32(p.buf[off:], p.sum(off))
+	}
+}
+
+// sealed reports whether p matches its checksum, or is all zeros.
+func (p *Page) sealed() bool {
+	if off, ok := p.crcOff(); ok && p.order().Uint32(p.buf[off:]) == p.sum(off) {
+		return true
+	}
+	for _, b := range p.buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checksums reports whether the pages of the database carry checksums.
+func (db *DBM) checksums() bool {
+	return db.format.Features&FeatureChecksums != 0
+}
+
+// checkPage validates page pagb, just read into p: its checksum, if the
+// database has them, and then its offsets, naming the page in the error. A
+// page whose offsets only make sense in the other byte order fails with
+// ErrByteOrder as well.
+func (db *DBM) checkPage(pagb int64, p *Page) error {
+	if db.checksums() && !p.sealed() {
+		return fmt.Errorf("%w: page %d", ErrChecksumMismatch, pagb)
+	}
+	if err := p.check(); err != nil {
+		if swapped := (&Page{buf: p.buf, be: !p.be}); swapped.getN() > 0 && swapped.ChkPage() {
+			return fmt.Errorf("page %d: %w (%w)", pagb, err, ErrByteOrder)
+		}
+		return fmt.Errorf("page %d: %w", pagb, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package sdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestOpenWithOptions_Checksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, Checksums: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	pairs := generatePairs("key", "val", 2000)
+	for _, p := range pairs {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, p := range pairs[:1000] {
+		if _, err := db.Delete(p.Key); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	// a pair of PAIRMAX bytes still fits in a page.
+	big := sdbm.Datum(strings.Repeat("v", sdbm.PAIRMAX-3))
+	if _, err := db.Store(sdbm.Datum("big"), big, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() of a PAIRMAX pair error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the checksums are verified without the option.
+	db, err = sdbm.Open(path, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	info, err := db.FormatInfo()
+	if err != nil {
+		t.Fatalf("FormatInfo() error = %v", err)
+	}
+	if info.Features&sdbm.FeatureChecksums == 0 {
+		t.Errorf("FormatInfo().Features got = %#x, want FeatureChecksums set", info.Features)
+	}
+	for _, p := range pairs[1000:] {
+		if val, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(val, p.Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+		}
+	}
+	if val, err := db.Fetch(sdbm.Datum("big")); err != nil || !reflect.DeepEqual(val, big) {
+		t.Fatalf("Fetch(big) got %d bytes, %v, want %d bytes", len(val), err, len(big))
+	}
+	n := 0
+	if err := db.ForEach(func(key, val sdbm.Datum) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach() error = %v", err)
+	}
+	if n != 1001 {
+		t.Errorf("ForEach() visited %d pairs, want 1001", n)
+	}
+}
+
+func TestDBM_Fetch_ChecksumMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, Checksums: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit of the value, at the end of page 0, behind the header.
+	f, err := os.OpenFile(path+sdbm.PAGFEXT, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b [1]byte
+	off := int64(2*sdbm.PBLKSIZ - len("key") - 1)
+	if _, err := f.ReadAt(b[:], off); err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != 'l' {
+		t.Fatalf("byte at %d got = %q, want 'l'", off, b[0])
+	}
+	b[0] ^= 0x01
+	if _, err := f.WriteAt(b[:], off); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = sdbm.Open(path, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	_, err = db.Fetch(sdbm.Datum("key"))
+	if !errors.Is(err, sdbm.ErrChecksumMismatch) {
+		t.Fatalf("Fetch() error = %v, want ErrChecksumMismatch", err)
+	}
+	if !strings.Contains(err.Error(), "page 0") {
+		t.Errorf("Fetch() error = %q, want it to name page 0", err)
+	}
+	if _, err := db.FirstKey(); !errors.Is(err, sdbm.ErrChecksumMismatch) {
+		t.Errorf("FirstKey() error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestOpenWithOptions_Checksums_ExistingDatabase(t *testing.T) {
+	dir, db := setup(t, generatePairs("key", "val", 100)...)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a database created without checksums opens as it was.
+	path := filepath.Join(dir, DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR, Checksums: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	info, err := db.FormatInfo()
+	if err != nil {
+		t.Fatalf("FormatInfo() error = %v", err)
+	}
+	if info.Version != 0 || info.Features != 0 {
+		t.Errorf("FormatInfo() got = %+v, want a headerless database", info)
+	}
+	for _, p := range generatePairs("key", "val", 100) {
+		if val, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(val, p.Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sdbm
+
+import "fmt"
+
+// Compact merges sibling pages of the trie back into one page wherever
+// their pairs fit together, undoing the splits that deletions have made
+// unnecessary, and truncates the .pag file after the last page still in
+// use. Unlike Reorganize it works in place and only rewrites the pages it
+// merges, but it neither refills pages that have no sibling to merge with
+// nor shrinks the .dir file.
+//
+// Each merge writes the merged page before clearing the directory bit of
+// the split, so that a crash in between leaves every pair reachable.
+// Compact abandons an iteration in progress; restart it with FirstKey. It
+// fails with ErrDBMRDOnly on a read-only database and with
+// ErrInvalidArgument while a Snapshot of the database is open.
+func (db *DBM) Compact() error {
+	if db.rdonly {
+		return ErrDBMRDOnly
+	}
+	db.cow.mu.Lock()
+	n := len(db.cow.snaps)
+	db.cow.mu.Unlock()
+	if n > 0 {
+		return fmt.Errorf("%w: %d snapshots open", ErrInvalidArgument, n)
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+
+	_, last, err := db.compact(0, 0, 0)
+	// the page in memory may have been merged away; the next operation
+	// walks the trie again to find its page.
+	db.pagbno = -1
+	db.blkptr = 0
+	db.keyptr = 0
+	db.iter = nil
+	if err != nil {
+		return err
+	}
+
+	size, err := db.pagf.Size()
+	if err != nil {
+		return wrapIOErr("stat", db.pagf.Name(), err)
+	}
+	if end := db.offPag(last + 1); size > end {
+		if err := db.pagf.Truncate(end); err != nil {
+			return wrapIOErr("truncate", db.pagf.Name(), err)
+		}
+		db.pagSync = true
+	}
+	if db.opts.SyncOnWrite {
+		return db.syncWritten()
+	}
+	return nil
+}
+
+// compact merges the subtree of the trie below directory bit dbit, whose
+// pages hold the hashes equal to pagb in their low hbit bits. It reports
+// whether the subtree is a single page afterwards, and the highest number
+// of its pages.
+func (db *DBM) compact(dbit, hbit, pagb int64) (leaf bool, last int64, err error) {
+	if dbit >= db.maxbno || hbit >= maxDepth || !db.getDBit(dbit) {
+		return true, pagb, nil
+	}
+
+	sibling := pagb | 1<<hbit
+	lleaf, llast, err := db.compact(2*dbit+1, hbit+1, pagb)
+	if err != nil {
+		return false, 0, err
+	}
+	rleaf, rlast, err := db.compact(2*dbit+2, hbit+1, sibling)
+	if err != nil {
+		return false, 0, err
+	}
+	last = max(llast, rlast)
+	if !lleaf || !rleaf {
+		return false, last, nil
+	}
+
+	merged, err := db.merge(dbit, pagb, sibling)
+	if err != nil || !merged {
+		return false, last, err
+	}
+	return true, pagb, nil
+}
+
+// merge moves the pairs of page sibling into page pagb, the two pages the
+// split recorded by directory bit dbit made, if they fit, and clears the
+// bit. It reports whether the pages were merged.
+func (db *DBM) merge(dbit, pagb, sibling int64) (bool, error) {
+	read := func(pg int64, p *Page) error {
+		if _, err := db.readPage(pg, p); err != nil {
+			return err
+		}
+		return db.checkPage(pg, p)
+	}
+	p, q := db.newPage(), db.newPage()
+	if err := read(pagb, p); err != nil {
+		return false, err
+	}
+	if err := read(sibling, q); err != nil {
+		return false, err
+	}
+
+	extra := 0
+	if db.checksums() {
+		extra = crcSize
+	}
+	fits := true
+	q.forEachPair(func(key, val Datum) bool {
+		if !p.FitPair(key.Size() + val.Size() + extra) {
+			fits = false
+			return false
+		}
+		p.PutPair(key, val)
+		return true
+	})
+	if !fits {
+		return false, nil
+	}
+
+	if err := db.writePage(pagb, p); err != nil {
+		return false, err
+	}
+	if err := db.clearDBit(dbit); err != nil {
+		return false, err
+	}
+	if db.opts.Logger != nil {
+		db.opts.Logger.Printf("merge page %d into %d", sibling, pagb)
+	}
+	return true, db.writePage(sibling, db.newPage())
+}
@@ -0,0 +1,120 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Compact(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+		keep int // keep every keep-th pair
+	}{
+		{name: "plain", keep: 50},
+		{name: "Checksums", opts: sdbm.Options{Checksums: true}, keep: 50},
+		{name: "PageSize", opts: sdbm.Options{PageSize: 4096}, keep: 50},
+		{name: "everything deleted", keep: 0},
+		{name: "nothing deleted", keep: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			pairs := generatePairs("key", "a longer value", 3000)
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			var kept []Pair
+			for i, p := range pairs {
+				if tt.keep > 0 && i%tt.keep == 0 {
+					kept = append(kept, p)
+					continue
+				}
+				if _, err := db.Delete(p.Key); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+			}
+			before, err := db.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact() error = %v", err)
+			}
+			after, err := db.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.keep == 1 {
+				if after.PageCount != before.PageCount {
+					t.Errorf("Compact() page count got = %d, want %d", after.PageCount, before.PageCount)
+				}
+			} else if after.PageCount >= before.PageCount/4 {
+				t.Errorf("Compact() page count got = %d, want less than a quarter of %d", after.PageCount, before.PageCount)
+			}
+			if after.PairCount != int64(len(kept)) {
+				t.Errorf("Compact() pair count got = %d, want %d", after.PairCount, len(kept))
+			}
+			if err := db.Verify(); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			for _, p := range kept {
+				if got, err := db.Fetch(p.Key); err != nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+
+			// the trie splits again as it refills.
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() after Compact() error = %v", err)
+				}
+			}
+			for _, p := range pairs {
+				if got, err := db.Fetch(p.Key); err != nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%s) after refill got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+		})
+	}
+}
+
+func TestDBM_Compact_Errors(t *testing.T) {
+	dir, db := setup(t, generatePairs("key", "val", 1000)...)
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := db.Compact(); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Compact() with a snapshot open error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	teardown(t, db)
+
+	db, err = sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	if err := db.Compact(); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Compact() on a read-only db error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+}
@@ -0,0 +1,113 @@
+package sdbm
+
+import "errors"
+
+// ErrCursorClosed is returned by Next on a closed Cursor.
+var ErrCursorClosed = errors.New("cursor closed")
+
+// Cursor is a scan over the pairs of a database, in page order, with its
+// own position and page buffer. Unlike FirstKey and NextKey, which share a
+// single position held by the database, any number of cursors may be in
+// flight at once without disturbing each other or the shared position.
+// A cursor reads the pages as they are on disk, so pairs stored or deleted
+// while it is open may or may not be seen. Like the database, a cursor must
+// not be used by more than one goroutine at a time.
+type Cursor struct {
+	db     *DBM
+	pag    *Page // page buffer, nil once closed
+	pagbno int64 // page to read, or the page in pag
+	loaded bool  // pag holds page pagbno
+	keyptr int   // number of pairs of pag already returned
+	key    Datum // buffers of NextReuse
+	val    Datum
+}
+
+// NewCursor returns a cursor positioned before the first pair of the database.
+func (db *DBM) NewCursor() *Cursor {
+	return &Cursor{db: db, pag: db.newPage()}
+}
+
+// Seek positions the cursor at the start of the page that holds key, or
+// would hold it, so that the next call to Next returns the first pair of
+// that page. Pages are in hash order rather than key order, so this resumes
+// a scan near key: the pairs on earlier pages are skipped, and key itself,
+// if present, is returned by one of the following calls.
+func (c *Cursor) Seek(key Datum) {
+	hash := c.db.exHash(c.db.normKey(key))
+	_, hmask := c.db.lookup(hash)
+	c.pagbno = hash & hmask
+	c.loaded = false
+	c.keyptr = 0
+}
+
+// Next returns copies of the next key and value and true, or false once
+// the scan has passed the last page. A failed page read, or a page that
+// fails ChkPage or its checksum, stops the scan with an error.
+func (c *Cursor) Next() (Datum, Datum, bool, error) {
+	key, val, ok, err := c.next()
+	if !ok {
+		return Nullitem, Nullitem, ok, err
+	}
+	return cloneDatum(key), cloneDatum(val), true, nil
+}
+
+// NextReuse is Next without the allocation of a copy of every pair: the key
+// and value are copied into two buffers of the cursor, grown as needed and
+// reused by the next call. They are only valid until the next call to
+// NextReuse, Next, Seek or Close on the cursor, so a caller keeping a pair
+// past that must copy it. A full scan with NextReuse allocates only as the
+// buffers grow.
+func (c *Cursor) NextReuse() (Datum, Datum, bool, error) {
+	key, val, ok, err := c.next()
+	if !ok {
+		return Nullitem, Nullitem, ok, err
+	}
+	if c.key == nil {
+		// an empty value must stay non-nil, unlike an absent one.
+		c.key, c.val = make(Datum, 0, 64), make(Datum, 0, 64)
+	}
+	c.key = append(c.key[:0], key...)
+	c.val = append(c.val[:0], val...)
+	return c.key, c.val, true, nil
+}
+
+// next advances the cursor and returns the next pair, aliasing the page
+// buffer.
+func (c *Cursor) next() (Datum, Datum, bool, error) {
+	if c.pag == nil {
+		return Nullitem, Nullitem, false, ErrCursorClosed
+	}
+	for {
+		if !c.loaded {
+			ok, err := c.db.readPage(c.pagbno, c.pag)
+			if err != nil {
+				return Nullitem, Nullitem, false, err
+			}
+			if !ok {
+				return Nullitem, Nullitem, false, nil
+			}
+			if err := c.db.checkPage(c.pagbno, c.pag); err != nil {
+				return Nullitem, Nullitem, false, err
+			}
+			c.loaded = true
+		}
+
+		c.keyptr++
+		if key := c.pag.GetNKey(c.keyptr); key != nil {
+			i := 2 * c.keyptr
+			return key, c.pag.buf[c.pag.getIno(i):c.pag.getIno(i-1)], true, nil
+		}
+
+		// this page is done; move on to the next one.
+		c.pagbno++
+		c.loaded = false
+		c.keyptr = 0
+	}
+}
+
+// Close releases the page buffer of the cursor. Next fails with
+// ErrCursorClosed afterwards.
+func (c *Cursor) Close() {
+	c.pag = nil
+	c.key, c.val = nil, nil
+}
@@ -0,0 +1,154 @@
+package sdbm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestCursor_Independent(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	want := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		want[p.Key.String()] = p.Val.String()
+	}
+
+	first, err := dbm.FirstKey()
+	if err != nil {
+		t.Fatalf("FirstKey() error = %v", err)
+	}
+
+	// two cursors advanced in lockstep, one twice as fast.
+	a, b := dbm.NewCursor(), dbm.NewCursor()
+	defer a.Close()
+	defer b.Close()
+	seenA, seenB := make(map[string]int), make(map[string]int)
+	next := func(c *sdbm.Cursor, seen map[string]int) bool {
+		t.Helper()
+		key, val, ok, err := c.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if !ok {
+			return false
+		}
+		if want[key.String()] != val.String() {
+			t.Errorf("Next() got %s = %q, want %q", key, val, want[key.String()])
+		}
+		seen[key.String()]++
+		return true
+	}
+	for moreA, moreB := true, true; moreA || moreB; {
+		if moreA {
+			moreA = next(a, seenA)
+		}
+		for i := 0; i < 2 && moreB; i++ {
+			moreB = next(b, seenB)
+		}
+	}
+	for name, seen := range map[string]map[string]int{"a": seenA, "b": seenB} {
+		if len(seen) != len(pairs) {
+			t.Errorf("cursor %s keys got = %d, want %d", name, len(seen), len(pairs))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("cursor %s returned %s %d times, want 1", name, key, n)
+			}
+		}
+	}
+
+	// the cursors leave the position of FirstKey and NextKey alone.
+	second, err := dbm.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey() error = %v", err)
+	}
+	if second == nil || second.String() == first.String() {
+		t.Errorf("NextKey() got = %q after FirstKey() = %q", second, first)
+	}
+}
+
+func TestCursor_Seek(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	c := dbm.NewCursor()
+	defer c.Close()
+	for _, target := range []string{"key1", "key500", "key999", "missing"} {
+		c.Seek(sdbm.Datum(target))
+		found, n := false, 0
+		for {
+			key, _, ok, err := c.Next()
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			if !ok {
+				break
+			}
+			n++
+			found = found || key.String() == target
+		}
+		if found != (target != "missing") {
+			t.Errorf("Seek(%s) then Next() found = %v", target, found)
+		}
+		if n == 0 || n > len(pairs) {
+			t.Errorf("Seek(%s) then Next() pairs got = %d, want 1 to %d", target, n, len(pairs))
+		}
+	}
+}
+
+func TestCursor_Close(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	c := dbm.NewCursor()
+	c.Close()
+	if _, _, ok, err := c.Next(); ok || !errors.Is(err, sdbm.ErrCursorClosed) {
+		t.Errorf("Next() after Close() got = %v, %v, want false, %v", ok, err, sdbm.ErrCursorClosed)
+	}
+}
+
+func TestCursor_NextReuse(t *testing.T) {
+	pairs := append(generatePairs("key", "val", 1000), Pair{Key: sdbm.Datum("empty"), Val: sdbm.Datum{}})
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	want := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		want[p.Key.String()] = p.Val.String()
+	}
+	scan := func() int {
+		c := dbm.NewCursor()
+		defer c.Close()
+		n := 0
+		for {
+			key, val, ok, err := c.NextReuse()
+			if err != nil {
+				t.Fatalf("NextReuse() error = %v", err)
+			}
+			if !ok {
+				return n
+			}
+			if val == nil {
+				t.Errorf("NextReuse() got %s = nil, want non-nil", key)
+			}
+			if w, ok := want[key.String()]; !ok || w != val.String() {
+				t.Errorf("NextReuse() got %s = %q, want %q", key, val, w)
+			}
+			n++
+		}
+	}
+	if n := scan(); n != len(pairs) {
+		t.Errorf("NextReuse() pairs got = %d, want %d", n, len(pairs))
+	}
+
+	// a pair costs no allocation: only the cursor and its buffers do.
+	allocs := testing.AllocsPerRun(10, func() { scan() })
+	if allocs > 10 {
+		t.Errorf("NextReuse() scan allocs got = %v, want at most 10 for %d pairs", allocs, len(pairs))
+	}
+}
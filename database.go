@@ -0,0 +1,98 @@
+package sdbm
+
+import (
+	"context"
+	"io"
+	"iter"
+	"os"
+)
+
+// Database is the method set of *DBM, for callers that would rather depend
+// on an interface, such as to substitute a fake in their tests. It grows
+// with *DBM: a method added to *DBM is added here too.
+type Database interface {
+	// basic operations.
+	Fetch(key Datum) (Datum, error)
+	FetchInto(key Datum, dst []byte) (n int, found bool, err error)
+	FetchAll(key Datum) ([]Datum, error)
+	Exists(key Datum) (bool, error)
+	Store(key, val Datum, flags StoreFlags) (bool, error)
+	StoreIfChanged(key, val Datum) (bool, error)
+	StoreAndGet(key, val Datum, flags StoreFlags) (old Datum, existed bool, err error)
+	Delete(key Datum) (bool, error)
+	DeleteAndGet(key Datum) (val Datum, ok bool, err error)
+	DeleteAll(key Datum) (int, error)
+	Increment(key Datum, delta int64) (int64, error)
+	AssertFetchable(key Datum) error
+	WarmupKeys(keys []Datum) error
+	LookupCost(key Datum) (dirReads int, trieDepth int, err error)
+	DirBits() ([]bool, error)
+	PairMax() int
+
+	// tagged, versioned and encoded values.
+	StoreTagged(key, val Datum, tag string, flags StoreFlags) (bool, error)
+	FetchTagged(key Datum) (Datum, string, bool, error)
+	StoreVersion(key, val Datum, version uint64, flags StoreFlags) (bool, error)
+	FetchVersion(key Datum) (Datum, uint64, bool, error)
+	StoreGob(key Datum, v any, flags StoreFlags) (bool, error)
+	FetchGob(key Datum, out any) (bool, error)
+	FetchIfNewer(key Datum, version uint64) (Datum, bool, error)
+
+	// batches.
+	NewWriteBatch() *WriteBatch
+	StoreMany(pairs []Pair, flags StoreFlags) (int, error)
+	StoreManyContext(ctx context.Context, pairs []Pair, flags StoreFlags) (int, error)
+	DeleteBatch(keys []Datum) (int, error)
+	DeleteMany(keys []Datum) (int, error)
+
+	// iteration.
+	FirstKey() (Datum, error)
+	NextKey() (Datum, error)
+	NewCursor() *Cursor
+	ForEach(fn func(key, val Datum) error) error
+	ForEachContext(ctx context.Context, fn func(key, val Datum) error) error
+	ReverseForEach(fn func(key, val Datum) error) error
+	ScanPrefix(prefix Datum, fn func(key, val Datum) error) error
+	RecoverScan(fn func(key, val Datum) error) (skipped []int64, err error)
+	ForEachKey(fn func(key Datum) error) error
+	Keys() iter.Seq2[Datum, error]
+	Pairs() iter.Seq2[Pair, error]
+	Pages(ctx context.Context) (<-chan PageRecord, error)
+	RawPages(fn func(pageNo int64, raw []byte, valid bool) error) error
+
+	// inspection.
+	Count() (int, error)
+	CountContext(ctx context.Context) (int, error)
+	ApproxCount() (pairs int, pages int, err error)
+	KeyPageMap() (map[string]int64, error)
+	Extremes() (largestKey, largestVal Datum, maxKeyLen, maxValLen int, err error)
+	TotalFreeBytes() (int64, error)
+	Stats() (Stats, error)
+	DiskUsage() (pagBytes, dirBytes int64, err error)
+	FormatInfo() (FormatInfo, error)
+	DigestTree(fanout int) (*DigestNode, error)
+	Verify() error
+	VerifyContext(ctx context.Context) error
+	DumpPage(pageNo int64, w io.Writer) error
+	IsStale() (bool, error)
+
+	// copies and dumps.
+	Snapshot() (*Snapshot, error)
+	SnapshotTo(path string) (*DBM, error)
+	WriteTo(w io.Writer) (int64, error)
+	Load(r io.Reader, flags StoreFlags) error
+	ExportJSON(w io.Writer) error
+	ImportJSON(r io.Reader, flags StoreFlags) error
+
+	// maintenance.
+	Reorganize() error
+	Compact() error
+	Clear() error
+	Reset(file string, flags int, mode os.FileMode) error
+	Reopen() error
+	Release()
+	Sync() error
+	Close() error
+}
+
+var _ Database = (*DBM)(nil)
@@ -0,0 +1,152 @@
+package sdbm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"slices"
+)
+
+// DigestNode is a node of the tree built by DigestTree.
+//
+// Every key is placed at a position given by the bit-reversed low 32 bits of
+// its hash. Since a page holds the keys sharing the low bits of their hash,
+// aligned position ranges correspond to subtrees of the directory trie, so a
+// node covers a group of pages. The root covers every position, and each
+// inner node splits its range into fanout equal, contiguous child ranges.
+// A node becomes a leaf once it holds at most fanout keys.
+//
+// The Sum of a leaf is the SHA-256 of the digests of its pairs in position
+// order, and the Sum of an inner node is the SHA-256 of its children's sums.
+// Two databases holding the same pairs therefore produce identical trees,
+// whatever their page layout.
+type DigestNode struct {
+	Lo       uint32        // The first position covered by the node.
+	Hi       uint32        // The last position covered by the node.
+	Count    int           // The number of pairs within the range.
+	Sum      [32]byte      // The digest of the pairs within the range.
+	Children []*DigestNode // The child nodes; nil for a leaf.
+}
+
+// DigestRange is a range of key positions reported by DigestNode.Diff.
+type DigestRange struct {
+	Lo uint32
+	Hi uint32
+}
+
+type digestEntry struct {
+	pos uint32
+	sum [32]byte
+}
+
+// DigestTree scans the database and builds a digest tree with the given
+// fanout, which must be at least 2. Replicas can compare their trees with
+// Diff to find the ranges of keys that differ without exchanging every pair.
+func (db *DBM) DigestTree(fanout int) (*DigestNode, error) {
+	if fanout < 2 {
+		return nil, ErrInvalidArgument
+	}
+
+	var entries []digestEntry
+	err := db.walkPages(func(_ int64, p *Page) error {
+		p.forEachPair(func(key, val Datum) bool {
+			entries = append(entries, digestEntry{
+				pos: bits.Reverse32(uint32(db.exHash(key))),
+				sum: pairDigest(key, val),
+			})
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b digestEntry) int {
+		if a.pos != b.pos {
+			if a.pos < b.pos {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(a.sum[:], b.sum[:])
+	})
+
+	return buildDigest(entries, 0, 1<<32-1, fanout), nil
+}
+
+// pairDigest is the canonical digest of a single key-value pair.
+func pairDigest(key, val Datum) [32]byte {
+	h := sha256.New()
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(key)))
+	h.Write(n[:])
+	h.Write(key)
+	binary.LittleEndian.PutUint32(n[:], uint32(len(val)))
+	h.Write(n[:])
+	h.Write(val)
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// buildDigest builds the node covering [lo, hi] from the sorted entries
+// falling in that range.
+func buildDigest(entries []digestEntry, lo, hi uint32, fanout int) *DigestNode {
+	node := &DigestNode{Lo: lo, Hi: hi, Count: len(entries)}
+	width := uint64(hi) - uint64(lo) + 1
+	h := sha256.New()
+
+	if len(entries) <= fanout || width < uint64(fanout) {
+		for _, e := range entries {
+			h.Write(e.sum[:])
+		}
+		h.Sum(node.Sum[:0])
+		return node
+	}
+
+	step := width / uint64(fanout)
+	for i := 0; i < fanout; i++ {
+		clo := uint64(lo) + uint64(i)*step
+		chi := clo + step - 1
+		if i == fanout-1 {
+			chi = uint64(hi)
+		}
+		n, _ := slices.BinarySearchFunc(entries, uint32(chi)+1, func(e digestEntry, pos uint32) int {
+			if e.pos < pos {
+				return -1
+			}
+			if e.pos > pos {
+				return 1
+			}
+			return 0
+		})
+		if chi == 1<<32-1 {
+			n = len(entries)
+		}
+		child := buildDigest(entries[:n:n], uint32(clo), uint32(chi), fanout)
+		entries = entries[n:]
+		node.Children = append(node.Children, child)
+		h.Write(child.Sum[:])
+	}
+	h.Sum(node.Sum[:0])
+	return node
+}
+
+// Diff compares two trees built with the same fanout and returns the
+// narrowest ranges whose pairs differ between them. It returns nil if the
+// trees are identical.
+func (n *DigestNode) Diff(other *DigestNode) []DigestRange {
+	if n.Sum == other.Sum && n.Count == other.Count {
+		return nil
+	}
+	if len(n.Children) == 0 || len(n.Children) != len(other.Children) {
+		return []DigestRange{{Lo: n.Lo, Hi: n.Hi}}
+	}
+	var ranges []DigestRange
+	for i, child := range n.Children {
+		ranges = append(ranges, child.Diff(other.Children[i])...)
+	}
+	return ranges
+}
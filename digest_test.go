@@ -0,0 +1,52 @@
+package sdbm_test
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_DigestTree(t *testing.T) {
+	pairs := generatePairs("key", "val", 2000)
+	_, dbm1 := setup(t, pairs...)
+	defer teardown(t, dbm1)
+	_, dbm2 := setup(t, pairs...)
+	defer teardown(t, dbm2)
+
+	changed := sdbm.Datum("extra")
+	if _, err := dbm2.Store(changed, sdbm.Datum("val"), 0); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	tree1, err := dbm1.DigestTree(4)
+	if err != nil {
+		t.Fatalf("DigestTree() error = %v", err)
+	}
+	tree2, err := dbm2.DigestTree(4)
+	if err != nil {
+		t.Fatalf("DigestTree() error = %v", err)
+	}
+	if tree1.Count != len(pairs) {
+		t.Errorf("DigestTree() count got = %d, want %d", tree1.Count, len(pairs))
+	}
+	if got := tree1.Diff(tree1); got != nil {
+		t.Errorf("Diff() of identical trees got = %v, want nil", got)
+	}
+
+	ranges := tree1.Diff(tree2)
+	if len(ranges) != 1 {
+		t.Fatalf("Diff() got = %v, want a single range", ranges)
+	}
+	pos := bits.Reverse32(uint32(sdbm.Hash(changed)))
+	if pos < ranges[0].Lo || pos > ranges[0].Hi {
+		t.Errorf("Diff() range %v does not contain position %d", ranges[0], pos)
+	}
+	if width := uint64(ranges[0].Hi) - uint64(ranges[0].Lo) + 1; width > 1<<32/64 {
+		t.Errorf("Diff() range width got = %d, want a narrowed range", width)
+	}
+
+	if _, err := dbm1.DigestTree(1); err == nil {
+		t.Error("DigestTree() want error for fanout 1")
+	}
+}
@@ -0,0 +1,128 @@
+package sdbm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * dump format:
+ *
+ * WriteTo writes a stream that Load reads back, independent of the page
+ * layout:
+ *
+ *      +-------------+------+---------+-----+---------+-----+---------+
+ *      | "sdbmdump"  | vers | klen(4) | key | vlen(4) | val | ...     |
+ *      +-------------+------+---------+-----+---------+-----+---------+
+ *
+ * Lengths are little-endian uint32s. The stream ends after the last record.
+ */
+var dumpMagic = []byte("sdbmdump")
+
+const dumpVersion = 1 // the version written by WriteTo
+
+// WriteTo writes every pair of the database to w in the dump format read by
+// Load, and returns the number of bytes written. The values are those Fetch
+// returns, so a dump taken with Options.InternValues or
+// Options.OverflowThreshold loads into a database with or without them.
+// The cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	bw.Write(dumpMagic)
+	bw.WriteByte(dumpVersion)
+	err := db.forEachValue(func(key, val Datum) error {
+		_ = binary.Write(bw, binary.LittleEndian, uint32(key.Size()))
+		bw.Write(key)
+		_ = binary.Write(bw, binary.LittleEndian, uint32(val.Size()))
+		_, err := bw.Write(val)
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	return cw.n, err
+}
+
+// Load reads a stream written by WriteTo and stores each of its pairs with
+// flags, as Store does. It fails with ErrUnsupportedFormat if r does not
+// start with a dump header this package can read, and with
+// io.ErrUnexpectedEOF if the stream ends inside a record. The pairs stored
+// before a failure stay stored.
+func (db *DBM) Load(r io.Reader, flags StoreFlags) error {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(dumpMagic)+1)
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr[:len(dumpMagic)], dumpMagic) {
+		return fmt.Errorf("%w: not a dump", ErrUnsupportedFormat)
+	}
+	if v := hdr[len(dumpMagic)]; v != dumpVersion {
+		return fmt.Errorf("%w: dump version %d", ErrUnsupportedFormat, v)
+	}
+
+	// lengths no Store accepts are rejected before they are allocated.
+	readDatum := func(limit int64, tooBig error) (Datum, error) {
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		if limit >= 0 && int64(n) > limit {
+			return nil, tooBig
+		}
+		d := make(Datum, n)
+		_, err := io.ReadFull(br, d)
+		return d, noEOF(err)
+	}
+	for {
+		key, err := readDatum(int64(db.PairMax()), ErrKeyTooLong)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		limit := int64(db.PairMax())
+		if db.ovf != nil {
+			limit = -1
+		}
+		val, err := readDatum(limit, ErrValueTooBig)
+		if err != nil {
+			return noEOF(err)
+		}
+		if _, err := db.Store(key, val, flags); err != nil {
+			return err
+		}
+	}
+}
+
+// forEachValue is ForEach with the values Fetch returns rather than the
+// records of Options.InternValues and Options.OverflowThreshold.
+func (db *DBM) forEachValue(fn func(key, val Datum) error) error {
+	return db.ForEach(func(key, val Datum) error {
+		var err error
+		if db.blobs != nil {
+			val, err = db.resolveBlob(val)
+		} else if db.ovf != nil {
+			val, err = db.resolveOverflow(val)
+		}
+		if err != nil {
+			return err
+		}
+		return fn(key, val)
+	})
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
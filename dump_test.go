@@ -0,0 +1,126 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_WriteTo_Load(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "one page", pairs: generatePairs("key", "val", 10)},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, src := setup(t, tt.pairs...)
+			defer teardown(t, src)
+
+			var buf bytes.Buffer
+			n, err := src.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("WriteTo() got = %d, want %d bytes written", n, buf.Len())
+			}
+
+			dst := setupMem(t)
+			defer teardown(t, dst)
+			if err := dst.Load(&buf, sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			for _, p := range tt.pairs {
+				if got, err := dst.Fetch(p.Key); err != nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+			if count, err := dst.Count(); err != nil || count != len(tt.pairs) {
+				t.Errorf("Count() got = %d, %v, want %d", count, err, len(tt.pairs))
+			}
+		})
+	}
+}
+
+func TestDBM_WriteTo_Overflow(t *testing.T) {
+	dir := t.TempDir()
+	opts := sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, OverflowThreshold: 100}
+	src, err := sdbm.OpenWithOptions(filepath.Join(dir, "src"), opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, src)
+	big := sdbm.Datum(strings.Repeat("v", 5000))
+	if _, err := src.Store(sdbm.Datum("big"), big, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := src.Store(sdbm.Datum("small"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	dump := buf.Bytes()
+
+	// the values are dumped, not the records pointing at them.
+	dst, err := sdbm.OpenWithOptions(filepath.Join(dir, "dst"), opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dst)
+	if err := dst.Load(bytes.NewReader(dump), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, err := dst.Fetch(sdbm.Datum("big")); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("Fetch(big) got %d bytes, %v, want %d bytes", len(got), err, len(big))
+	}
+
+	// a database without an overflow file cannot hold the big value.
+	plain := setupMem(t)
+	defer teardown(t, plain)
+	if err := plain.Load(bytes.NewReader(dump), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrValueTooBig) {
+		t.Errorf("Load() error = %v, want ErrValueTooBig", err)
+	}
+}
+
+func TestDBM_Load_Invalid(t *testing.T) {
+	_, src := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, src)
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	dump := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		wantErr error
+	}{
+		{name: "empty stream", stream: nil, wantErr: sdbm.ErrUnsupportedFormat},
+		{name: "not a dump", stream: []byte("hello, world"), wantErr: sdbm.ErrUnsupportedFormat},
+		{name: "unknown version", stream: append([]byte("sdbmdump"), 9), wantErr: sdbm.ErrUnsupportedFormat},
+		{name: "truncated record", stream: dump[:len(dump)-1], wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := setupMem(t)
+			defer teardown(t, dst)
+			if err := dst.Load(bytes.NewReader(tt.stream), 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package sdbm
+
+// divergingFile flips the last byte of every non-empty read, as storage
+// that corrupts data would.
+type divergingFile struct {
+	File
+}
+
+func (f divergingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if n > 0 {
+		p[n-1] ^= 0xff
+	}
+	return n, err
+}
+
+// CorruptPagReads makes every later read of the .pag file return corrupted data.
+func CorruptPagReads(db *DBM) {
+	db.pagf = divergingFile{db.pagf}
+}
+
+// SetTxHook installs a hook called at each step of TxGroup.Commit.
+func SetTxHook(fn func(step string, i int) error) {
+	testHookTx = fn
+}
+
+// ClearDBit clears directory bit dbit.
+func ClearDBit(db *DBM, dbit int64) error {
+	return db.clearDBit(dbit)
+}
+
+// BuffersHeld reports whether the database holds its page and directory buffers.
+func BuffersHeld(db *DBM) bool {
+	return db.pag != nil || db.dirbuf != nil
+}
+
+// countingFile counts the writes made to a file.
+type countingFile struct {
+	File
+	writes *int
+}
+
+func (f countingFile) WriteAt(p []byte, off int64) (int, error) {
+	*f.writes++
+	return f.File.WriteAt(p, off)
+}
+
+// CountPagWrites makes the database count its writes to the .pag file in the returned counter.
+func CountPagWrites(db *DBM) *int {
+	n := new(int)
+	db.pagf = countingFile{File: db.pagf, writes: n}
+	return n
+}
+
+// readCountingFile counts the reads made from a file.
+type readCountingFile struct {
+	File
+	reads *int
+}
+
+func (f readCountingFile) ReadAt(p []byte, off int64) (int, error) {
+	*f.reads++
+	return f.File.ReadAt(p, off)
+}
+
+// CountPagReads makes the database count its reads of the .pag file in the returned counter.
+func CountPagReads(db *DBM) *int {
+	n := new(int)
+	db.pagf = readCountingFile{File: db.pagf, reads: n}
+	return n
+}
+
+// SetWALHook installs a hook called after each write-ahead log record of
+// db is synced.
+func SetWALHook(db *DBM, fn func() error) {
+	db.walHook = fn
+}
+
+// Abandon closes the files of the database without checkpointing, as a
+// crash would leave them.
+func Abandon(db *DBM) {
+	_ = db.dirf.Close()
+	_ = db.pagf.Close()
+	if db.wal != nil {
+		_ = db.wal.Close()
+	}
+}
+
+// syncCountingFile counts the syncs made to a file.
+type syncCountingFile struct {
+	File
+	syncs *int
+}
+
+func (f syncCountingFile) Sync() error {
+	*f.syncs++
+	return f.File.Sync()
+}
+
+// CountSyncs makes the database count its syncs of the .dir and .pag files
+// in the returned counters.
+func CountSyncs(db *DBM) (dir, pag *int) {
+	dir, pag = new(int), new(int)
+	db.dirf = syncCountingFile{File: db.dirf, syncs: dir}
+	db.pagf = syncCountingFile{File: db.pagf, syncs: pag}
+	return dir, pag
+}
@@ -0,0 +1,37 @@
+package sdbm
+
+import (
+	"io"
+	"os"
+)
+
+// File is the storage behind the .dir or .pag file of a database, as used
+// by OpenFiles. Open and the other constructors use *os.File, so
+// implementations are needed only to keep a database elsewhere, such as in
+// memory or behind an encrypting wrapper. Reads beyond Size must report
+// io.EOF; the database reads whatever lies beyond the end as zeros, and
+// WriteAt beyond the end must extend the file, filling any gap with zeros.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	// Name names the file in the errors of the database.
+	Name() string
+	// Size returns the current size of the file in bytes.
+	Size() (int64, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// osFile is the File of a file on disk.
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
@@ -0,0 +1,167 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+// memFile is an in-memory sdbm.File.
+type memFile struct {
+	name string
+	data []byte
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if size <= int64(len(f.data)) {
+		f.data = f.data[:size]
+		return nil
+	}
+	_, err := f.WriteAt(make([]byte, size-int64(len(f.data))), int64(len(f.data)))
+	return err
+}
+
+func (f *memFile) Name() string         { return f.name }
+func (f *memFile) Size() (int64, error) { return int64(len(f.data)), nil }
+func (f *memFile) Sync() error          { return nil }
+func (f *memFile) Close() error         { return nil }
+
+func TestOpenFiles(t *testing.T) {
+	dirf, pagf := &memFile{name: "mem.dir"}, &memFile{name: "mem.pag"}
+	db, err := sdbm.OpenFiles(dirf, pagf, sdbm.Options{Flags: os.O_RDWR, Checksums: true})
+	if err != nil {
+		t.Fatalf("OpenFiles() error = %v", err)
+	}
+	pairs := generatePairs("key", "val", 2000)
+	for _, p := range pairs {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if err := db.Reorganize(); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Reorganize() error = %v, want ErrInvalidArgument", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirf.data) == 0 || len(pagf.data) <= sdbm.PBLKSIZ {
+		t.Fatalf("file sizes got = %d/%d, want the pairs written", len(dirf.data), len(pagf.data))
+	}
+
+	db, err = sdbm.OpenFiles(dirf, pagf, sdbm.Options{})
+	if err != nil {
+		t.Fatalf("OpenFiles() error = %v", err)
+	}
+	defer teardown(t, db)
+	for _, p := range pairs {
+		if val, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(val, p.Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+		}
+	}
+	if err := db.Verify(); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if _, err := db.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Store() on a read-only database error = %v, want ErrDBMRDOnly", err)
+	}
+}
+
+// garbageFile is a memFile on storage without zero-filled holes: growing
+// it by writing past its end fills the gap with garbage.
+type garbageFile struct {
+	memFile
+}
+
+func (f *garbageFile) WriteAt(p []byte, off int64) (int, error) {
+	if gap := off - int64(len(f.data)); gap > 0 {
+		f.data = append(f.data, bytes.Repeat([]byte{0xa5}, int(gap))...)
+	}
+	return f.memFile.WriteAt(p, off)
+}
+
+func TestOpenFiles_ZeroFill(t *testing.T) {
+	tests := []struct {
+		name     string
+		zeroFill bool
+	}{
+		{name: "holes left", zeroFill: false},
+		{name: "holes filled", zeroFill: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirf := &garbageFile{memFile{name: "garbage.dir"}}
+			pagf := &garbageFile{memFile{name: "garbage.pag"}}
+			db, err := sdbm.OpenFiles(dirf, pagf, sdbm.Options{Flags: os.O_RDWR, ZeroFill: tt.zeroFill})
+			if err != nil {
+				t.Fatalf("OpenFiles() error = %v", err)
+			}
+			defer teardown(t, db)
+
+			pairs := generatePairs("key", "val", 3000)
+			for _, p := range pairs {
+				if _, err = db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = db.Verify()
+			}
+			if !tt.zeroFill {
+				if !errors.Is(err, sdbm.ErrInvalidPage) {
+					t.Errorf("Store() or Verify() error = %v, want %v from a hole", err, sdbm.ErrInvalidPage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Store() or Verify() error = %v", err)
+			}
+			for _, p := range pairs {
+				if val, err := db.Fetch(p.Key); err != nil || !bytes.Equal(val, p.Val) {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenFiles_InvalidArgument(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "InternValues", opts: sdbm.Options{Flags: os.O_RDWR, InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{Flags: os.O_RDWR, OverflowThreshold: 100}},
+		{name: "WAL", opts: sdbm.Options{Flags: os.O_RDWR, WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sdbm.OpenFiles(&memFile{name: "mem.dir"}, &memFile{name: "mem.pag"}, tt.opts)
+			if !errors.Is(err, sdbm.ErrInvalidArgument) {
+				t.Errorf("OpenFiles() error = %v, want ErrInvalidArgument", err)
+			}
+		})
+	}
+}
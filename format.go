@@ -0,0 +1,252 @@
+package sdbm
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * format header:
+ *
+ * A database created with Options.FormatHeader reserves the first block of
+ * the .pag file, of the page size, for a header, and page 0 follows it:
+ *
+ *      +------+------+---------+-------+----------+----------+----------+
+ *      | 0xff | 0xff | "sdbm"  | vers  | order    | pagsiz   | dirsiz   |
+ *      +------+------+---------+-------+----------+----------+----------+
+ *      | features |  zeros to the end of the block ...                  |
+ *      +----------+-----------------------------------------------------+
+ *
+ * The leading 0xffff reads as an entry count no page can hold, so code
+ * unaware of the header rejects the block instead of misreading it, and a
+ * headerless database can never start with the magic. order is 0 for
+ * little-endian page offsets and checksums, 1 for big-endian ones; pagsiz,
+ * dirsiz and features are little-endian uint32s whatever the order.
+ * Databases without a header are format version 0.
+ */
+var formatMagic = []byte{0xff, 0xff, 's', 'd', 'b', 'm'}
+
+const (
+	formatVersion = 1 // the version written by this package
+
+	hdrVersion  = 6
+	hdrOrder    = 7
+	hdrPagSiz   = 8
+	hdrDirSiz   = 12
+	hdrFeatures = 16
+	hdrSize     = 20
+
+	orderLittleEndian = 0
+	orderBigEndian    = 1
+)
+
+// Feature is a set of optional format features recorded in the header.
+type Feature uint32
+
+const (
+	// FeatureChecksums marks pages that carry a checksum.
+	FeatureChecksums Feature = 1 << iota
+	// bit 1 is reserved, so that FeatureOverflow keeps its value in the
+	// files already written.
+	_
+	// FeatureOverflow marks a database whose values are the records of
+	// Options.OverflowThreshold, pointing into its overflow file. The
+	// overflowed values live in that separate file rather than in pages of
+	// the .pag file: every page number may be claimed by a split, as pages
+	// are numbered by the bits of the key hashes, so no range of the .pag
+	// file is free for them.
+	FeatureOverflow
+)
+
+// The block sizes a database may be created with. Page offsets are uint16s,
+// so a page holds at most maxPageSize bytes.
+const (
+	minPageSize     = 256
+	maxPageSize     = 1 << 15
+	minDirBlockSize = 256
+	maxDirBlockSize = 1 << 20
+)
+
+// validBlockSize reports whether size is a power of two within [lo, hi].
+func validBlockSize(size, lo, hi int) bool {
+	return size >= lo && size <= hi && size&(size-1) == 0
+}
+
+// checkFormatOptions validates Options.PageSize, Options.DirBlockSize and
+// Options.ByteOrder.
+func checkFormatOptions(opts Options) error {
+	if opts.PageSize != 0 && !validBlockSize(opts.PageSize, minPageSize, maxPageSize) {
+		return fmt.Errorf("%w: page size %d", ErrInvalidArgument, opts.PageSize)
+	}
+	if opts.DirBlockSize != 0 && !validBlockSize(opts.DirBlockSize, minDirBlockSize, maxDirBlockSize) {
+		return fmt.Errorf("%w: directory block size %d", ErrInvalidArgument, opts.DirBlockSize)
+	}
+	if bo := opts.ByteOrder; bo != nil && bo != binary.LittleEndian && bo != binary.BigEndian {
+		return fmt.Errorf("%w: byte order %v", ErrInvalidArgument, bo)
+	}
+	return nil
+}
+
+// supportedFeatures are the features this package can open.
+const supportedFeatures = FeatureChecksums | FeatureOverflow
+
+// ErrUnsupportedFormat indicates that the format header of a database
+// records a version, layout or feature this package cannot handle.
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// ErrByteOrder indicates that a page fails ChkPage but passes with its
+// offsets read in the other byte order: the database was likely written on
+// a machine of the other endianness, and opens with Options.ByteOrder set
+// to match. It comes wrapped together with ErrInvalidPage.
+var ErrByteOrder = errors.New("offsets in the other byte order")
+
+// ErrBadMagic indicates that a .pag file does not start with the magic of
+// the format header although it must: it starts like one but does not
+// match, or Options.RequireHeader is set and the file has no header.
+var ErrBadMagic = errors.New("bad magic number")
+
+// FormatInfo describes the on-disk format of a database.
+type FormatInfo struct {
+	Version      int              // 0 for a database without a header
+	PageSize     int              // size of a .pag block
+	DirBlockSize int              // size of a .dir block
+	ByteOrder    binary.ByteOrder // byte order of the page offset tables
+	Features     Feature          // optional features in use
+}
+
+// FormatInfo returns the format of the database, as read from its header
+// when it was opened.
+func (db *DBM) FormatInfo() (FormatInfo, error) {
+	return db.format, nil
+}
+
+// headerlessFormat is the format of databases without a header.
+var headerlessFormat = FormatInfo{
+	Version:      0,
+	PageSize:     PBLKSIZ,
+	DirBlockSize: DBLKSIZ,
+	ByteOrder:    binary.LittleEndian,
+}
+
+// readHeader reads the format header of the .pag file, if any, and sets up
+// the database accordingly. An empty .pag file opened for writing gets a
+// header when Options.FormatHeader, Options.RequireHeader, Options.Checksums,
+// Options.OverflowThreshold or a block size is set. The block sizes and byte order set in the options
+// must match those of the header.
+func (db *DBM) readHeader() error {
+	if err := db.readFormat(); err != nil {
+		return err
+	}
+	if ps := db.opts.PageSize; ps != 0 && ps != db.format.PageSize {
+		return fmt.Errorf("%w: page size is %d, Options.PageSize is %d", ErrUnsupportedFormat, db.format.PageSize, ps)
+	}
+	if ds := db.opts.DirBlockSize; ds != 0 && ds != db.format.DirBlockSize {
+		return fmt.Errorf("%w: directory block size is %d, Options.DirBlockSize is %d", ErrUnsupportedFormat, db.format.DirBlockSize, ds)
+	}
+	if bo := db.opts.ByteOrder; bo != nil && bo != db.format.ByteOrder {
+		return fmt.Errorf("%w: byte order is %v, Options.ByteOrder is %v", ErrUnsupportedFormat, db.format.ByteOrder, bo)
+	}
+	return nil
+}
+
+func (db *DBM) readFormat() error {
+	db.format = headerlessFormat
+	if db.opts.ByteOrder != nil {
+		db.format.ByteOrder = db.opts.ByteOrder
+	}
+	var hdr [hdrSize]byte
+	n, err := db.pagf.ReadAt(hdr[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return wrapIOErr("readat", db.pagf.Name(), err)
+	}
+
+	if n == 0 {
+		header := db.opts.FormatHeader || db.opts.RequireHeader || db.opts.Checksums || db.opts.OverflowThreshold > 0 ||
+			db.opts.PageSize != 0 || db.opts.DirBlockSize != 0
+		if header && !db.rdonly {
+			return db.writeHeader()
+		}
+		if db.opts.RequireHeader {
+			return fmt.Errorf("%w: %s is empty", ErrBadMagic, db.pagf.Name())
+		}
+		return nil
+	}
+	if n < len(formatMagic) || !bytes.Equal(hdr[:len(formatMagic)], formatMagic) {
+		// no page starts with an entry count of 0xffff.
+		if db.opts.RequireHeader || n >= 2 && hdr[0] == formatMagic[0] && hdr[1] == formatMagic[1] {
+			return fmt.Errorf("%w: %s", ErrBadMagic, db.pagf.Name())
+		}
+		return nil
+	}
+	if n < hdrSize {
+		return fmt.Errorf("%w: truncated header in %s", ErrUnsupportedFormat, db.pagf.Name())
+	}
+
+	f := FormatInfo{
+		Version:      int(hdr[hdrVersion]),
+		PageSize:     int(binary.LittleEndian.Uint32(hdr[hdrPagSiz:])),
+		DirBlockSize: int(binary.LittleEndian.Uint32(hdr[hdrDirSiz:])),
+		ByteOrder:    binary.LittleEndian,
+		Features:     Feature(binary.LittleEndian.Uint32(hdr[hdrFeatures:])),
+	}
+	if hdr[hdrOrder] == orderBigEndian {
+		f.ByteOrder = binary.BigEndian
+	}
+	switch {
+	case f.Version != formatVersion:
+		return fmt.Errorf("%w: version %d", ErrUnsupportedFormat, f.Version)
+	case hdr[hdrOrder] != orderLittleEndian && hdr[hdrOrder] != orderBigEndian:
+		return fmt.Errorf("%w: byte order %d", ErrUnsupportedFormat, hdr[hdrOrder])
+	case !validBlockSize(f.PageSize, minPageSize, maxPageSize) || !validBlockSize(f.DirBlockSize, minDirBlockSize, maxDirBlockSize):
+		return fmt.Errorf("%w: block sizes %d/%d", ErrUnsupportedFormat, f.PageSize, f.DirBlockSize)
+	case f.Features&^supportedFeatures != 0:
+		return fmt.Errorf("%w: features %#x", ErrUnsupportedFormat, uint32(f.Features))
+	}
+	// values written with an overflow file read as garbage without it, and
+	// plain values as garbage with it.
+	if ovf := f.Features&FeatureOverflow != 0; ovf != (db.opts.OverflowThreshold > 0) {
+		return fmt.Errorf("%w: overflow file in use is %v, Options.OverflowThreshold is %d",
+			ErrUnsupportedFormat, ovf, db.opts.OverflowThreshold)
+	}
+	db.format = f
+	db.pagBase = int64(f.PageSize)
+	return nil
+}
+
+// writeHeader writes a header for the current format to an empty .pag file.
+func (db *DBM) writeHeader() error {
+	f := FormatInfo{
+		Version:      formatVersion,
+		PageSize:     cmp.Or(db.opts.PageSize, PBLKSIZ),
+		DirBlockSize: cmp.Or(db.opts.DirBlockSize, DBLKSIZ),
+		ByteOrder:    binary.LittleEndian,
+	}
+	if db.opts.ByteOrder == binary.BigEndian {
+		f.ByteOrder = binary.BigEndian
+	}
+	if db.opts.OverflowThreshold > 0 {
+		f.Features |= FeatureOverflow
+	}
+	if db.opts.Checksums {
+		f.Features |= FeatureChecksums
+	}
+	buf := make([]byte, f.PageSize)
+	copy(buf, formatMagic)
+	buf[hdrVersion] = byte(f.Version)
+	if f.ByteOrder == binary.BigEndian {
+		buf[hdrOrder] = orderBigEndian
+	}
+	binary.LittleEndian.PutUint32(buf[hdrPagSiz:], uint32(f.PageSize))
+	binary.LittleEndian.PutUint32(buf[hdrDirSiz:], uint32(f.DirBlockSize))
+	binary.LittleEndian.PutUint32(buf[hdrFeatures:], uint32(f.Features))
+	if err := writeAt(db.pagf, 0, buf); err != nil {
+		return err
+	}
+	db.format = f
+	db.pagBase = int64(f.PageSize)
+	return nil
+}
@@ -0,0 +1,426 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_FormatInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		header bool
+		want   sdbm.FormatInfo
+	}{
+		{
+			name: "headerless database is version 0",
+			want: sdbm.FormatInfo{Version: 0, PageSize: sdbm.PBLKSIZ, DirBlockSize: sdbm.DBLKSIZ, ByteOrder: binary.LittleEndian},
+		},
+		{
+			name:   "database created with a header is version 1",
+			header: true,
+			want:   sdbm.FormatInfo{Version: 1, PageSize: sdbm.PBLKSIZ, DirBlockSize: sdbm.DBLKSIZ, ByteOrder: binary.LittleEndian},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, FormatHeader: tt.header})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			pairs := generatePairs("key", "val", 2000)
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the header is detected without the option.
+			db, err = sdbm.Open(path, os.O_RDONLY, 0)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+			got, err := db.FormatInfo()
+			if err != nil {
+				t.Fatalf("FormatInfo() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FormatInfo() got = %+v, want %+v", got, tt.want)
+			}
+
+			for _, p := range pairs {
+				if val, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(val, p.Val) {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+				}
+			}
+			n := 0
+			for key, err := db.FirstKey(); key != nil || err != nil; key, err = db.NextKey() {
+				if err != nil {
+					t.Fatalf("NextKey() error = %v", err)
+				}
+				n++
+			}
+			if n != len(pairs) {
+				t.Errorf("FirstKey/NextKey visited %d keys, want %d", n, len(pairs))
+			}
+		})
+	}
+}
+
+func TestOpen_UnsupportedFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int64
+		patch  []byte
+	}{
+		{name: "unknown version", offset: 6, patch: []byte{99}},
+		{name: "unknown byte order", offset: 7, patch: []byte{2}},
+		{name: "page size not a power of two", offset: 8, patch: []byte{0xe8, 0x03, 0, 0}},
+		{name: "page size too large for its offsets", offset: 8, patch: []byte{0, 0, 1, 0}},
+		{name: "unknown feature", offset: 16, patch: []byte{0, 0, 0, 0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, FormatHeader: true})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			teardown(t, db)
+
+			f, err := os.OpenFile(path+sdbm.PAGFEXT, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt(tt.patch, tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := sdbm.Open(path, os.O_RDWR, 0); !errors.Is(err, sdbm.ErrUnsupportedFormat) {
+				t.Errorf("Open() error = %v, want %v", err, sdbm.ErrUnsupportedFormat)
+			}
+		})
+	}
+}
+
+func TestOpen_OverflowFeature(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	// OverflowThreshold implies the header that records the feature.
+	opts := sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, OverflowThreshold: 64}
+	db, err := sdbm.OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	big := sdbm.Datum(strings.Repeat("v", 4*sdbm.PAIRMAX))
+	if _, err := db.Store(sdbm.Datum("big"), big, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	info, err := db.FormatInfo()
+	if err != nil || info.Features != sdbm.FeatureOverflow {
+		t.Errorf("FormatInfo() features got = %#x, %v, want %#x", info.Features, err, sdbm.FeatureOverflow)
+	}
+	teardown(t, db)
+
+	// the records cannot be read without the overflow file.
+	_, err = sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR, Mode: 0644})
+	if !errors.Is(err, sdbm.ErrUnsupportedFormat) {
+		t.Errorf("OpenWithOptions() without OverflowThreshold error = %v, want %v", err, sdbm.ErrUnsupportedFormat)
+	}
+
+	db, err = sdbm.OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	if got, err := db.Fetch(sdbm.Datum("big")); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("Fetch() got %d bytes, %v, want %d bytes", got.Size(), err, big.Size())
+	}
+	teardown(t, db)
+
+	// nor can plain values be read as records.
+	plain := filepath.Join(t.TempDir(), DBMFile)
+	db, err = sdbm.OpenWithOptions(plain, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, FormatHeader: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	teardown(t, db)
+	_, err = sdbm.OpenWithOptions(plain, opts)
+	if !errors.Is(err, sdbm.ErrUnsupportedFormat) {
+		t.Errorf("OpenWithOptions() with OverflowThreshold error = %v, want %v", err, sdbm.ErrUnsupportedFormat)
+	}
+}
+
+func TestOpenWithOptions_BlockSizes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	opts := sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, PageSize: 4096, DirBlockSize: 1024}
+	db, err := sdbm.OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if got, want := db.PairMax(), 4096-16; got != want {
+		t.Errorf("PairMax() got = %d, want %d", got, want)
+	}
+	pairs := generatePairs("key", "val", 5000)
+	pairs = append(pairs, Pair{
+		Key: sdbm.Datum("big"),
+		Val: sdbm.Datum(strings.Repeat("v", db.PairMax()-3)),
+	})
+	for _, p := range pairs {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store(%s) error = %v", p.Key, err)
+		}
+	}
+	if _, err := db.Store(sdbm.Datum("huge"), sdbm.Datum(strings.Repeat("v", db.PairMax())), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrValueTooBig) {
+		t.Errorf("Store() beyond PairMax error = %v, want %v", err, sdbm.ErrValueTooBig)
+	}
+	teardown(t, db)
+
+	// the sizes are read back from the header.
+	db, err = sdbm.Open(path, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	info, err := db.FormatInfo()
+	if err != nil || info.PageSize != 4096 || info.DirBlockSize != 1024 {
+		t.Errorf("FormatInfo() got = %+v, %v, want page size 4096 and dir block size 1024", info, err)
+	}
+	for _, p := range pairs {
+		if val, err := db.Fetch(p.Key); err != nil || !bytes.Equal(val, p.Val) {
+			t.Fatalf("Fetch(%s) got %d bytes, %v, want %d bytes", p.Key, val.Size(), err, p.Val.Size())
+		}
+	}
+	if err := db.Verify(); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	teardown(t, db)
+
+	// sizes that disagree with the header are refused.
+	_, err = sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR, PageSize: 8192})
+	if !errors.Is(err, sdbm.ErrUnsupportedFormat) {
+		t.Errorf("OpenWithOptions() with another page size error = %v, want %v", err, sdbm.ErrUnsupportedFormat)
+	}
+
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "page size not a power of two", opts: sdbm.Options{PageSize: 1000}},
+		{name: "page size too small", opts: sdbm.Options{PageSize: 128}},
+		{name: "page size too large", opts: sdbm.Options{PageSize: 1 << 16}},
+		{name: "dir block size not a power of two", opts: sdbm.Options{DirBlockSize: 3000}},
+		{name: "negative dir block size", opts: sdbm.Options{DirBlockSize: -4096}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Flags = os.O_RDWR | os.O_CREATE
+			tt.opts.Mode = 0644
+			_, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), tt.opts)
+			if !errors.Is(err, sdbm.ErrInvalidArgument) {
+				t.Errorf("OpenWithOptions() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+			}
+		})
+	}
+}
+
+func TestOpenWithOptions_RequireHeader(t *testing.T) {
+	create := func(t *testing.T, path string, opts sdbm.Options) {
+		t.Helper()
+		opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+		db, err := sdbm.OpenWithOptions(path, opts)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		if _, err := db.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+		teardown(t, db)
+	}
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, path string)
+		opts    sdbm.Options
+		wantErr error
+	}{
+		{
+			name:    "headerless database",
+			prepare: func(t *testing.T, path string) { create(t, path, sdbm.Options{}) },
+			opts:    sdbm.Options{Flags: os.O_RDWR, RequireHeader: true},
+			wantErr: sdbm.ErrBadMagic,
+		},
+		{
+			name:    "headerless database in legacy mode",
+			prepare: func(t *testing.T, path string) { create(t, path, sdbm.Options{}) },
+			opts:    sdbm.Options{Flags: os.O_RDWR},
+		},
+		{
+			name:    "database created with RequireHeader",
+			prepare: func(t *testing.T, path string) { create(t, path, sdbm.Options{RequireHeader: true}) },
+			opts:    sdbm.Options{Flags: os.O_RDONLY, RequireHeader: true},
+		},
+		{
+			name: "not a database",
+			prepare: func(t *testing.T, path string) {
+				if err := os.WriteFile(path+sdbm.PAGFEXT, []byte("just some text\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts:    sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, RequireHeader: true},
+			wantErr: sdbm.ErrBadMagic,
+		},
+		{
+			name: "damaged magic",
+			prepare: func(t *testing.T, path string) {
+				create(t, path, sdbm.Options{FormatHeader: true})
+				f, err := os.OpenFile(path+sdbm.PAGFEXT, os.O_RDWR, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.WriteAt([]byte("SDBM"), 2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts:    sdbm.Options{Flags: os.O_RDWR},
+			wantErr: sdbm.ErrBadMagic,
+		},
+		{
+			name: "empty database opened read-only",
+			prepare: func(t *testing.T, path string) {
+				create(t, path, sdbm.Options{})
+				if err := os.Truncate(path+sdbm.PAGFEXT, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts:    sdbm.Options{Flags: os.O_RDONLY, RequireHeader: true},
+			wantErr: sdbm.ErrBadMagic,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			tt.prepare(t, path)
+			db, err := sdbm.OpenWithOptions(path, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer teardown(t, db)
+			if got, err := db.Fetch(sdbm.Datum("key")); err != nil || got.String() != "val" {
+				t.Errorf("Fetch() got = %q, %v, want %q", got, err, "val")
+			}
+		})
+	}
+}
+
+func TestOpenWithOptions_ByteOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   sdbm.Options
+		reopen sdbm.Options // options the files are opened with again
+	}{
+		{name: "little-endian", opts: sdbm.Options{ByteOrder: binary.LittleEndian}},
+		{name: "big-endian", opts: sdbm.Options{ByteOrder: binary.BigEndian}, reopen: sdbm.Options{ByteOrder: binary.BigEndian}},
+		{name: "big-endian with a header", opts: sdbm.Options{ByteOrder: binary.BigEndian, FormatHeader: true}},
+		{name: "big-endian with checksums", opts: sdbm.Options{ByteOrder: binary.BigEndian, Checksums: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			pairs := generatePairs("key", "val", 2000)
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			teardown(t, db)
+
+			// the entry count of page 0 is written in the byte order asked for.
+			raw, err := os.ReadFile(path + sdbm.PAGFEXT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.opts.FormatHeader || tt.opts.Checksums {
+				raw = raw[sdbm.PBLKSIZ:]
+			}
+			if n := tt.opts.ByteOrder.Uint16(raw); n == 0 || n%2 != 0 || int(n) > sdbm.PBLKSIZ/2 {
+				t.Fatalf("page 0 entry count read %s got = %d, want a valid count", tt.opts.ByteOrder, n)
+			}
+
+			reopen := tt.reopen
+			reopen.Flags = os.O_RDONLY
+			db, err = sdbm.OpenWithOptions(path, reopen)
+			if err != nil {
+				t.Fatalf("failed to reopen db: %v", err)
+			}
+			defer teardown(t, db)
+			if info, err := db.FormatInfo(); err != nil || info.ByteOrder != tt.opts.ByteOrder {
+				t.Errorf("FormatInfo() byte order got = %v, %v, want %v", info.ByteOrder, err, tt.opts.ByteOrder)
+			}
+			for _, p := range pairs {
+				if got, err := db.Fetch(p.Key); err != nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+			if err := db.Verify(); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenWithOptions_ByteOrderMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, ByteOrder: binary.BigEndian})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	teardown(t, db)
+
+	// a headerless file does not tell its byte order, but its pages do.
+	db, err = sdbm.Open(path, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	_, err = db.Fetch(sdbm.Datum("key"))
+	if !errors.Is(err, sdbm.ErrByteOrder) || !errors.Is(err, sdbm.ErrInvalidPage) {
+		t.Errorf("Fetch() error = %v, want %v and %v", err, sdbm.ErrByteOrder, sdbm.ErrInvalidPage)
+	}
+
+	// a header does, and the options must agree with it.
+	hdr := filepath.Join(t.TempDir(), DBMFile)
+	db, err = sdbm.OpenWithOptions(hdr, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, ByteOrder: binary.BigEndian, FormatHeader: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	teardown(t, db)
+	_, err = sdbm.OpenWithOptions(hdr, sdbm.Options{Flags: os.O_RDONLY, ByteOrder: binary.LittleEndian})
+	if !errors.Is(err, sdbm.ErrUnsupportedFormat) {
+		t.Errorf("OpenWithOptions() with the other byte order error = %v, want %v", err, sdbm.ErrUnsupportedFormat)
+	}
+}
@@ -0,0 +1,247 @@
+// Package gdbm provides a best-effort, read-only reader for GNU dbm files.
+//
+// The reader understands the standard gdbm layout written by gdbm 1.x: a
+// file header, a hash directory of bucket addresses, and hash buckets whose
+// elements point at the key and data bytes. Both 32-bit and 64-bit file
+// offsets are supported, in either byte order, as are the numsync variants
+// of the header introduced by gdbm 1.21.
+//
+// Limitations: files can only be read, never written; files using the
+// original (pre-1.8) magic number, and crash-tolerance snapshots, are not
+// supported; and bytes of a key are hashed as signed chars, as gdbm does on
+// most platforms, so lookups of keys with bytes >= 0x80 in files written on
+// unsigned-char platforms fail. Use ForEach to reach such keys.
+package gdbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+const (
+	magic32        = 0x13579acd
+	magic64        = 0x13579acf
+	magicNumsync32 = 0x13579ad0
+	magicNumsync64 = 0x13579ad1
+
+	smallKey    = 4 // bytes of the key kept in a bucket element
+	bucketAvail = 6 // avail entries kept in a bucket
+)
+
+// ErrBadMagic indicates that the file is not a gdbm file this package can read.
+var ErrBadMagic = errors.New("gdbm: bad magic number")
+
+// ErrCorrupt indicates that the file structure is inconsistent.
+var ErrCorrupt = errors.New("gdbm: corrupt file")
+
+// DB is a gdbm database opened for reading.
+type DB struct {
+	f           *os.File
+	order       binary.ByteOrder
+	offSize     int     // size of a file offset: 4 or 8
+	dir         []int64 // bucket address for each directory entry
+	dirBits     int
+	bucketSize  int
+	bucketElems int
+}
+
+// Open opens the gdbm file at path for reading.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{f: f}
+	if err := db.readHeader(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close closes the file.
+func (db *DB) Close() error {
+	return db.f.Close()
+}
+
+func (db *DB) readHeader() error {
+	var m [4]byte
+	if _, err := db.f.ReadAt(m[:], 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadMagic, err)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(m[:]) {
+		case magic32, magicNumsync32:
+			db.order, db.offSize = order, 4
+		case magic64, magicNumsync64:
+			db.order, db.offSize = order, 8
+		}
+	}
+	if db.order == nil {
+		return ErrBadMagic
+	}
+
+	// magic, block_size, dir, dir_size, dir_bits, bucket_size, bucket_elems.
+	hdr := make([]byte, 8+db.offSize+16)
+	if _, err := db.f.ReadAt(hdr, 0); err != nil {
+		return fmt.Errorf("%w: header: %v", ErrCorrupt, err)
+	}
+	dirAddr := db.off(hdr[8:])
+	p := 8 + db.offSize
+	dirSize := db.int(hdr[p:])
+	db.dirBits = db.int(hdr[p+4:])
+	db.bucketSize = db.int(hdr[p+8:])
+	db.bucketElems = db.int(hdr[p+12:])
+	if dirSize <= 0 || db.dirBits < 0 || db.dirBits > 31 || dirSize/db.offSize != 1<<db.dirBits ||
+		db.bucketElems <= 0 || db.bucketSize < db.elemOffset(db.bucketElems) {
+		return fmt.Errorf("%w: header", ErrCorrupt)
+	}
+
+	raw := make([]byte, dirSize)
+	if _, err := db.f.ReadAt(raw, dirAddr); err != nil {
+		return fmt.Errorf("%w: directory: %v", ErrCorrupt, err)
+	}
+	db.dir = make([]int64, 1<<db.dirBits)
+	for i := range db.dir {
+		db.dir[i] = db.off(raw[i*db.offSize:])
+	}
+	return nil
+}
+
+func (db *DB) int(b []byte) int {
+	return int(int32(db.order.Uint32(b)))
+}
+
+func (db *DB) off(b []byte) int64 {
+	if db.offSize == 4 {
+		return int64(int32(db.order.Uint32(b)))
+	}
+	return int64(db.order.Uint64(b))
+}
+
+// elemSize is the size of a bucket element: hash_value, key_start,
+// data_pointer, key_size and data_size.
+func (db *DB) elemSize() int {
+	return 4 + smallKey + db.offSize + 8
+}
+
+// elemOffset returns the offset of element i within a bucket. The bucket
+// starts with av_count and the avail table (each entry an int and an
+// offset, aligned to the offset size), then bucket_bits and count.
+func (db *DB) elemOffset(i int) int {
+	avail := db.offSize + bucketAvail*2*db.offSize
+	if db.offSize == 4 {
+		avail = 4 + bucketAvail*8
+	}
+	return avail + 8 + i*db.elemSize()
+}
+
+type element struct {
+	hash    int32
+	start   [smallKey]byte
+	dataPtr int64
+	keySize int
+	valSize int
+}
+
+func (db *DB) readBucket(addr int64) ([]element, error) {
+	raw := make([]byte, db.elemOffset(db.bucketElems))
+	if _, err := db.f.ReadAt(raw, addr); err != nil {
+		return nil, fmt.Errorf("%w: bucket at %d: %v", ErrCorrupt, addr, err)
+	}
+	elems := make([]element, db.bucketElems)
+	for i := range elems {
+		b := raw[db.elemOffset(i):]
+		e := &elems[i]
+		e.hash = int32(db.order.Uint32(b))
+		copy(e.start[:], b[4:])
+		e.dataPtr = db.off(b[4+smallKey:])
+		e.keySize = db.int(b[4+smallKey+db.offSize:])
+		e.valSize = db.int(b[8+smallKey+db.offSize:])
+		if e.hash != -1 && (e.keySize < 0 || e.valSize < 0) {
+			return nil, fmt.Errorf("%w: bucket at %d", ErrCorrupt, addr)
+		}
+	}
+	return elems, nil
+}
+
+func (db *DB) readPair(e element) (key, val sdbm.Datum, err error) {
+	buf := make([]byte, e.keySize+e.valSize)
+	if _, err := db.f.ReadAt(buf, e.dataPtr); err != nil {
+		return nil, nil, fmt.Errorf("%w: data at %d: %v", ErrCorrupt, e.dataPtr, err)
+	}
+	return buf[:e.keySize:e.keySize], buf[e.keySize:], nil
+}
+
+// Hash is the gdbm hash function. It returns a 31-bit value.
+func Hash(key []byte) int32 {
+	value := uint32(0x238F13AF) * uint32(len(key))
+	for i, c := range key {
+		value = (value + uint32(int32(int8(c))<<(uint(i)*5%24))) & 0x7FFFFFFF
+	}
+	value = (1103515243*value + 12345) & 0x7FFFFFFF
+	return int32(value)
+}
+
+// Fetch returns the value stored under key, or nil if the key is not present.
+func (db *DB) Fetch(key sdbm.Datum) (sdbm.Datum, error) {
+	hash := Hash(key)
+	elems, err := db.readBucket(db.dir[uint32(hash)>>(31-db.dirBits)])
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(hash) % db.bucketElems
+	for i := 0; i < db.bucketElems; i++ {
+		e := elems[(start+i)%db.bucketElems]
+		if e.hash == -1 {
+			break
+		}
+		if e.hash != hash || e.keySize != len(key) ||
+			!bytes.Equal(e.start[:min(smallKey, len(key))], key[:min(smallKey, len(key))]) {
+			continue
+		}
+		k, v, err := db.readPair(e)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(k, key) {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// ForEach calls fn for every pair in the database, bucket by bucket, until
+// fn returns an error, which ForEach then returns.
+func (db *DB) ForEach(fn func(key, val sdbm.Datum) error) error {
+	seen := make(map[int64]bool)
+	for _, addr := range db.dir {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		elems, err := db.readBucket(addr)
+		if err != nil {
+			return err
+		}
+		for _, e := range elems {
+			if e.hash == -1 {
+				continue
+			}
+			k, v, err := db.readPair(e)
+			if err != nil {
+				return err
+			}
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+	"github.com/vvatanabe/go-sdbm/gdbm"
+)
+
+var fruits = map[string]string{
+	"apple": "red", "banana": "yellow", "cherry": "dark red", "date": "brown",
+	"elderberry": "purple", "fig": "green", "grape": "violet", "kiwi": "fuzzy",
+}
+
+func TestDB_Fetch(t *testing.T) {
+	db, err := gdbm.Open(filepath.Join("testdata", "fruits.gdbm"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	for key, want := range fruits {
+		got, err := db.Fetch(sdbm.Datum(key))
+		if err != nil {
+			t.Fatalf("Fetch(%s) error = %v", key, err)
+		}
+		if got.String() != want {
+			t.Errorf("Fetch(%s) got = %q, want %q", key, got, want)
+		}
+	}
+	got, err := db.Fetch(sdbm.Datum("mango"))
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("Fetch(mango) got = %q, want nil", got)
+	}
+}
+
+func TestDB_ForEach(t *testing.T) {
+	db, err := gdbm.Open(filepath.Join("testdata", "fruits.gdbm"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	seen := make(map[string]string)
+	err = db.ForEach(func(key, val sdbm.Datum) error {
+		seen[key.String()] = val.String()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach() error = %v", err)
+	}
+	if len(seen) != len(fruits) {
+		t.Errorf("ForEach() pairs got = %d, want %d", len(seen), len(fruits))
+	}
+	for key, want := range fruits {
+		if seen[key] != want {
+			t.Errorf("ForEach() %s got = %q, want %q", key, seen[key], want)
+		}
+	}
+}
+
+func TestOpen_BadMagic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not.gdbm")
+	if err := os.WriteFile(path, []byte("this is not a gdbm file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gdbm.Open(path); !errors.Is(err, gdbm.ErrBadMagic) {
+		t.Errorf("Open() error = %v, wantErr %v", err, gdbm.ErrBadMagic)
+	}
+}
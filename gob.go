@@ -0,0 +1,56 @@
+package sdbm
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// StoreGob stores v, encoded with encoding/gob, under key, as Store does
+// with flags. Like Store it fails with ErrDBMRDOnly on a read-only database
+// and with ErrValueTooBig if key and the encoded value exceed PairMax,
+// unless the database stores large values elsewhere. Each value is encoded
+// on its own, so it carries the description of its type.
+func (db *DBM) StoreGob(key Datum, v any, flags StoreFlags) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return false, err
+	}
+	return db.Store(key, buf.Bytes(), flags)
+}
+
+// FetchGob decodes the value stored under key by StoreGob into out, which
+// must be a pointer, and reports whether key was present. out is left
+// alone if it was not.
+func (db *DBM) FetchGob(key Datum, out any) (bool, error) {
+	val, err := db.Fetch(key)
+	if err != nil || val == nil {
+		return false, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(val)).Decode(out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GobCodec returns a Codec storing values of type T with encoding/gob. Each
+// value is encoded on its own, type description included, so gob values
+// are larger than their JSON equivalent for small structs.
+func GobCodec[T any]() Codec[T] {
+	return Codec[T]{
+		Marshal: func(v T) (Datum, error) {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		Unmarshal: func(d Datum) (T, error) {
+			var v T
+			err := gob.NewDecoder(bytes.NewReader(d)).Decode(&v)
+			return v, err
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package sdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_StoreGob(t *testing.T) {
+	dir, dbm := setup(t)
+
+	tests := []struct {
+		name    string
+		key     string
+		val     user
+		wantErr error
+	}{
+		{name: "struct", key: "alice", val: user{Name: "Alice", Age: 30}},
+		{name: "zero value", key: "nobody", val: user{}},
+		{name: "too big", key: "big", val: user{Name: strings.Repeat("x", sdbm.PAIRMAX)}, wantErr: sdbm.ErrValueTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dbm.StoreGob(sdbm.Datum(tt.key), tt.val, sdbm.StoreREPLACE)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StoreGob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			var got user
+			if ok, err := dbm.FetchGob(sdbm.Datum(tt.key), &got); err != nil || !ok || got != tt.val {
+				t.Errorf("FetchGob() got = %v, %v, %v, want %v, true", got, ok, err, tt.val)
+			}
+		})
+	}
+
+	got := user{Name: "unchanged"}
+	if ok, err := dbm.FetchGob(sdbm.Datum("absent"), &got); err != nil || ok || got.Name != "unchanged" {
+		t.Errorf("FetchGob() of an absent key got = %v, %v, %v, want unchanged, false", got, ok, err)
+	}
+	if _, err := dbm.Store(sdbm.Datum("plain"), sdbm.Datum("not gob"), sdbm.StoreREPLACE); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbm.FetchGob(sdbm.Datum("plain"), &got); err == nil {
+		t.Errorf("FetchGob() of a value not stored by StoreGob error = nil, want an error")
+	}
+	teardown(t, dbm)
+
+	dbm, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+	if _, err := dbm.StoreGob(sdbm.Datum("alice"), user{}, sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("StoreGob() on a read-only db error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+	if ok, err := dbm.FetchGob(sdbm.Datum("alice"), &got); err != nil || !ok || got.Name != "Alice" {
+		t.Errorf("FetchGob() on a read-only db got = %v, %v, %v, want Alice", got, ok, err)
+	}
+}
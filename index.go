@@ -0,0 +1,248 @@
+package sdbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// IndexedDBM stores pairs in a primary database and keeps a secondary index
+// over their values in a second database. An extractor derives the index
+// keys of a value; the index maps each index key to the primary keys whose
+// values produced it. Every write must go through the IndexedDBM for the
+// index to stay in step with the primary database.
+//
+// The primary keys of an index key are listed in a single pair of the
+// index, two bytes plus the length of each key, so that pair is limited to
+// PairMax of the index database, unless it stores large values elsewhere:
+// a few hundred short keys may share an index key, but not more. A Store
+// that would outgrow the limit fails with ErrValueTooBig and leaves both
+// databases alone.
+type IndexedDBM struct {
+	db      *DBM
+	idx     *DBM
+	extract func(val Datum) []Datum
+}
+
+// NewIndexedDBM returns an IndexedDBM storing pairs in db and the index in
+// idx, using extract to derive the index keys of a value.
+func NewIndexedDBM(db, idx *DBM, extract func(val Datum) []Datum) *IndexedDBM {
+	return &IndexedDBM{db: db, idx: idx, extract: extract}
+}
+
+// Fetch retrieves the value stored under key in the primary database.
+func (x *IndexedDBM) Fetch(key Datum) (Datum, error) {
+	return x.db.Fetch(key)
+}
+
+// Store stores val under key, replacing any existing value, and updates the
+// index: key is removed from the index keys only the old value produced and
+// added to those only the new value produces. The updated key lists are
+// checked against the size limit before the primary database is written,
+// and if writing the index fails all the same, the lists already written
+// and the old value are restored.
+func (x *IndexedDBM) Store(key, val Datum) error {
+	old, err := x.db.Fetch(key)
+	if err != nil {
+		return err
+	}
+	old = cloneDatum(old)
+	var oldIdx []Datum
+	if old != nil {
+		oldIdx = x.extract(old)
+	}
+	newIdx := x.extract(val)
+
+	updates, err := x.planIndex(key, oldIdx, newIdx)
+	if err != nil {
+		return err
+	}
+	if _, err := x.db.Store(key, val, StoreREPLACE); err != nil {
+		return err
+	}
+	if err := x.writeIndex(updates); err != nil {
+		if old != nil {
+			_, _ = x.db.Store(key, old, StoreREPLACE)
+		} else {
+			_, _ = x.db.Delete(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// indexUpdate is the encoded key list to store under index key ik, or
+// remove if it is empty, and the list it replaces.
+type indexUpdate struct {
+	ik   Datum
+	list Datum
+	prev Datum
+}
+
+// Delete removes key from the primary database and from the index. The
+// updated key lists are read before the primary database is written, and if
+// writing the index fails, the lists already written and the pair are
+// restored and Delete reports false. It reports true with the error only
+// if the pair could not be restored.
+func (x *IndexedDBM) Delete(key Datum) (bool, error) {
+	old, err := x.db.Fetch(key)
+	if err != nil || old == nil {
+		return false, err
+	}
+	old = cloneDatum(old)
+
+	updates, err := x.planIndex(key, x.extract(old), nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err := x.db.Delete(key); err != nil {
+		return false, err
+	}
+	if err := x.writeIndex(updates); err != nil {
+		if _, rerr := x.db.Store(key, old, StoreREPLACE); rerr != nil {
+			return true, errors.Join(err, rerr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Lookup returns the primary keys whose values produced indexKey.
+func (x *IndexedDBM) Lookup(indexKey Datum) ([]Datum, error) {
+	list, err := x.idx.Fetch(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeyList(list)
+}
+
+// planIndex returns the updates that remove key from the index keys only
+// oldIdx has and add it to those only newIdx has, each checked against the
+// size limit. Nothing is written.
+func (x *IndexedDBM) planIndex(key Datum, oldIdx, newIdx []Datum) ([]indexUpdate, error) {
+	var updates []indexUpdate
+	plan := func(ik Datum, add bool) error {
+		u, changed, err := x.listUpdate(ik, key, add)
+		if err != nil || !changed {
+			return err
+		}
+		updates = append(updates, u)
+		return x.checkList(ik, u.list)
+	}
+	for _, ik := range oldIdx {
+		if !containsDatum(newIdx, ik) {
+			if err := plan(ik, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, ik := range newIdx {
+		if !containsDatum(oldIdx, ik) {
+			if err := plan(ik, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return updates, nil
+}
+
+// writeIndex writes updates in order. If one fails, the lists written
+// before it are put back as they were.
+func (x *IndexedDBM) writeIndex(updates []indexUpdate) error {
+	for i, u := range updates {
+		if err := x.writeList(u.ik, u.list); err != nil {
+			for _, w := range slices.Backward(updates[:i]) {
+				_ = x.writeList(w.ik, w.prev)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// listUpdate returns the update adding key to, or removing it from, the
+// list stored under ik, and whether that changes the list.
+func (x *IndexedDBM) listUpdate(ik, key Datum, add bool) (indexUpdate, bool, error) {
+	list, err := x.idx.Fetch(ik)
+	if err != nil {
+		return indexUpdate{}, false, err
+	}
+	list = cloneDatum(list)
+	keys, err := decodeKeyList(list)
+	if err != nil {
+		return indexUpdate{}, false, err
+	}
+
+	i := indexDatum(keys, key)
+	switch {
+	case add && i < 0:
+		keys = append(keys, key)
+	case !add && i >= 0:
+		keys = append(keys[:i], keys[i+1:]...)
+	default:
+		return indexUpdate{}, false, nil
+	}
+	return indexUpdate{ik: ik, list: encodeKeyList(keys), prev: list}, true, nil
+}
+
+// checkList fails with ErrValueTooBig if the index cannot store list under
+// ik.
+func (x *IndexedDBM) checkList(ik, list Datum) error {
+	idx := x.idx
+	if limit := idx.PairMax(); idx.blobs == nil && idx.ovf == nil && ik.Size()+list.Size() > limit {
+		return fmt.Errorf("%w: index key %q lists %d bytes of keys, over PairMax (%d bytes)",
+			ErrValueTooBig, ik, list.Size(), limit)
+	}
+	return nil
+}
+
+// writeList stores list under ik, or removes ik if list is empty.
+func (x *IndexedDBM) writeList(ik, list Datum) error {
+	if list.Size() == 0 {
+		_, err := x.idx.Delete(ik)
+		return err
+	}
+	_, err := x.idx.Store(ik, list, StoreREPLACE)
+	return err
+}
+
+// encodeKeyList encodes keys as a sequence of 2-byte length-prefixed keys.
+func encodeKeyList(keys []Datum) Datum {
+	var buf []byte
+	for _, k := range keys {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(k.Size()))
+		buf = append(buf, k...)
+	}
+	return buf
+}
+
+func decodeKeyList(list Datum) ([]Datum, error) {
+	var keys []Datum
+	for len(list) > 0 {
+		if len(list) < 2 {
+			return nil, ErrInvalidPage
+		}
+		n := int(binary.LittleEndian.Uint16(list))
+		if len(list) < 2+n {
+			return nil, ErrInvalidPage
+		}
+		keys = append(keys, cloneDatum(list[2:2+n]))
+		list = list[2+n:]
+	}
+	return keys, nil
+}
+
+func indexDatum(list []Datum, d Datum) int {
+	for i, x := range list {
+		if bytes.Equal(x, d) {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsDatum(list []Datum, d Datum) bool {
+	return indexDatum(list, d) >= 0
+}
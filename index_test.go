@@ -0,0 +1,185 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestIndexedDBM(t *testing.T) {
+	_, db := setup(t)
+	defer teardown(t, db)
+	_, idx := setup(t)
+	defer teardown(t, idx)
+
+	// values are "name:city"; the index is on the city.
+	x := sdbm.NewIndexedDBM(db, idx, func(val sdbm.Datum) []sdbm.Datum {
+		_, city, ok := bytes.Cut(val, []byte(":"))
+		if !ok {
+			return nil
+		}
+		return []sdbm.Datum{city}
+	})
+	lookup := func(city string) []string {
+		t.Helper()
+		keys, err := x.Lookup(sdbm.Datum(city))
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		var got []string
+		for _, k := range keys {
+			got = append(got, k.String())
+		}
+		sort.Strings(got)
+		return got
+	}
+	for _, kv := range [][2]string{{"u1", "alice:tokyo"}, {"u2", "bob:osaka"}, {"u3", "carol:tokyo"}} {
+		if err := x.Store(sdbm.Datum(kv[0]), sdbm.Datum(kv[1])); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if got := lookup("tokyo"); strings.Join(got, ",") != "u1,u3" {
+		t.Errorf("Lookup(tokyo) after insert got = %v, want [u1 u3]", got)
+	}
+
+	// moving u1 reindexes it.
+	if err := x.Store(sdbm.Datum("u1"), sdbm.Datum("alice:osaka")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if got := lookup("tokyo"); strings.Join(got, ",") != "u3" {
+		t.Errorf("Lookup(tokyo) after update got = %v, want [u3]", got)
+	}
+	if got := lookup("osaka"); strings.Join(got, ",") != "u1,u2" {
+		t.Errorf("Lookup(osaka) after update got = %v, want [u1 u2]", got)
+	}
+
+	if ok, err := x.Delete(sdbm.Datum("u3")); err != nil || !ok {
+		t.Fatalf("Delete() got = %v, %v, want true", ok, err)
+	}
+	if got := lookup("tokyo"); len(got) != 0 {
+		t.Errorf("Lookup(tokyo) after delete got = %v, want none", got)
+	}
+	if val, err := x.Fetch(sdbm.Datum("u3")); err != nil || val != nil {
+		t.Errorf("Fetch() after delete got = %v, %v, want nil", val, err)
+	}
+}
+
+func TestIndexedDBM_ListLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "checked before the write"},
+		// the interned index fails the write itself, which is rolled back.
+		{name: "rolled back", opts: sdbm.Options{InternValues: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, db := setup(t)
+			defer teardown(t, db)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			idx, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), "idx"), opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, idx)
+
+			// every value produces the same index key.
+			x := sdbm.NewIndexedDBM(db, idx, func(sdbm.Datum) []sdbm.Datum {
+				return []sdbm.Datum{sdbm.Datum("all")}
+			})
+			var stored int
+			for i := 0; ; i++ {
+				key := sdbm.Datum(fmt.Sprintf("key%04d", i))
+				err := x.Store(key, sdbm.Datum("val"))
+				if err == nil {
+					stored++
+					continue
+				}
+				if !errors.Is(err, sdbm.ErrValueTooBig) {
+					t.Fatalf("Store() error = %v, want %v", err, sdbm.ErrValueTooBig)
+				}
+				if got, err := x.Fetch(key); err != nil || got != nil {
+					t.Errorf("Fetch(%s) after a failed Store() got = %q, %v, want nil", key, got, err)
+				}
+				break
+			}
+			keys, err := x.Lookup(sdbm.Datum("all"))
+			if err != nil || len(keys) != stored {
+				t.Errorf("Lookup() got %d keys, %v, want %d", len(keys), err, stored)
+			}
+			if n, err := db.Count(); err != nil || n != stored {
+				t.Errorf("Count() of the primary got = %d, %v, want %d", n, err, stored)
+			}
+		})
+	}
+}
+
+func TestIndexedDBM_Rollback(t *testing.T) {
+	_, db := setup(t)
+	defer teardown(t, db)
+	idxPath := filepath.Join(t.TempDir(), "idx")
+	idx, err := sdbm.OpenWithOptions(idxPath, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, InternValues: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, idx)
+
+	// each value is indexed under itself, then under a key shared by all,
+	// whose list eventually outgrows the value store.
+	extract := func(val sdbm.Datum) []sdbm.Datum {
+		return []sdbm.Datum{val, sdbm.Datum("all")}
+	}
+	x := sdbm.NewIndexedDBM(db, idx, extract)
+	var failed sdbm.Datum
+	for i := 0; failed == nil; i++ {
+		key := sdbm.Datum(fmt.Sprintf("key%04d", i))
+		err := x.Store(key, key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sdbm.ErrValueTooBig) {
+			t.Fatalf("Store() error = %v, want %v", err, sdbm.ErrValueTooBig)
+		}
+		failed = key
+	}
+	if keys, err := x.Lookup(failed); err != nil || len(keys) != 0 {
+		t.Errorf("Lookup(%s) after a failed Store() got = %q, %v, want none", failed, keys, err)
+	}
+	if got, err := x.Fetch(failed); err != nil || got != nil {
+		t.Errorf("Fetch(%s) after a failed Store() got = %q, %v, want nil", failed, got, err)
+	}
+
+	// an index that cannot be written leaves the pair in place.
+	_, db2 := setup(t)
+	defer teardown(t, db2)
+	roPath := filepath.Join(t.TempDir(), "ro")
+	ro, err := sdbm.Open(roPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	key := sdbm.Datum("key")
+	if err := sdbm.NewIndexedDBM(db2, ro, extract).Store(key, key); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	teardown(t, ro)
+	ro, err = sdbm.OpenReadOnly(roPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, ro)
+	if ok, err := sdbm.NewIndexedDBM(db2, ro, extract).Delete(key); !errors.Is(err, sdbm.ErrDBMRDOnly) || ok {
+		t.Errorf("Delete() with a read-only index got = %v, %v, want false, %v", ok, err, sdbm.ErrDBMRDOnly)
+	}
+	if got, err := db2.Fetch(key); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Fetch(%s) after a failed Delete() got = %q, %v, want %q", key, got, err, key)
+	}
+}
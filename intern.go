@@ -0,0 +1,136 @@
+package sdbm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// blobSuffix is appended to the database name to name the blob store used
+// by Options.InternValues.
+const blobSuffix = ".blob"
+
+// refcntSize is the size of the reference count prefixed to every blob.
+const refcntSize = 8
+
+func (db *DBM) openBlobs(file string) error {
+	blobs := &DBM{}
+	opts := Options{
+		Flags:        db.opts.Flags,
+		Mode:         db.opts.Mode,
+		ReadOnly:     db.opts.ReadOnly,
+		SyncOnWrite:  db.opts.SyncOnWrite,
+		PageSize:     db.opts.PageSize,
+		DirBlockSize: db.opts.DirBlockSize,
+		ByteOrder:    db.opts.ByteOrder,
+		ZeroFill:     db.opts.ZeroFill,
+	}
+	if err := blobs.init(file+blobSuffix+DIRFEXT, file+blobSuffix+PAGFEXT, opts); err != nil {
+		return err
+	}
+	db.blobs = blobs
+	return nil
+}
+
+// resolveBlob returns the value a reference stored in the database points at.
+func (db *DBM) resolveBlob(ref Datum) (Datum, error) {
+	blob, err := db.blobs.Fetch(ref)
+	if err != nil {
+		return Nullitem, err
+	}
+	if blob.Size() < refcntSize {
+		return Nullitem, ErrInvalidPage
+	}
+	return blob[refcntSize:], nil
+}
+
+// adjustBlob adds delta to the reference count of the blob for ref, creating
+// the blob from val when it does not exist yet and removing it when the count
+// drops to zero.
+func (db *DBM) adjustBlob(ref, val Datum, delta int64) error {
+	blob, err := db.blobs.Fetch(ref)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if blob != nil {
+		count = int64(binary.LittleEndian.Uint64(blob))
+		val = cloneDatum(blob[refcntSize:])
+	}
+	count += delta
+	if count <= 0 {
+		_, err := db.blobs.Delete(ref)
+		return err
+	}
+
+	buf := make(Datum, refcntSize+val.Size())
+	binary.LittleEndian.PutUint64(buf, uint64(count))
+	copy(buf[refcntSize:], val)
+	_, err = db.blobs.Store(ref, buf, StoreREPLACE)
+	return err
+}
+
+// storeInterned implements Store for Options.InternValues. The new blob is
+// referenced before the key points at it, and the replaced blob is released
+// only after, so a failure never leaves a key pointing at a missing blob.
+func (db *DBM) storeInterned(key, val Datum, flags StoreFlags) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	sum := sha256.Sum256(val)
+	ref := Datum(sum[:])
+
+	old, err := db.fetch(key)
+	if err != nil {
+		return false, err
+	}
+	old = cloneDatum(old)
+	if old != nil && flags == StoreSEEDUPS {
+		return true, nil
+	}
+	if old != nil && flags == StoreINSERT {
+		return false, nil
+	}
+
+	if err := db.audit(AuditStore, db.normKey(key), val.Size()); err != nil {
+		return false, err
+	}
+	if err := db.adjustBlob(ref, val, 1); err != nil {
+		return false, err
+	}
+	ok, err := db.store(key, ref, flags)
+	if err == nil {
+		err = db.flush()
+	}
+	if err != nil {
+		_ = db.adjustBlob(ref, nil, -1)
+		return false, err
+	}
+	if old != nil && flags == StoreREPLACE {
+		if err := db.adjustBlob(old, nil, -1); err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+// deleteInterned implements Delete for Options.InternValues.
+func (db *DBM) deleteInterned(key Datum) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	ref, err := db.fetch(key)
+	if err != nil || ref == nil {
+		return false, err
+	}
+	ref = cloneDatum(ref)
+
+	ok, err := db.del(key)
+	if err == nil && ok {
+		err = db.flush()
+	}
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, db.adjustBlob(ref, nil, -1)
+}
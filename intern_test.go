@@ -0,0 +1,96 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_InternValues(t *testing.T) {
+	val := sdbm.Datum(bytes.Repeat([]byte("v"), 500))
+	pagSize := func(path string) int64 {
+		t.Helper()
+		fi, err := os.Stat(path + sdbm.PAGFEXT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "plain")
+	plain, err := sdbm.Open(plainPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, plain)
+	internPath := filepath.Join(dir, "interned")
+	interned, err := sdbm.OpenWithOptions(internPath, sdbm.Options{
+		Flags:        os.O_RDWR | os.O_CREATE,
+		Mode:         0644,
+		InternValues: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, interned)
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		key := sdbm.Datum("key" + strconv.Itoa(i))
+		for _, db := range []*sdbm.DBM{plain, interned} {
+			if _, err := db.Store(key, val, sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+		}
+	}
+	if _, err := interned.Store(sdbm.Datum("other"), sdbm.Datum("other"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	total := pagSize(internPath) + pagSize(internPath+".blob")
+	if total*4 > pagSize(plainPath) {
+		t.Errorf("interned size got = %d, want well below plain size %d", total, pagSize(plainPath))
+	}
+
+	for i := 0; i < n; i++ {
+		got, err := interned.Fetch(sdbm.Datum("key" + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !bytes.Equal(got, val) {
+			t.Fatalf("Fetch() got = %q, want %q", got, val)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if ok, err := interned.Delete(sdbm.Datum("key" + strconv.Itoa(i))); err != nil || !ok {
+			t.Fatalf("Delete() got = %v, %v, want true", ok, err)
+		}
+	}
+	got, err := interned.Fetch(sdbm.Datum("other"))
+	if err != nil || got.String() != "other" {
+		t.Errorf("Fetch() got = %q, %v, want %q", got, err, "other")
+	}
+
+	// only the blob still referenced by "other" may remain.
+	blobs, err := sdbm.OpenWithOptions(internPath+".blob", sdbm.Options{NoLock: true})
+	if err != nil {
+		t.Fatalf("failed to open blob store: %v", err)
+	}
+	defer teardown(t, blobs)
+	count := 0
+	for key, err := blobs.FirstKey(); key != nil; key, err = blobs.NextKey() {
+		if err != nil {
+			t.Fatalf("NextKey() error = %v", err)
+		}
+		count++
+	}
+	if count != 1 {
+		t.Errorf("blobs left got = %d, want %d", count, 1)
+	}
+}
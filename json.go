@@ -0,0 +1,69 @@
+package sdbm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonPair is a pair as written by ExportJSON. encoding/json writes the
+// byte slices as base64 strings.
+type jsonPair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// ExportJSON writes every pair of the database to w as a JSON array of
+// {"key": ..., "value": ...} objects, one per line, with the keys and values
+// base64-encoded. The pairs are encoded one at a time rather than collected
+// first. As with WriteTo, the values are those Fetch returns. It is meant
+// for inspecting and hand-editing small databases; ImportJSON reads it back.
+func (db *DBM) ExportJSON(w io.Writer) error {
+	if _, err := io.WriteString(w, "[\n"); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	sep := ""
+	err := db.forEachValue(func(key, val Datum) error {
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ","
+		return enc.Encode(jsonPair{Key: key, Value: val})
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// ImportJSON reads an array written by ExportJSON from r and stores each of
+// its pairs with flags, as Store does, decoding one pair at a time. It stops
+// at the first pair that fails to decode or to store and returns the error;
+// the pairs stored before stay stored.
+func (db *DBM) ImportJSON(r io.Reader, flags StoreFlags) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("%w: JSON export must be an array, got %v", ErrInvalidArgument, tok)
+	}
+	for i := 0; dec.More(); i++ {
+		var p jsonPair
+		if err := dec.Decode(&p); err != nil {
+			return fmt.Errorf("pair %d: %w", i, err)
+		}
+		if p.Key == nil {
+			return fmt.Errorf("pair %d: %w: no key", i, ErrInvalidArgument)
+		}
+		if p.Value == nil {
+			p.Value = Datum{}
+		}
+		if _, err := db.Store(p.Key, p.Value, flags); err != nil {
+			return fmt.Errorf("pair %d: %w", i, err)
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
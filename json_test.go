@@ -0,0 +1,99 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_ExportJSON_ImportJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "empty key and value", pairs: []Pair{{Key: sdbm.Datum{}, Val: sdbm.Datum{}}}},
+		{name: "binary", pairs: []Pair{{Key: sdbm.Datum{0, 0xff}, Val: sdbm.Datum{'\n', 0}}}},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := setupMem(t, tt.pairs...)
+			defer teardown(t, src)
+
+			var buf bytes.Buffer
+			if err := src.ExportJSON(&buf); err != nil {
+				t.Fatalf("ExportJSON() error = %v", err)
+			}
+			var decoded []map[string][]byte
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("ExportJSON() wrote invalid JSON: %v", err)
+			}
+			if len(decoded) != len(tt.pairs) {
+				t.Errorf("ExportJSON() wrote %d pairs, want %d", len(decoded), len(tt.pairs))
+			}
+
+			dst := setupMem(t)
+			defer teardown(t, dst)
+			if err := dst.ImportJSON(&buf, sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("ImportJSON() error = %v", err)
+			}
+			for _, p := range tt.pairs {
+				if got, err := dst.Fetch(p.Key); err != nil || got == nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%q) got = %q, %v, want %q", p.Key, got, err, p.Val)
+				}
+			}
+			if count, err := dst.Count(); err != nil || count != len(tt.pairs) {
+				t.Errorf("Count() got = %d, %v, want %d", count, err, len(tt.pairs))
+			}
+		})
+	}
+}
+
+func TestDBM_ImportJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      map[string]string
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name:  "hand-written",
+			input: `[{"key": "a2V5", "value": "dmFs"}, {"key": "b3RoZXI=", "value": ""}]`,
+			want:  map[string]string{"key": "val", "other": ""},
+		},
+		{name: "not an array", input: `{"key": "a2V5"}`, wantErr: true, wantErrIs: sdbm.ErrInvalidArgument},
+		{name: "missing key", input: `[{"value": "dmFs"}]`, wantErr: true, wantErrIs: sdbm.ErrInvalidArgument},
+		{
+			name:    "bad base64 after a stored pair",
+			input:   `[{"key": "a2V5", "value": "dmFs"}, {"key": "!!", "value": ""}]`,
+			want:    map[string]string{"key": "val"},
+			wantErr: true,
+		},
+		{name: "truncated", input: `[{"key": "a2V5", "value": "dmFs"}`, want: map[string]string{"key": "val"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbm := setupMem(t)
+			defer teardown(t, dbm)
+
+			err := dbm.ImportJSON(strings.NewReader(tt.input), sdbm.StoreREPLACE)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("ImportJSON() error = %v, want %v", err, tt.wantErrIs)
+			}
+			for key, val := range tt.want {
+				if got, err := dbm.Fetch(sdbm.Datum(key)); err != nil || got == nil || got.String() != val {
+					t.Errorf("Fetch(%s) got = %q, %v, want %q", key, got, err, val)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package sdbm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked indicates that the database is open in another handle that
+// excludes this one: a writer excludes every other handle, and readers
+// exclude writers.
+var ErrLocked = errors.New("database locked")
+
+// lockPag takes an advisory lock on the .pag file of a database opened from
+// disk: an exclusive lock for a writer and a shared one for a reader, so
+// that two writers, or a writer and a reader, cannot use the files at once.
+// The lock is held until unlockPag or until the file is closed.
+func lockPag(f *os.File, rdonly bool) error {
+	err := lockFile(f, !rdonly)
+	if errors.Is(err, errWouldBlock) {
+		return fmt.Errorf("%w: %s", ErrLocked, f.Name())
+	}
+	if err != nil {
+		return wrapIOErr("lock", f.Name(), err)
+	}
+	return nil
+}
+
+// unlockPag releases the lock taken by lockPag, if the .pag file is on disk.
+func (db *DBM) unlockPag() {
+	if f, ok := db.pagf.(osFile); ok {
+		_ = unlockFile(f.File)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows
+
+package sdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestOpen_Locked(t *testing.T) {
+	rdwr := sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644}
+	rdonly := sdbm.Options{}
+	tests := []struct {
+		name    string
+		first   sdbm.Options
+		second  sdbm.Options
+		wantErr error
+	}{
+		{name: "writer excludes a writer", first: rdwr, second: rdwr, wantErr: sdbm.ErrLocked},
+		{name: "writer excludes a reader", first: rdwr, second: rdonly, wantErr: sdbm.ErrLocked},
+		{name: "reader excludes a writer", first: rdonly, second: rdwr, wantErr: sdbm.ErrLocked},
+		{name: "readers share", first: rdonly, second: rdonly},
+		{name: "NoLock reader shares with a writer", first: rdwr, second: sdbm.Options{NoLock: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, db := setup(t, generatePairs("key", "val", 10)...)
+			teardown(t, db)
+			path := filepath.Join(dir, DBMFile)
+
+			first, err := sdbm.OpenWithOptions(path, tt.first)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			second, err := sdbm.OpenWithOptions(path, tt.second)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("second OpenWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				teardown(t, second)
+			}
+
+			// Close releases the lock.
+			teardown(t, first)
+			second, err = sdbm.OpenWithOptions(path, tt.second)
+			if err != nil {
+				t.Fatalf("OpenWithOptions() after Close error = %v", err)
+			}
+			teardown(t, second)
+		})
+	}
+}
@@ -0,0 +1,9 @@
+package sdbm
+
+// Logger receives the diagnostics of a database opened with Options.Logger:
+// page and directory block reads, trie walks and page splits. *log.Logger
+// satisfies it. A Logger shared by databases used from several goroutines
+// must be safe for concurrent use.
+type Logger interface {
+	Printf(format string, args ...any)
+}
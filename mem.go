@@ -0,0 +1,73 @@
+package sdbm
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// memName names the files of the databases returned by OpenMem.
+const memName = "(mem)"
+
+// memFile is a File held in a growable byte slice. The mutex lets a
+// Snapshot read it while the database writes to it.
+type memFile struct {
+	name string
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.grow(off + int64(len(p)))
+	return copy(f.data[off:], p), nil
+}
+
+// grow extends the file with zeros to size bytes, if it is shorter.
+func (f *memFile) grow(size int64) {
+	if n := size - int64(len(f.data)); n > 0 {
+		f.data = append(f.data, make([]byte, n)...)
+	}
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size]
+	}
+	f.grow(size)
+	return nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return int64(len(f.data)), nil
+}
+
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+// OpenMem returns a new, empty, writable database held in memory, for tests
+// and scratch data. It is gone once the database is closed. The database
+// supports the API of OpenFiles, and so Reorganize fails with
+// ErrInvalidArgument.
+func OpenMem() (*DBM, error) {
+	return OpenFiles(&memFile{name: memName + DIRFEXT}, &memFile{name: memName + PAGFEXT}, Options{Flags: os.O_RDWR})
+}
@@ -0,0 +1,121 @@
+package sdbm_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func setupMem(t testing.TB, initialData ...Pair) *sdbm.DBM {
+	t.Helper()
+	db, err := sdbm.OpenMem()
+	if err != nil {
+		t.Fatalf("OpenMem() error = %v", err)
+	}
+	for _, pair := range initialData {
+		if _, err := db.Store(pair.Key, pair.Val, 0); err != nil {
+			t.Fatalf("failed to store key=%s, val=%s: %v", pair.Key, pair.Val, err)
+		}
+	}
+	return db
+}
+
+func TestOpenMem_Fetch(t *testing.T) {
+	dbm := setupMem(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name    string
+		key     sdbm.Datum
+		want    sdbm.Datum
+		wantErr bool
+	}{
+		{name: "key is nil", key: nil, want: sdbm.Nullitem, wantErr: true},
+		{name: "key0 is not found", key: sdbm.Datum("key0"), want: sdbm.Nullitem},
+		{name: "key1 is found", key: sdbm.Datum("key1"), want: sdbm.Datum("val1")},
+		{name: "key10 is found", key: sdbm.Datum("key10"), want: sdbm.Datum("val10")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dbm.Fetch(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fetch() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenMem_ManyPairs(t *testing.T) {
+	size := 10000
+	pairs := generatePairs("key", "val", size)
+	dbm := setupMem(t, pairs...)
+	defer teardown(t, dbm)
+
+	stats, err := dbm.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.PageCount < 2 || stats.DirFileSize == 0 {
+		t.Errorf("Stats() got = %+v, want pages split", stats)
+	}
+
+	n := 0
+	for key, err := dbm.FirstKey(); key != nil || err != nil; key, err = dbm.NextKey() {
+		if err != nil {
+			t.Fatalf("NextKey() error = %v", err)
+		}
+		n++
+	}
+	if n != size {
+		t.Errorf("FirstKey/NextKey visited %d keys, want %d", n, size)
+	}
+
+	for i := 1; i <= size; i++ {
+		key := sdbm.Datum("key" + strconv.Itoa(i))
+		val, err := dbm.Fetch(key)
+		if err != nil || !reflect.DeepEqual(val, pairs[i-1].Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", key, val, err, pairs[i-1].Val)
+		}
+		ok, err := dbm.Delete(key)
+		if err != nil || !ok {
+			t.Fatalf("Delete(%s) got = %v, %v, want true", key, ok, err)
+		}
+	}
+	if err := dbm.Verify(); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if count, err := dbm.Count(); err != nil || count != 0 {
+		t.Errorf("Count() got = %d, %v, want 0", count, err)
+	}
+}
+
+func TestOpenMem_Snapshot(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	dbm := setupMem(t, pairs...)
+	defer teardown(t, dbm)
+
+	snap, err := dbm.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	defer snap.Close()
+	if err := dbm.Clear(); err == nil {
+		t.Fatal("Clear() with a snapshot open succeeded")
+	}
+	for _, p := range generatePairs("key", "new", 1000) {
+		if _, err := dbm.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, p := range pairs {
+		if val, err := snap.Fetch(p.Key); err != nil || !reflect.DeepEqual(val, p.Val) {
+			t.Fatalf("Snapshot.Fetch(%s) got = %q, %v, want %q", p.Key, val, err, p.Val)
+		}
+	}
+}
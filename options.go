@@ -0,0 +1,173 @@
+package sdbm
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Options holds the settings used by OpenWithOptions to open a database.
+// The zero value opens the files read-only with no extra behavior enabled.
+type Options struct {
+	// Flags are the flags passed to os.OpenFile for both the .dir and .pag files.
+	Flags int
+	// Mode is the permission used when the files are created.
+	Mode os.FileMode
+	// ReadOnly opens the files read-only whatever Flags holds, so that Store
+	// and Delete fail with ErrDBMRDOnly and nothing is created.
+	ReadOnly bool
+	// NoLock skips the advisory lock Open takes on the .pag file: shared for
+	// a read-only database and exclusive otherwise, failing with ErrLocked if
+	// another handle holds a conflicting one. It is meant for readers that
+	// share the files with a writer on purpose and use IsStale to notice its
+	// page splits; two writers must never share the files.
+	NoLock bool
+	// Logger, if not nil, receives diagnostics about the I/O and page splits
+	// of the database, such as "pag read: 3", for debugging. They are
+	// frequent, so it is best left unset in production.
+	Logger Logger
+	// AuditLog, if not nil, receives one line for every mutation made by
+	// Store and Delete. See AuditEntry for the line format.
+	AuditLog io.Writer
+	// ZeroCopyIteration makes FirstKey and NextKey return keys that alias the
+	// internal page buffer instead of copies. Such a key is only valid until
+	// the next call on the database, so callers must consume it first.
+	ZeroCopyIteration bool
+	// HashFunc, if not nil, replaces Hash as the function that maps a key to
+	// the hash deciding its page, for example to read files written by a
+	// tool that hashes differently. The hash decides where every pair is
+	// placed, so it must stay the same for the life of the files: opening
+	// them with another hash makes most keys unreachable.
+	HashFunc func(key []byte) int64
+	// KeyNormalizer, if not nil, maps every key passed to Fetch, Delete and
+	// Store to a canonical form before it is hashed and compared, and the
+	// canonical form is what Store writes. Keys that normalize to the same
+	// bytes are therefore the same key: with a lowercasing normalizer,
+	// storing "alice" with StoreSEEDUPS after "Alice" is a no-op.
+	// The normalizer must stay the same for the life of the files.
+	KeyNormalizer func(key Datum) Datum
+	// VerifyWrites makes every page write read the page back and compare it
+	// with what was written, failing with ErrWriteVerifyFailed on a mismatch.
+	// This catches storage that silently drops or corrupts writes, at the
+	// cost of an extra read per write.
+	VerifyWrites bool
+	// Checksums makes a newly created database keep a CRC-32 of every page
+	// in the page, at the cost of four bytes of each, and verify it whenever
+	// the page is read, failing with ErrChecksumMismatch if it does not
+	// match. This catches corruption that ChkPage, which only checks the
+	// offsets, misses. It implies FormatHeader, whose FeatureChecksums
+	// records the choice: a database created with checksums always verifies
+	// them, and one created without never does, whatever Checksums holds
+	// when it is opened later.
+	Checksums bool
+	// SyncOnWrite makes Store and Delete sync the files they wrote before
+	// they return: the .pag file, the .dir file when a page split grew the
+	// directory, and the overflow file when it was used. An operation that
+	// returns successfully is then on stable storage, at the cost of one or
+	// more fsyncs per write. WriteBatch.Commit, StoreMany and DeleteBatch
+	// sync once, after writing all their pages.
+	SyncOnWrite bool
+	// Sync is SyncOnWrite under its earlier name: setting either enables it.
+	Sync bool
+	// InternValues stores each distinct value once. Store keeps the value in
+	// a reference-counted blob store held in a second database, named after
+	// the file with a ".blob" suffix, and writes only a 32-byte reference
+	// under the key; Fetch resolves the reference and Delete releases it.
+	// This saves space when many keys share identical values, at the cost of
+	// a second lookup. Values are limited to PairMax-40 bytes. Interning
+	// applies to Store, Fetch and Delete, and to the writers built on them:
+	// WriteBatch, StoreMany, DeleteBatch and TxGroup store their pairs one
+	// at a time through Store and Delete. Methods that read the pages
+	// directly, such as ForEach and Cursor, see the references. It is only
+	// available through OpenWithOptions.
+	InternValues bool
+	// PageCacheSize is the number of recently used pages kept in memory,
+	// so that Fetch, Store and Delete read pages again from the cache
+	// instead of the .pag file. Each cached page takes a page of memory,
+	// PBLKSIZ bytes unless PageSize is set. Values of 0 and 1 keep only the
+	// page last used, as without a cache. Pages
+	// written by another handle on the same files are not seen while they
+	// are cached, unless RefreshOnChange notices the write.
+	PageCacheSize int
+	// RefreshOnChange makes Fetch, Store and Delete check the sizes of the
+	// .dir and .pag files before looking up a page, and drop the page and
+	// directory block kept in memory, and the pages of PageCacheSize, when
+	// either has changed since the last check, so that a reader sharing the
+	// files with a writer in another process follows the pages the writer
+	// splits. It costs two stats per operation. Only writes that change the
+	// size of a file are noticed: a pair added to a page in place, or a
+	// split into a page or directory block within the files, is not, and
+	// needs Reopen.
+	RefreshOnChange bool
+	// LazyBuffers defers allocating the page and directory block buffers
+	// until the database is first used, takes them from a shared pool, and
+	// returns them to the pool on Close. Together with DBM.Release this
+	// keeps idle handles, such as those in a pool, small.
+	LazyBuffers bool
+	// OverflowThreshold, if positive, lets values of any size be stored.
+	// Values longer than OverflowThreshold bytes, and values that would not
+	// fit in a page next to their key, are written to a third file, named
+	// after the database with a ".ovf" suffix, and the page holds a 17-byte
+	// reference to them instead; smaller values stay in the page behind a
+	// one-byte tag. Delete frees the space of an overflowed value, and later
+	// values reuse it. Like InternValues, with which it cannot be combined,
+	// it applies to Store, Fetch and Delete and the writers built on them,
+	// WriteBatch, StoreMany, DeleteBatch and TxGroup, while methods that
+	// read the pages directly see the tagged records and references. It
+	// must stay enabled for the life of the files, and is only available
+	// through OpenWithOptions.
+	// The values are kept out of the .pag file because its pages are
+	// numbered by key hash, so that any page may be claimed by a split.
+	// OverflowThreshold implies FormatHeader, whose FeatureOverflow records
+	// the choice: such a database fails to open with ErrUnsupportedFormat
+	// unless OverflowThreshold is set, and a database with a header but
+	// without the feature fails to open if it is.
+	OverflowThreshold int
+	// WAL makes Store and Delete durable and atomic across crashes. Each
+	// operation is appended to a write-ahead log, named after the database
+	// with a ".wal" suffix, and synced before it touches the pages. Opening
+	// the database replays the operations of a log left by a crash. Sync and
+	// Close checkpoint: they sync the data files and empty the log.
+	// WriteBatch and TxGroup log their writes too, applying them one at a
+	// time by Store and Delete. It is only available through
+	// OpenWithOptions.
+	WAL bool
+	// PageSize and DirBlockSize, if not zero, are the sizes of the blocks of
+	// the .pag and .dir files of a newly created database, in place of
+	// PBLKSIZ and DBLKSIZ. They must be powers of two, from 256 to 32768
+	// bytes for pages and from 256 bytes to 1 MiB for directory blocks.
+	// Larger pages hold larger pairs, up to DBM.PairMax, and split less
+	// often. The sizes are recorded in the format header, which they imply,
+	// and are read back when the database is opened: the fields may then be
+	// left zero, and opening fails with ErrUnsupportedFormat if they are set
+	// and differ from the sizes of the files.
+	PageSize     int
+	DirBlockSize int
+	// ByteOrder, if not nil, is the byte order of the page offset tables and
+	// checksums: binary.LittleEndian, the default, or binary.BigEndian, as
+	// written by C sdbm on big-endian machines. A database with a format
+	// header records its byte order, which is then read back as the block
+	// sizes are. A headerless one does not, so files from a big-endian
+	// machine must be opened with binary.BigEndian; their pages otherwise
+	// fail with ErrByteOrder.
+	ByteOrder binary.ByteOrder
+	// FormatHeader makes a newly created database reserve the first block of
+	// its .pag file for a header recording the format version, block sizes,
+	// byte order and features; see FormatInfo. Databases with a header are
+	// recognized on open whether or not it is set, but C sdbm and older
+	// versions of this package cannot read them.
+	FormatHeader bool
+	// ZeroFill makes every write past the end of the .dir or .pag file first
+	// write zeros over the gap, where a page split or a deeper trie would
+	// otherwise leave a hole. The package reads holes as empty blocks, which
+	// is only safe on storage, or a File given to OpenFiles, that reads them
+	// back as zeros. Filling costs a stat per block written and the space of
+	// every hole, which in a deep trie may be far more than the pairs take.
+	ZeroFill bool
+	// RequireHeader makes opening fail with ErrBadMagic unless the .pag
+	// file has a format header, so that files that are not sdbm databases,
+	// or were written without a header, are not mistaken for one. It
+	// implies FormatHeader for a newly created database. Without it,
+	// headerless files open as format version 0.
+	RequireHeader bool
+}
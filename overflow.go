@@ -0,0 +1,327 @@
+package sdbm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// ovfSuffix is appended to the database name to name the overflow file used
+// by Options.OverflowThreshold.
+const ovfSuffix = ".ovf"
+
+/*
+ * With an overflow file, every value written to a page is a record
+ * starting with a kind byte:
+ *
+ *      +--------+---------------------------+
+ *      | inline | value                     |
+ *      +--------+-------------+-------------+
+ *      | ovf    | offset (8)  | length (8)  |
+ *      +--------+-------------+-------------+
+ *
+ * offset points at an extent of the overflow file, which is a sequence of
+ * extents laid end to end:
+ *
+ *      +--------------+-------+-----------------------+
+ *      | capacity (8) | state | data (capacity bytes) |
+ *      +--------------+-------+-----------------------+
+ *
+ * A freed extent keeps its place in the file and is marked free, so the
+ * free list is rebuilt by scanning the extent headers when the file is opened.
+ */
+const (
+	recInline   = 0
+	recOverflow = 1
+
+	ovfRefSize = 1 + 8 + 8
+	ovfHdrSize = 8 + 1
+
+	extentUsed = 0
+	extentFree = 1
+)
+
+// extent is a region of the overflow file, addressed by the offset of its header.
+type extent struct {
+	off      int64
+	capacity int64
+}
+
+// overflow manages the overflow file and its free extents.
+type overflow struct {
+	f       File
+	end     int64    // offset one past the last extent
+	free    []extent // free extents, in no particular order
+	written bool     // written since the last Options.SyncOnWrite sync
+}
+
+// openOverflow opens the overflow file named name and rebuilds its free list.
+func openOverflow(name string, flags int, mode os.FileMode) (*overflow, error) {
+	if flags&os.O_WRONLY != 0 {
+		flags = (flags &^ os.O_WRONLY) | os.O_RDWR
+	}
+	f, err := openFile(name, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+	o := &overflow{f: osFile{f}}
+	if err := o.scan(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// scan walks the extent headers, collecting the free extents. A truncated
+// extent at the end of the file, left by an interrupted append, is ignored
+// and overwritten by the next append.
+func (o *overflow) scan() error {
+	size, err := o.f.Size()
+	if err != nil {
+		return wrapIOErr("stat", o.f.Name(), err)
+	}
+
+	var hdr [ovfHdrSize]byte
+	for o.end+ovfHdrSize <= size {
+		if _, err := o.f.ReadAt(hdr[:], o.end); err != nil {
+			return wrapIOErr("readat", o.f.Name(), err)
+		}
+		capacity := int64(binary.LittleEndian.Uint64(hdr[:]))
+		if capacity < 0 || capacity > size-o.end-ovfHdrSize {
+			break
+		}
+		if hdr[8] == extentFree {
+			o.free = append(o.free, extent{off: o.end, capacity: capacity})
+		}
+		o.end += ovfHdrSize + capacity
+	}
+	return nil
+}
+
+// alloc writes val to a free extent large enough to hold it, splitting off
+// any usable remainder, or appends a new extent, and returns its offset.
+func (o *overflow) alloc(val Datum) (int64, error) {
+	size := int64(val.Size())
+	ext := extent{off: o.end, capacity: size}
+	for i, e := range o.free {
+		if e.capacity >= size {
+			ext = e
+			o.free = append(o.free[:i], o.free[i+1:]...)
+			break
+		}
+	}
+
+	if rest := ext.capacity - size - ovfHdrSize; ext.off != o.end && rest > 0 {
+		tail := extent{off: ext.off + ovfHdrSize + size, capacity: rest}
+		if err := o.writeHeader(tail, extentFree); err != nil {
+			return 0, err
+		}
+		o.free = append(o.free, tail)
+		ext.capacity = size
+	}
+
+	buf := make([]byte, ovfHdrSize+size)
+	binary.LittleEndian.PutUint64(buf, uint64(ext.capacity))
+	buf[8] = extentUsed
+	copy(buf[ovfHdrSize:], val)
+	if err := writeAt(o.f, ext.off, buf); err != nil {
+		return 0, err
+	}
+	o.written = true
+	if ext.off == o.end {
+		o.end += ovfHdrSize + size
+	}
+	return ext.off, nil
+}
+
+// release marks the extent at off free for reuse.
+func (o *overflow) release(off int64) error {
+	var hdr [ovfHdrSize]byte
+	if _, err := o.f.ReadAt(hdr[:], off); err != nil {
+		return wrapIOErr("readat", o.f.Name(), err)
+	}
+	if hdr[8] != extentUsed {
+		return ErrInvalidPage
+	}
+	ext := extent{off: off, capacity: int64(binary.LittleEndian.Uint64(hdr[:]))}
+	if err := o.writeHeader(ext, extentFree); err != nil {
+		return err
+	}
+	o.free = append(o.free, ext)
+	return nil
+}
+
+func (o *overflow) writeHeader(e extent, state byte) error {
+	var hdr [ovfHdrSize]byte
+	binary.LittleEndian.PutUint64(hdr[:], uint64(e.capacity))
+	hdr[8] = state
+	o.written = true
+	return writeAt(o.f, e.off, hdr[:])
+}
+
+// read returns the length bytes stored in the extent at off. off and
+// length come from a page, so they are checked against the file and the
+// extent header before anything is allocated for the value.
+func (o *overflow) read(off, length int64) (Datum, error) {
+	if off < 0 || length < 0 || off > o.end-ovfHdrSize || length > o.end-off-ovfHdrSize {
+		return Nullitem, ErrInvalidPage
+	}
+	var hdr [ovfHdrSize]byte
+	if _, err := o.f.ReadAt(hdr[:], off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Nullitem, ErrInvalidPage
+		}
+		return Nullitem, wrapIOErr("readat", o.f.Name(), err)
+	}
+	if capacity := int64(binary.LittleEndian.Uint64(hdr[:])); hdr[8] != extentUsed || capacity < length {
+		return Nullitem, ErrInvalidPage
+	}
+	buf := make([]byte, length)
+	if _, err := o.f.ReadAt(buf, off+ovfHdrSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Nullitem, ErrInvalidPage
+		}
+		return Nullitem, wrapIOErr("readat", o.f.Name(), err)
+	}
+	return buf, nil
+}
+
+// decodeOverflowRef returns the extent offset and value length of an
+// overflow record, and reports whether rec is one.
+func decodeOverflowRef(rec Datum) (off, length int64, ok bool) {
+	if rec.Size() != ovfRefSize || rec[0] != recOverflow {
+		return 0, 0, false
+	}
+	off = int64(binary.LittleEndian.Uint64(rec[1:]))
+	length = int64(binary.LittleEndian.Uint64(rec[9:]))
+	return off, length, true
+}
+
+func (db *DBM) openOverflow(file string) error {
+	flags := db.opts.Flags
+	if db.rdonly {
+		flags = os.O_RDONLY
+	}
+	o, err := openOverflow(file+ovfSuffix, flags, db.opts.Mode)
+	if err != nil {
+		return err
+	}
+	db.ovf = o
+	return nil
+}
+
+// resolveOverflow returns the value a record stored in the database holds.
+func (db *DBM) resolveOverflow(rec Datum) (Datum, error) {
+	if rec.Size() == 0 {
+		return Nullitem, ErrInvalidPage
+	}
+	switch rec[0] {
+	case recInline:
+		return rec[1:], nil
+	case recOverflow:
+		off, length, ok := decodeOverflowRef(rec)
+		if !ok {
+			return Nullitem, ErrInvalidPage
+		}
+		return db.ovf.read(off, length)
+	}
+	return Nullitem, ErrInvalidPage
+}
+
+// encodeOverflow returns the record to store in the page for val, moving val
+// to the overflow file when it is longer than the threshold or would not fit
+// in a page next to key. It reports the offset of the new extent, or -1.
+func (db *DBM) encodeOverflow(key, val Datum) (Datum, int64, error) {
+	need := db.normKey(key).Size() + 1 + val.Size()
+	if val.Size() <= db.opts.OverflowThreshold && need <= db.PairMax() {
+		rec := make(Datum, 1+val.Size())
+		rec[0] = recInline
+		copy(rec[1:], val)
+		return rec, -1, nil
+	}
+
+	off, err := db.ovf.alloc(val)
+	if err != nil {
+		return nil, -1, err
+	}
+	rec := make(Datum, ovfRefSize)
+	rec[0] = recOverflow
+	binary.LittleEndian.PutUint64(rec[1:], uint64(off))
+	binary.LittleEndian.PutUint64(rec[9:], uint64(val.Size()))
+	return rec, off, nil
+}
+
+// releaseOverflow frees the extent rec points at, if any.
+func (db *DBM) releaseOverflow(rec Datum) error {
+	off, _, ok := decodeOverflowRef(rec)
+	if !ok {
+		return nil
+	}
+	return db.ovf.release(off)
+}
+
+// storeOverflow implements Store for Options.OverflowThreshold. The new
+// extent is written before the key points at it, and the replaced extent is
+// freed only after, so a failure never leaves a key pointing at a free extent.
+func (db *DBM) storeOverflow(key, val Datum, flags StoreFlags) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	old, err := db.fetch(key)
+	if err != nil {
+		return false, err
+	}
+	old = cloneDatum(old)
+	if old != nil && flags == StoreSEEDUPS {
+		return true, nil
+	}
+	if old != nil && flags == StoreINSERT {
+		return false, nil
+	}
+
+	if err := db.audit(AuditStore, db.normKey(key), val.Size()); err != nil {
+		return false, err
+	}
+	rec, off, err := db.encodeOverflow(key, val)
+	if err != nil {
+		return false, err
+	}
+	ok, err := db.store(key, rec, flags)
+	if err == nil {
+		err = db.flush()
+	}
+	if err != nil {
+		if off >= 0 {
+			_ = db.ovf.release(off)
+		}
+		return false, err
+	}
+	if old != nil && flags == StoreREPLACE {
+		if err := db.releaseOverflow(old); err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+// deleteOverflow implements Delete for Options.OverflowThreshold.
+func (db *DBM) deleteOverflow(key Datum) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	rec, err := db.fetch(key)
+	if err != nil || rec == nil {
+		return false, err
+	}
+	rec = cloneDatum(rec)
+
+	ok, err := db.del(key)
+	if err == nil && ok {
+		err = db.flush()
+	}
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, db.releaseOverflow(rec)
+}
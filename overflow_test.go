@@ -0,0 +1,231 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func openOverflow(t *testing.T, path string) *sdbm.DBM {
+	t.Helper()
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{
+		Flags:             os.O_RDWR | os.O_CREATE,
+		Mode:              0644,
+		OverflowThreshold: 100,
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	return db
+}
+
+func TestDBM_Overflow(t *testing.T) {
+	tests := []struct {
+		name string
+		val  sdbm.Datum
+	}{
+		{name: "empty", val: sdbm.Datum("")},
+		{name: "small", val: sdbm.Datum("small")},
+		{name: "at threshold", val: bytes.Repeat([]byte("t"), 100)},
+		{name: "above threshold", val: bytes.Repeat([]byte("a"), 101)},
+		{name: "above PAIRMAX", val: bytes.Repeat([]byte("p"), 3*sdbm.PAIRMAX)},
+		{name: "large", val: bytes.Repeat([]byte("l"), 1<<20)},
+	}
+
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openOverflow(t, path)
+	for _, tt := range tests {
+		if _, err := db.Store(sdbm.Datum(tt.name), tt.val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store(%s) error = %v", tt.name, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openOverflow(t, path)
+	defer teardown(t, db)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Fetch(sdbm.Datum(tt.name))
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.val) {
+				t.Fatalf("Fetch() got %d bytes, want %d", got.Size(), tt.val.Size())
+			}
+			if ok, err := db.Delete(sdbm.Datum(tt.name)); err != nil || !ok {
+				t.Fatalf("Delete() got = %v, %v, want true, nil", ok, err)
+			}
+			if got, err := db.Fetch(sdbm.Datum(tt.name)); err != nil || got != nil {
+				t.Errorf("Fetch() after Delete got = %q, %v, want nil, nil", got, err)
+			}
+		})
+	}
+}
+
+func TestDBM_Overflow_CorruptRef(t *testing.T) {
+	tests := []struct {
+		name   string
+		length uint64
+	}{
+		{name: "past the end of the file", length: 1 << 20},
+		{name: "huge", length: 1<<63 - 1},
+		{name: "negative", length: 1<<64 - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			db := openOverflow(t, path)
+			val := bytes.Repeat([]byte("v"), 200)
+			if _, err := db.Store(sdbm.Datum("key"), val, sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+			teardown(t, db)
+
+			// the reference to the first extent: offset 0 and the value length.
+			ref := []byte{1, 0, 0, 0, 0, 0, 0, 0, 0}
+			ref = binary.LittleEndian.AppendUint64(ref, uint64(len(val)))
+			pag, err := os.ReadFile(path + sdbm.PAGFEXT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := bytes.Index(pag, ref)
+			if i < 0 {
+				t.Fatal("overflow reference not found in the .pag file")
+			}
+			binary.LittleEndian.PutUint64(pag[i+9:], tt.length)
+			if err := os.WriteFile(path+sdbm.PAGFEXT, pag, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			db = openOverflow(t, path)
+			defer teardown(t, db)
+			if _, err := db.Fetch(sdbm.Datum("key")); !errors.Is(err, sdbm.ErrInvalidPage) {
+				t.Errorf("Fetch() error = %v, want %v", err, sdbm.ErrInvalidPage)
+			}
+		})
+	}
+}
+
+func TestDBM_Overflow_Writers(t *testing.T) {
+	big := bytes.Repeat([]byte("b"), 3*sdbm.PAIRMAX)
+	marker := t.TempDir()
+	tests := []struct {
+		name  string
+		write func(db *sdbm.DBM, pairs []sdbm.Pair) error
+	}{
+		{name: "WriteBatch", write: func(db *sdbm.DBM, pairs []sdbm.Pair) error {
+			b := db.NewWriteBatch()
+			for _, p := range pairs {
+				b.Put(p.Key, p.Val)
+			}
+			_, err := b.Commit()
+			return err
+		}},
+		{name: "StoreMany", write: func(db *sdbm.DBM, pairs []sdbm.Pair) error {
+			_, err := db.StoreMany(pairs, sdbm.StoreREPLACE)
+			return err
+		}},
+		{name: "TxGroup", write: func(db *sdbm.DBM, pairs []sdbm.Pair) error {
+			g := sdbm.NewTxGroup(filepath.Join(marker, "tx.marker"))
+			for _, p := range pairs {
+				g.Put(db, p.Key, p.Val)
+			}
+			return g.Commit()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openOverflow(t, filepath.Join(t.TempDir(), DBMFile))
+			defer teardown(t, db)
+
+			pairs := []sdbm.Pair{
+				{Key: sdbm.Datum("small"), Val: sdbm.Datum("hello")},
+				{Key: sdbm.Datum("big"), Val: big},
+			}
+			if err := tt.write(db, pairs); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			for _, p := range pairs {
+				if got, err := db.Fetch(p.Key); err != nil || !bytes.Equal(got, p.Val) {
+					t.Fatalf("Fetch(%s) got %d bytes, %v, want %d", p.Key, got.Size(), err, p.Val.Size())
+				}
+			}
+			if n, err := db.DeleteBatch([]sdbm.Datum{sdbm.Datum("small"), sdbm.Datum("big")}); err != nil || n != 2 {
+				t.Fatalf("DeleteBatch() got = %d, %v, want 2", n, err)
+			}
+			for _, p := range pairs {
+				if got, err := db.Fetch(p.Key); err != nil || got != nil {
+					t.Errorf("Fetch(%s) after DeleteBatch() got = %q, %v, want nil", p.Key, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDBM_Overflow_ReusesFreedSpace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openOverflow(t, path)
+	defer teardown(t, db)
+	ovfSize := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path + ".ovf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	big := bytes.Repeat([]byte("b"), 10000)
+	for _, key := range []string{"a", "b"} {
+		if _, err := db.Store(sdbm.Datum(key), big, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	size := ovfSize()
+
+	// replacing frees the old extent after the new one is written, so the
+	// next replacement lands in the space the previous one freed.
+	for i := 0; i < 10; i++ {
+		val := bytes.Repeat([]byte{byte('0' + i)}, 5000)
+		if _, err := db.Store(sdbm.Datum("a"), val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if ok, err := db.Delete(sdbm.Datum("b")); err != nil || !ok {
+		t.Fatalf("Delete() got = %v, %v, want true, nil", ok, err)
+	}
+	if _, err := db.Store(sdbm.Datum("c"), big, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if got := ovfSize(); got > size+5000+100 {
+		t.Errorf("overflow file size got = %d, want at most %d", got, size+5000+100)
+	}
+
+	want := bytes.Repeat([]byte("9"), 5000)
+	if got, err := db.Fetch(sdbm.Datum("a")); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Fetch(a) got %d bytes, %v, want %d bytes", got.Size(), err, len(want))
+	}
+	if got, err := db.Fetch(sdbm.Datum("c")); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("Fetch(c) got %d bytes, %v, want %d bytes", got.Size(), err, len(big))
+	}
+}
+
+func TestDBM_Overflow_WithInternValues(t *testing.T) {
+	_, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), sdbm.Options{
+		Flags:             os.O_RDWR | os.O_CREATE,
+		Mode:              0644,
+		InternValues:      true,
+		OverflowThreshold: 100,
+	})
+	if !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("OpenWithOptions() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+}
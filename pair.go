@@ -24,39 +24,56 @@ import (
  * of entries (ino[0]) is zero, the offset to the END of
  * the free area is the block size. Otherwise, it is the
  * nth (ino[ino[0]]) entry's offset.
+ *
+ * the offsets are uint16s, so a page holds at most maxPageSize bytes.
  */
 
 // Page represents a database page for SDBM, handling the storage of keys and values.
 // Each page contains metadata about key and value offsets and a free area for storing data.
 // The free area begins at the highest offset in the page. The key/value pairs
 // are stored in reverse order with their offsets stored at the beginning of the page.
+// The zero Page is an empty page of PBLKSIZ bytes with little-endian offsets.
 type Page struct {
-	buf [PBLKSIZ]byte
+	buf []byte
+	be  bool // offsets are big-endian
+}
+
+// newPage returns an empty page of size bytes.
+func newPage(size int) *Page {
+	return &Page{buf: make([]byte, size)}
+}
+
+// init allocates the block of a zero Page.
+func (p *Page) init() {
+	if p.buf == nil {
+		p.buf = make([]byte, PBLKSIZ)
+	}
 }
 
 // FitPair checks if there is enough space in the page to store a new key-value pair.
 // It calculates the free area and compares it to the required space for the pair.
 func (p *Page) FitPair(need int) bool {
+	p.init()
+	need += 2 * SHORTSIZE
+	return need <= p.free()
+}
+
+// free returns the size of the free area between the offset table and the pairs.
+func (p *Page) free() int {
 	n := int(p.getN())
-	off := PBLKSIZ
+	off := len(p.buf)
 	if n > 0 {
 		off = int(p.getIno(n))
 	}
-	free := off - (n+1)*SHORTSIZE
-	need += 2 * SHORTSIZE
-
-	if debug {
-		fmt.Printf("free %d need %d\n", free, need)
-	}
-
-	return need <= free
+	return off - (n+1)*SHORTSIZE
 }
 
 // PutPair stores a key-value pair in the page. It updates the offset table
 // and copies the key and value into the free area in reverse order.
 func (p *Page) PutPair(key Datum, val Datum) {
+	p.init()
 	n := int(p.getN())
-	off := PBLKSIZ
+	off := len(p.buf)
 	if n > 0 {
 		off = int(p.getIno(n))
 	}
@@ -78,6 +95,7 @@ func (p *Page) PutPair(key Datum, val Datum) {
 // GetPair retrieves the value corresponding to a given key from the page.
 // If the key is found, it returns the associated value. If not, it returns Nullitem.
 func (p *Page) GetPair(key Datum) Datum {
+	p.init()
 	n := int(p.getN())
 	if n == 0 {
 		return Nullitem
@@ -98,6 +116,7 @@ func (p *Page) GetPair(key Datum) Datum {
 
 // DupPair checks if a duplicate of the given key exists in the page.
 func (p *Page) DupPair(key Datum) bool {
+	p.init()
 	n := int(p.getN())
 	if n == 0 {
 		return false
@@ -110,12 +129,13 @@ func (p *Page) GetNKey(num int) Datum {
 	var key Datum
 	num = num*2 - 1
 
+	p.init()
 	n := int(p.getN())
 	if n == 0 || num > n {
 		return Nullitem
 	}
 
-	off := PBLKSIZ
+	off := len(p.buf)
 	if num > 1 {
 		off = int(p.getIno(num - 1))
 	}
@@ -128,6 +148,7 @@ func (p *Page) GetNKey(num int) Datum {
 
 // DelPair deletes the key-value pair from the page.
 func (p *Page) DelPair(key Datum) bool {
+	p.init()
 	n := int(p.getN())
 	if n == 0 {
 		return false
@@ -146,20 +167,17 @@ func (p *Page) DelPair(key Datum) bool {
 	if i < n-1 {
 		var dst int
 		if i == 1 {
-			dst = PBLKSIZ
+			dst = len(p.buf)
 		} else {
 			dst = int(p.getIno(i - 1))
 		}
 		src := int(p.getIno(i + 1))
 		zoo := dst - src
 
-		if debug {
-			fmt.Printf("free-up %d\n", zoo)
-		}
-
-		// shift data/keys down
+		// shift data/keys down. only the m bytes below the deleted
+		// pair move; the pairs above dst must stay untouched.
 		m := int(p.getIno(i+1) - p.getIno(n))
-		copy(p.buf[dst-m:], p.buf[src-m:])
+		copy(p.buf[dst-m:dst], p.buf[src-m:src])
 
 		// Adjust offset index up
 		for i < n-1 {
@@ -175,7 +193,7 @@ func (p *Page) DelPair(key Datum) bool {
 // return offset index in the range 0 < i < n.
 // return 0 if not found.
 func (p *Page) seePair(n int, key []byte) int {
-	off := PBLKSIZ
+	off := len(p.buf)
 	for i := 1; i < n; i += 2 {
 		cur := p.getIno(i)
 		if len(key) == off-int(cur) && bytes.Equal(key, p.buf[cur:cur+uint16(len(key))]) {
@@ -189,15 +207,24 @@ func (p *Page) seePair(n int, key []byte) int {
 // SplPage splits the current page into two, distributing the key-value pairs
 // between the original page and the new page based on the provided hash bit (sbit).
 func (p *Page) SplPage(newPag *Page, sbit int64) {
-	var (
-		key, val Datum
-		cur      Page
-	)
-	off := PBLKSIZ
+	p.init()
+	p.splPage(newPag, sbit, Hash)
+}
 
-	copy(cur.buf[:], p.buf[:])
-	copy(p.buf[:], make([]byte, PBLKSIZ))
-	copy(newPag.buf[:], make([]byte, PBLKSIZ))
+// splPage is SplPage with the hash function used to place the keys.
+// newPag becomes a page of the size of p.
+func (p *Page) splPage(newPag *Page, sbit int64, hash func([]byte) int64) {
+	var key, val Datum
+	off := len(p.buf)
+
+	cur := Page{buf: bytes.Clone(p.buf), be: p.be}
+	newPag.be = p.be
+	clear(p.buf)
+	if len(newPag.buf) == len(p.buf) {
+		clear(newPag.buf)
+	} else {
+		newPag.buf = make([]byte, len(p.buf))
+	}
 
 	n := cur.getIno(0)
 	for i := 1; n > 0; i += 2 {
@@ -208,7 +235,7 @@ func (p *Page) SplPage(newPag *Page, sbit int64) {
 		val = cur.buf[valOff:keyOff]
 
 		// select the page pointer (by looking at sbit) and insert
-		if exHash(key)&sbit != 0 {
+		if hash(key)&sbit != 0 {
 			newPag.PutPair(key, val)
 		} else {
 			p.PutPair(key, val)
@@ -217,32 +244,42 @@ func (p *Page) SplPage(newPag *Page, sbit int64) {
 		off = valOff
 		n -= 2
 	}
-
-	if debug {
-		fmt.Printf("%d split %d/%d\n", cur.getIno(0)/2, len(newPag.buf)/2, len(p.buf)/2)
-	}
 }
 
 // ChkPage checks the integrity of the page by verifying that the number of entries
 // and the order of offsets are valid. Returns false if the page is invalid.
 func (p *Page) ChkPage() bool {
+	return p.check() == nil
+}
+
+// check is ChkPage telling what is wrong with the page: an entry count it
+// cannot hold, or the first entry whose offset lies beyond the pair before
+// it, or the end of the block. The error wraps ErrInvalidPage.
+func (p *Page) check() error {
+	p.init()
 	n := int(p.getN())
-	if n < 0 || n > PBLKSIZ/SHORTSIZE {
-		return false
+	if limit := len(p.buf)/SHORTSIZE - 1; n > limit {
+		return fmt.Errorf("%w: entry count %d, a page holds at most %d", ErrInvalidPage, n, limit)
 	}
-	if n > 0 {
-		off := PBLKSIZ
-		for i := 1; n > 0; i += 2 {
-			keyOff := int(p.getIno(i))
-			valOff := int(p.getIno(i + 1))
-			if keyOff > off || valOff > off || valOff > keyOff {
-				return false
+	if n%2 != 0 {
+		return fmt.Errorf("%w: odd entry count %d", ErrInvalidPage, n)
+	}
+	off := len(p.buf)
+	for i := 1; i < n; i += 2 {
+		keyOff := int(p.getIno(i))
+		valOff := int(p.getIno(i + 1))
+		if keyOff > off {
+			if off == len(p.buf) {
+				return fmt.Errorf("%w: entry %d: key offset %d beyond the block size %d", ErrInvalidPage, i, keyOff, off)
 			}
-			off = valOff
-			n -= 2
+			return fmt.Errorf("%w: entry %d: key offset %d beyond the previous pair at %d", ErrInvalidPage, i, keyOff, off)
 		}
+		if valOff > keyOff {
+			return fmt.Errorf("%w: entry %d: value offset %d beyond its key offset %d", ErrInvalidPage, i+1, valOff, keyOff)
+		}
+		off = valOff
 	}
-	return true
+	return nil
 }
 
 func (p *Page) getN() uint16 {
@@ -254,9 +291,40 @@ func (p *Page) setN(val uint16) {
 }
 
 func (p *Page) getIno(i int) uint16 {
+	if p.be {
+		return binary.BigEndian.Uint16(p.buf[i*2 : i*2+2])
+	}
 	return binary.LittleEndian.Uint16(p.buf[i*2 : i*2+2])
 }
 
 func (p *Page) setIno(i int, val uint16) {
+	if p.be {
+		binary.BigEndian.PutUint16(p.buf[i*2:], val)
+		return
+	}
 	binary.LittleEndian.PutUint16(p.buf[i*2:], val)
 }
+
+// order returns the byte order of the offsets of p.
+func (p *Page) order() binary.ByteOrder {
+	if p.be {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// forEachPair calls fn for every key-value pair in the page, in the order
+// of the offset table, until fn returns false. The key and value slices
+// alias the page buffer.
+func (p *Page) forEachPair(fn func(key, val Datum) bool) {
+	n := int(p.getN())
+	off := len(p.buf)
+	for i := 1; i < n; i += 2 {
+		keyOff := int(p.getIno(i))
+		valOff := int(p.getIno(i + 1))
+		if !fn(p.buf[keyOff:off], p.buf[valOff:keyOff]) {
+			return
+		}
+		off = valOff
+	}
+}
@@ -0,0 +1,110 @@
+package sdbm_test
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestPage_DelPair_Positions(t *testing.T) {
+	pairs := []Pair{
+		{Key: sdbm.Datum("first"), Val: sdbm.Datum("value-1")},
+		{Key: sdbm.Datum("middle"), Val: sdbm.Datum("value-two")},
+		{Key: sdbm.Datum("last"), Val: sdbm.Datum("v3")},
+	}
+	for del := range pairs {
+		t.Run(pairs[del].Key.String(), func(t *testing.T) {
+			p := &sdbm.Page{}
+			for _, pair := range pairs {
+				p.PutPair(pair.Key, pair.Val)
+			}
+			if !p.DelPair(pairs[del].Key) {
+				t.Fatalf("DelPair(%s) got = false, want true", pairs[del].Key)
+			}
+			if !p.ChkPage() {
+				t.Fatal("ChkPage() got = false after DelPair")
+			}
+			for i, pair := range pairs {
+				want := pair.Val
+				if i == del {
+					want = sdbm.Nullitem
+				}
+				if got := p.GetPair(pair.Key); !reflect.DeepEqual(got, want) {
+					t.Errorf("GetPair(%s) got = %q, want %q", pair.Key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPage_DelPair_Matrix(t *testing.T) {
+	for _, n := range []int{2, 3, 5} {
+		// vary the sizes so a miscomputed shift shows up as a wrong value.
+		pairs := make([]Pair, n)
+		for i := range pairs {
+			pairs[i] = Pair{
+				Key: sdbm.Datum(strings.Repeat("k", i+1) + strconv.Itoa(i)),
+				Val: sdbm.Datum(strings.Repeat(strconv.Itoa(i), 2*i+3)),
+			}
+		}
+		for del := 0; del < n; del++ {
+			t.Run(fmt.Sprintf("%d pairs delete %d", n, del), func(t *testing.T) {
+				p := &sdbm.Page{}
+				for _, pair := range pairs {
+					p.PutPair(pair.Key, pair.Val)
+				}
+				if !p.DelPair(pairs[del].Key) {
+					t.Fatalf("DelPair(%s) got = false, want true", pairs[del].Key)
+				}
+				if !p.ChkPage() {
+					t.Fatal("ChkPage() got = false after DelPair")
+				}
+				for i, pair := range pairs {
+					want := pair.Val
+					if i == del {
+						want = sdbm.Nullitem
+					}
+					if got := p.GetPair(pair.Key); !reflect.DeepEqual(got, want) {
+						t.Errorf("GetPair(%s) got = %q, want %q", pair.Key, got, want)
+					}
+				}
+				// the freed space must be reusable.
+				p.PutPair(pairs[del].Key, pairs[del].Val)
+				for _, pair := range pairs {
+					if got := p.GetPair(pair.Key); !reflect.DeepEqual(got, pair.Val) {
+						t.Errorf("GetPair(%s) after reinsert got = %q, want %q", pair.Key, got, pair.Val)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Replacing a value deletes the old pair first; with the key in the middle of
+// its page this used to corrupt the pairs stored before it.
+func TestDBM_ReplaceMiddlePair(t *testing.T) {
+	pairs := generatePairs("key", "val", 5)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	if _, err := dbm.Store(sdbm.Datum("key3"), sdbm.Datum("replaced"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	for _, pair := range pairs {
+		want := pair.Val
+		if pair.Key.String() == "key3" {
+			want = sdbm.Datum("replaced")
+		}
+		got, err := dbm.Fetch(pair.Key)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Fetch(%s) got = %q, want %q", pair.Key, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package sdbm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// reorgSuffix is appended to the names of the .dir and .pag files to name
+// the files Reorganize builds before moving them into place.
+const reorgSuffix = ".reorg"
+
+// Reorganize rebuilds the database into fresh files holding only its live
+// pairs, which reclaims the space left behind by deletions, refills
+// underfilled pages and rebalances the trie. The pairs are copied as stored,
+// so the value store of Options.InternValues and the overflow file of
+// Options.OverflowThreshold are kept as they are.
+//
+// The new files are written and synced next to the old ones, with a
+// ".reorg" suffix, and then renamed over them, the .pag file first. Any
+// failure before the renames leaves the original files untouched. Each
+// rename is atomic, but a crash between the two leaves the new .pag file
+// next to the old .dir file; the new .dir file is then still present under
+// its temporary name and must be renamed by hand. The new .pag file is
+// locked as the old one was, unless Options.NoLock is set. Reorganize fails with
+// ErrDBMRDOnly on a read-only database and with ErrInvalidArgument while a
+// Snapshot of the database is open or if it was opened with OpenFiles.
+func (db *DBM) Reorganize() error {
+	if db.rdonly {
+		return ErrDBMRDOnly
+	}
+	pagf, ok := db.pagf.(osFile)
+	if !ok {
+		return ErrInvalidArgument
+	}
+	db.cow.mu.Lock()
+	defer db.cow.mu.Unlock()
+	if n := len(db.cow.snaps); n > 0 {
+		return fmt.Errorf("%w: %d snapshots open", ErrInvalidArgument, n)
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+
+	dirname, pagname := db.dirf.Name(), db.pagf.Name()
+	tmpDir, tmpPag := dirname+reorgSuffix, pagname+reorgSuffix
+	if err := db.copyTo(pagf, tmpDir, tmpPag); err != nil {
+		_ = os.Remove(tmpDir)
+		_ = os.Remove(tmpPag)
+		return err
+	}
+
+	if err := os.Rename(tmpPag, pagname); err != nil {
+		_ = os.Remove(tmpDir)
+		_ = os.Remove(tmpPag)
+		return wrapIOErr("rename", tmpPag, err)
+	}
+	if err := os.Rename(tmpDir, dirname); err != nil {
+		return wrapIOErr("rename", tmpDir, err)
+	}
+	return db.reopen(dirname, pagname)
+}
+
+// copyTo writes the pairs of the database to a new database with the
+// given files, in the same format and with the permissions of pagf, the
+// .pag file, and syncs it.
+func (db *DBM) copyTo(pagf osFile, dirname, pagname string) error {
+	fi, err := pagf.Stat()
+	if err != nil {
+		return wrapIOErr("stat", pagf.Name(), err)
+	}
+	opts := Options{
+		Flags:             os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+		Mode:              fi.Mode().Perm(),
+		HashFunc:          db.opts.HashFunc,
+		FormatHeader:      db.pagBase > 0,
+		Checksums:         db.checksums(),
+		ByteOrder:         db.format.ByteOrder,
+		ZeroFill:          db.opts.ZeroFill,
+		OverflowThreshold: db.opts.OverflowThreshold, // only recorded in the header
+	}
+	if db.pagBase > 0 {
+		opts.PageSize, opts.DirBlockSize = db.format.PageSize, db.format.DirBlockSize
+	}
+	tmp, err := prep(dirname, pagname, opts)
+	if err != nil {
+		return err
+	}
+
+	// the keys are stored as they are, already normalized, and with flags
+	// 0 so that pairs sharing a key are all kept.
+	err = db.ForEach(func(key, val Datum) error {
+		_, err := tmp.store(key, val, 0)
+		return err
+	})
+	if err == nil {
+		err = tmp.flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	return errors.Join(err, tmp.Close())
+}
+
+// reopen replaces the open .dir and .pag files with the files of the same
+// names, after Reorganize moved new ones into place.
+func (db *DBM) reopen(dirname, pagname string) error {
+	dirf, err := openFile(dirname, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	pagf, err := openFile(pagname, os.O_RDWR, 0)
+	if err != nil {
+		_ = dirf.Close()
+		return err
+	}
+	if !db.opts.NoLock {
+		if err := lockPag(pagf, false); err != nil {
+			_ = dirf.Close()
+			_ = pagf.Close()
+			return err
+		}
+	}
+	fi, err := dirf.Stat()
+	if err != nil {
+		_ = dirf.Close()
+		_ = pagf.Close()
+		return wrapIOErr("stat", dirname, err)
+	}
+
+	_ = db.dirf.Close()
+	db.unlockPag()
+	_ = db.pagf.Close()
+	db.dirf, db.pagf = osFile{dirf}, osFile{pagf}
+	db.dirSync, db.pagSync = false, false
+	db.forget(fi.Size())
+	if db.wal != nil {
+		// the log holds nothing the new files lack.
+		return db.Sync()
+	}
+	return nil
+}
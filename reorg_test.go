@@ -0,0 +1,146 @@
+package sdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Reorganize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "plain"},
+		{name: "FormatHeader", opts: sdbm.Options{FormatHeader: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{FormatHeader: true, OverflowThreshold: 8}},
+		{name: "WAL", opts: sdbm.Options{WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			pairs := generatePairs("key", "a longer value", 3000)
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			for i, p := range pairs {
+				if i%10 != 0 {
+					if _, err := db.Delete(p.Key); err != nil {
+						t.Fatalf("Delete() error = %v", err)
+					}
+				}
+			}
+			before, err := os.Stat(path + sdbm.PAGFEXT)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Reorganize(); err != nil {
+				t.Fatalf("Reorganize() error = %v", err)
+			}
+			after, err := os.Stat(path + sdbm.PAGFEXT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Size() >= before.Size() {
+				t.Errorf("Reorganize() .pag size got = %d, want less than %d", after.Size(), before.Size())
+			}
+			if matches, _ := filepath.Glob(path + "*.reorg"); len(matches) != 0 {
+				t.Errorf("Reorganize() left %v behind", matches)
+			}
+
+			check := func(db *sdbm.DBM) {
+				t.Helper()
+				for i, p := range pairs {
+					var want sdbm.Datum
+					if i%10 == 0 {
+						want = p.Val
+					}
+					got, err := db.Fetch(p.Key)
+					if err != nil {
+						t.Fatalf("Fetch() error = %v", err)
+					}
+					if got.String() != want.String() || (got == nil) != (want == nil) {
+						t.Fatalf("Fetch(%s) got = %q, want %q", p.Key, got, want)
+					}
+				}
+			}
+			check(db)
+			if _, err := db.Store(sdbm.Datum("new"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() after Reorganize() error = %v", err)
+			}
+			teardown(t, db)
+
+			db, err = sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to reopen db: %v", err)
+			}
+			defer teardown(t, db)
+			check(db)
+			if got, _ := db.Fetch(sdbm.Datum("new")); got.String() != "val" {
+				t.Errorf("Fetch(new) after reopening got = %q, want val", got)
+			}
+		})
+	}
+}
+
+func TestDBM_Reorganize_NoLock(t *testing.T) {
+	dir, writer := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, writer)
+	path := filepath.Join(dir, DBMFile)
+
+	// a handle opened with NoLock shares the files with the locked writer.
+	dbm, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR, NoLock: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+	if err := dbm.Reorganize(); err != nil {
+		t.Fatalf("Reorganize() error = %v", err)
+	}
+	if n, err := dbm.Count(); err != nil || n != 1000 {
+		t.Errorf("Count() after Reorganize() got = %d, %v, want 1000", n, err)
+	}
+
+	// the new files are not locked either, so a writer may open them.
+	other, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR})
+	if err != nil {
+		t.Fatalf("open after Reorganize() error = %v", err)
+	}
+	teardown(t, other)
+}
+
+func TestDBM_Reorganize_Failure(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, dbm)
+	path := filepath.Join(dir, DBMFile)
+
+	// a directory in the way of the new .pag file makes the copy fail.
+	if err := os.Mkdir(path+sdbm.PAGFEXT+".reorg", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var ioErr *sdbm.IOError
+	if err := dbm.Reorganize(); !errors.As(err, &ioErr) {
+		t.Errorf("Reorganize() error = %v, want an IOError", err)
+	}
+	if _, err := os.Stat(path + sdbm.DIRFEXT + ".reorg"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Reorganize() left the new .dir file behind: %v", err)
+	}
+	if n, err := dbm.Count(); err != nil || n != 1000 {
+		t.Errorf("Count() after a failed Reorganize() got = %d, %v, want 1000", n, err)
+	}
+	if got, err := dbm.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+		t.Errorf("Fetch() after a failed Reorganize() got = %q, %v, want val1", got, err)
+	}
+}
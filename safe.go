@@ -0,0 +1,134 @@
+package sdbm
+
+import "sync"
+
+// SafeDBM wraps a DBM for use by several goroutines at once. Fetch and
+// Exists take a read lock and read the files directly, leaving the page and
+// directory caches of the DBM untouched, so they run in parallel with each
+// other. Store, Delete, FirstKey, NextKey and Close take the write lock;
+// FirstKey and NextKey do because they move the shared cursor, so
+// concurrent iterations interleave. The wrapped DBM must not be used
+// directly while the SafeDBM is in use.
+type SafeDBM struct {
+	mu sync.RWMutex
+	db *DBM
+}
+
+// NewSafeDBM returns a SafeDBM guarding db.
+func NewSafeDBM(db *DBM) *SafeDBM {
+	return &SafeDBM{db: db}
+}
+
+// Fetch is DBM.Fetch under a read lock. The value is a copy. With
+// Options.InternValues it takes the write lock, as resolving the reference
+// goes through the caches of the blob store.
+func (s *SafeDBM) Fetch(key Datum) (Datum, error) {
+	if s.db.blobs != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.db.Fetch(key)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, err := s.db.fetchShared(key)
+	if err != nil || val == nil || s.db.ovf == nil {
+		return val, err
+	}
+	return s.db.resolveOverflow(val)
+}
+
+// Exists is DBM.Exists under a read lock.
+func (s *SafeDBM) Exists(key Datum) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, err := s.db.fetchShared(key)
+	return val != nil, err
+}
+
+// Store is DBM.Store under the write lock.
+func (s *SafeDBM) Store(key, val Datum, flags StoreFlags) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Store(key, val, flags)
+}
+
+// Delete is DBM.Delete under the write lock.
+func (s *SafeDBM) Delete(key Datum) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Delete(key)
+}
+
+// FirstKey is DBM.FirstKey under the write lock.
+func (s *SafeDBM) FirstKey() (Datum, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.FirstKey()
+}
+
+// NextKey is DBM.NextKey under the write lock.
+func (s *SafeDBM) NextKey() (Datum, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.NextKey()
+}
+
+// Close is DBM.Close under the write lock.
+func (s *SafeDBM) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// fetchShared looks key up reading the files directly instead of through
+// the block caches, so concurrent calls do not race as long as nothing
+// writes to the database meanwhile.
+func (db *DBM) fetchShared(key Datum) (Datum, error) {
+	return db.fetchVia(key, db.maxbno, func(dir bool, off int64, buf []byte) error {
+		if dir {
+			return readFull(db.dirf, buf, off)
+		}
+		return readFull(db.pagf, buf, off)
+	})
+}
+
+// fetchVia looks key up in the trie below maxbno, reading blocks with read
+// rather than through the block caches. read fills buf with the block at
+// off of the .dir file if dir is set, and of the .pag file otherwise. The
+// value returned is backed by a private page.
+func (db *DBM) fetchVia(key Datum, maxbno int64, read func(dir bool, off int64, buf []byte) error) (Datum, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return Nullitem, err
+	}
+
+	dirbuf := make([]byte, db.format.DirBlockSize)
+	dirbno := int64(-1)
+	var err error
+	bit := func(dbit int64) bool {
+		c := dbit / BITSIZ
+		if dirb := c / int64(len(dirbuf)); dirb != dirbno {
+			if err = read(true, db.offDir(dirb), dirbuf); err != nil {
+				return false
+			}
+			dirbno = dirb
+		}
+		return dirbuf[c%int64(len(dirbuf))]&(1<<(dbit%BITSIZ)) != 0
+	}
+	hash := db.exHash(key)
+	_, hbit, _ := walkTrie(hash, maxbno, bit)
+	if err != nil {
+		return Nullitem, err
+	}
+
+	p := db.newPage()
+	pagb := hash & mask(hbit)
+	if err := read(false, db.offPag(pagb), p.buf); err != nil {
+		return Nullitem, err
+	}
+	if err := db.checkPage(pagb, p); err != nil {
+		return Nullitem, err
+	}
+	return p.GetPair(key), nil
+}
@@ -0,0 +1,78 @@
+package sdbm_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestSafeDBM_Concurrent(t *testing.T) {
+	pairs := generatePairs("key", "val", 500)
+	_, dbm := setup(t, pairs...)
+	safe := sdbm.NewSafeDBM(dbm)
+	defer func() {
+		if err := safe.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := sdbm.Datum("new" + strconv.Itoa(w) + "-" + strconv.Itoa(i))
+				if _, err := safe.Store(key, key, sdbm.StoreREPLACE); err != nil {
+					t.Errorf("Store() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				for _, p := range pairs {
+					got, err := safe.Fetch(p.Key)
+					if err != nil {
+						t.Errorf("Fetch() error = %v", err)
+						return
+					}
+					if got.String() != p.Val.String() {
+						t.Errorf("Fetch(%s) got = %q, want %q", p.Key, got, p.Val)
+						return
+					}
+					if ok, err := safe.Exists(p.Key); err != nil || !ok {
+						t.Errorf("Exists(%s) got = %v, %v, want true", p.Key, ok, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		key, err := safe.FirstKey()
+		for ; key != nil && err == nil; key, err = safe.NextKey() {
+		}
+		if err != nil {
+			t.Errorf("NextKey() error = %v", err)
+		}
+	}()
+	wg.Wait()
+
+	for w := 0; w < 2; w++ {
+		for i := 0; i < 500; i++ {
+			key := sdbm.Datum("new" + strconv.Itoa(w) + "-" + strconv.Itoa(i))
+			if got, err := safe.Fetch(key); err != nil || got.String() != key.String() {
+				t.Fatalf("Fetch(%s) got = %q, %v", key, got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package sdbm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+)
+
+// Pair is a key-value pair stored in the database.
+type Pair struct {
+	Key Datum
+	Val Datum
+}
+
+// PageRecord is a page of the .pag file delivered by Pages.
+// A record with a non-nil Err is the last record sent before the channel is closed.
+type PageRecord struct {
+	PageNo int64  // The page number within the .pag file.
+	Pairs  []Pair // Copies of the pairs stored in the page.
+	Err    error  // The error that stopped the scan, if any.
+}
+
+// readPage reads page pagb of the .pag file into p without touching the
+// page cache. It reports false if the page lies beyond the end of the file.
+// A partially read page, or a hole, is read as zeros.
+func (db *DBM) readPage(pagb int64, p *Page) (bool, error) {
+	n, err := db.pagf.ReadAt(p.buf, db.offPag(pagb))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, wrapIOErr("readat", db.pagf.Name(), err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	clear(p.buf[n:])
+	return true, nil
+}
+
+// walkPages reads every page of the .pag file in order and calls fn with
+// its number and contents, stopping at the first error. The page passed to
+// fn is reused between calls. The cursor used by FirstKey and NextKey and
+// the page cache are not disturbed.
+func (db *DBM) walkPages(fn func(pagb int64, p *Page) error) error {
+	return db.walkPagesContext(context.Background(), fn)
+}
+
+// walkPagesContext is walkPages returning ctx.Err() once ctx is done,
+// checked before each page is read.
+func (db *DBM) walkPagesContext(ctx context.Context, fn func(pagb int64, p *Page) error) error {
+	p := db.newPage()
+	for pagb := int64(0); ; pagb++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, err := db.readPage(pagb, p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := db.checkPage(pagb, p); err != nil {
+			return err
+		}
+		if err := fn(pagb, p); err != nil {
+			return err
+		}
+	}
+}
+
+// copyPairs returns copies of the pairs stored in p.
+func copyPairs(p *Page) []Pair {
+	var pairs []Pair
+	p.forEachPair(func(key, val Datum) bool {
+		pairs = append(pairs, Pair{Key: cloneDatum(key), Val: cloneDatum(val)})
+		return true
+	})
+	return pairs
+}
+
+// cloneDatum returns a copy of d that does not alias d's backing array.
+// Unlike append, it keeps an empty non-nil Datum non-nil.
+func cloneDatum(d Datum) Datum {
+	if d == nil {
+		return nil
+	}
+	c := make(Datum, len(d))
+	copy(c, d)
+	return c
+}
+
+// Pages streams the pages of the .pag file, in page order, over the returned
+// channel. Each record carries copies of the page's pairs, so consumers may
+// retain them. The channel is closed after the last page, after a record
+// carrying an error, or when ctx is canceled.
+// The database must not be used by other calls until the channel is closed.
+func (db *DBM) Pages(ctx context.Context) (<-chan PageRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ch := make(chan PageRecord)
+	go func() {
+		defer close(ch)
+		send := func(rec PageRecord) error {
+			select {
+			case ch <- rec:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err := db.walkPages(func(pagb int64, p *Page) error {
+			return send(PageRecord{PageNo: pagb, Pairs: copyPairs(p)})
+		})
+		if err != nil && ctx.Err() == nil {
+			_ = send(PageRecord{PageNo: -1, Err: err})
+		}
+	}()
+	return ch, nil
+}
+
+// ForEach calls fn for every pair in the database, in page order. fn
+// receives copies of the key and value, which stay valid after the scan
+// moves on. The scan stops at the first error returned by fn, which ForEach
+// then returns. The cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) ForEach(fn func(key, val Datum) error) error {
+	return db.ForEachContext(context.Background(), fn)
+}
+
+// ForEachContext is ForEach returning ctx.Err() once ctx is done, checked
+// between pages.
+func (db *DBM) ForEachContext(ctx context.Context, fn func(key, val Datum) error) error {
+	return db.walkPagesContext(ctx, func(_ int64, p *Page) error {
+		for _, pair := range copyPairs(p) {
+			if err := fn(pair.Key, pair.Val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ScanPrefix calls fn for every pair whose key begins with prefix, in page
+// order, with copies of the key and value as ForEach does. Keys are placed
+// by their hash, not in order, so this is not an index lookup: ScanPrefix
+// reads every page of the database, however few keys match. The scan stops
+// at the first error returned by fn, which ScanPrefix then returns. The
+// cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) ScanPrefix(prefix Datum, fn func(key, val Datum) error) error {
+	return db.walkPages(func(_ int64, p *Page) error {
+		var err error
+		p.forEachPair(func(key, val Datum) bool {
+			if !bytes.HasPrefix(key, prefix) {
+				return true
+			}
+			err = fn(cloneDatum(key), cloneDatum(val))
+			return err == nil
+		})
+		return err
+	})
+}
+
+// RecoverScan is ForEach for a damaged database: a page that fails its
+// checksum or ChkPage is skipped, and logged through Options.Logger, rather
+// than ending the scan, so that the pairs of the other pages can be
+// rescued, for example by storing them in a new database. It returns the
+// numbers of the pages skipped. The scan stops at the first error returned
+// by fn or met reading the file, which RecoverScan then returns along with
+// the pages skipped so far. The cursor used by FirstKey and NextKey is not
+// disturbed.
+func (db *DBM) RecoverScan(fn func(key, val Datum) error) (skipped []int64, err error) {
+	p := db.newPage()
+	for pagb := int64(0); ; pagb++ {
+		ok, err := db.readPage(pagb, p)
+		if err != nil {
+			return skipped, err
+		}
+		if !ok {
+			return skipped, nil
+		}
+		if err := db.checkPage(pagb, p); err != nil {
+			if db.opts.Logger != nil {
+				db.opts.Logger.Printf("skip page %d: %v", pagb, err)
+			}
+			skipped = append(skipped, pagb)
+			continue
+		}
+		for _, pair := range copyPairs(p) {
+			if err := fn(pair.Key, pair.Val); err != nil {
+				return skipped, err
+			}
+		}
+	}
+}
+
+// ReverseForEach calls fn for every pair in the database, walking the pages
+// from the highest page of the .pag file down to page 0. fn receives copies
+// of the key and value. The scan stops at the first error returned by fn,
+// which ReverseForEach then returns. The cursor used by FirstKey and NextKey
+// is not disturbed.
+func (db *DBM) ReverseForEach(fn func(key, val Datum) error) error {
+	size, err := db.pagf.Size()
+	if err != nil {
+		return wrapIOErr("stat", db.pagf.Name(), err)
+	}
+
+	p := db.newPage()
+	pblksiz := int64(db.format.PageSize)
+	for pagb := (size-db.pagBase+pblksiz-1)/pblksiz - 1; pagb >= 0; pagb-- {
+		if _, err := db.readPage(pagb, p); err != nil {
+			return err
+		}
+		if err := db.checkPage(pagb, p); err != nil {
+			return err
+		}
+		for _, pair := range copyPairs(p) {
+			if err := fn(pair.Key, pair.Val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// KeyPageMap returns a map from every key, as a string, to the number of the
+// page it is stored on. It is computed in a single scan of the .pag file
+// rather than by walking the trie for each key. The map holds a copy of
+// every key, so it needs memory proportional to the total key size plus
+// per-entry map overhead; avoid it on very large databases.
+func (db *DBM) KeyPageMap() (map[string]int64, error) {
+	m := make(map[string]int64)
+	err := db.walkPages(func(pagb int64, p *Page) error {
+		p.forEachPair(func(key, _ Datum) bool {
+			m[string(key)] = pagb
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Extremes scans the .pag file once and reports the longest key and the
+// longest value in the database together with their lengths, which helps to
+// judge how close the entries are to PairMax. The returned key and value are
+// copies. Only the sizes recorded in each page's offset table are compared;
+// ties keep the entry found first. An empty database yields nil Datums and
+// zero lengths.
+func (db *DBM) Extremes() (largestKey, largestVal Datum, maxKeyLen, maxValLen int, err error) {
+	maxKeyLen, maxValLen = -1, -1
+	err = db.walkPages(func(_ int64, p *Page) error {
+		p.forEachPair(func(key, val Datum) bool {
+			if len(key) > maxKeyLen {
+				largestKey, maxKeyLen = cloneDatum(key), len(key)
+			}
+			if len(val) > maxValLen {
+				largestVal, maxValLen = cloneDatum(val), len(val)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	return largestKey, largestVal, max(maxKeyLen, 0), max(maxValLen, 0), nil
+}
+
+// RawPages calls fn with a copy of the raw bytes of every page of the .pag
+// file, in page order, and reports whether the page passed ChkPage and, in
+// a database with FeatureChecksums, matched its checksum. Unlike
+// the other scans it does not stop at a corrupt page, so recovery tools can
+// salvage what the normal API rejects. The scan stops at the first error
+// returned by fn, which RawPages then returns.
+func (db *DBM) RawPages(fn func(pageNo int64, raw []byte, valid bool) error) error {
+	p := db.newPage()
+	for pagb := int64(0); ; pagb++ {
+		ok, err := db.readPage(pagb, p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		raw := bytes.Clone(p.buf)
+		if err := fn(pagb, raw, db.checkPage(pagb, p) == nil); err != nil {
+			return err
+		}
+	}
+}
+
+// TotalFreeBytes returns the size of the free area summed over every page of
+// the .pag file, computed in a single scan. It measures how much can still be
+// inserted without growing the file, although a pair only fits where its own
+// page has room. Pages never written, such as holes left by splits, count as
+// entirely free.
+func (db *DBM) TotalFreeBytes() (int64, error) {
+	var total int64
+	err := db.walkPages(func(_ int64, p *Page) error {
+		total += int64(p.free())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// ForEachKey calls fn with a copy of every key in the database, in page
+// order. Keys are located with GetNKey, so values are never read. The scan
+// stops at the first error returned by fn, which ForEachKey then returns.
+// The cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) ForEachKey(fn func(key Datum) error) error {
+	return db.walkPages(func(_ int64, p *Page) error {
+		for i := 1; ; i++ {
+			key := p.GetNKey(i)
+			if key == nil {
+				return nil
+			}
+			if err := fn(cloneDatum(key)); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Count returns the number of pairs in the database, summing the entry
+// counts of every page of the .pag file. Holes read as empty pages. The scan
+// reads pages on its own, so the cursor used by FirstKey and NextKey is not
+// disturbed.
+func (db *DBM) Count() (int, error) {
+	return db.CountContext(context.Background())
+}
+
+// CountContext is Count returning ctx.Err() once ctx is done, checked
+// between pages.
+func (db *DBM) CountContext(ctx context.Context) (int, error) {
+	n := 0
+	err := db.walkPagesContext(ctx, func(_ int64, p *Page) error {
+		n += int(p.getN()) / 2
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// errStopIteration ends a page walk when the consumer of an iterator stops.
+var errStopIteration = errors.New("sdbm: iteration stopped")
+
+// Keys returns an iterator over copies of every key in the database, in
+// page order, for use with range:
+//
+//	for key, err := range db.Keys() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// A failed page read or a page failing ChkPage is yielded as the error of a
+// last nil key. The cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) Keys() iter.Seq2[Datum, error] {
+	return func(yield func(Datum, error) bool) {
+		err := db.ForEachKey(func(key Datum) error {
+			if !yield(key, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			yield(Nullitem, err)
+		}
+	}
+}
+
+// Pairs returns an iterator over every pair in the database, in page
+// order, for use with range like Keys. Each key and value is read from the
+// offset table of the page holding it rather than fetched by hash, and both
+// are copies, so they stay valid after the iteration moves on. A failed
+// page read or a page failing ChkPage is yielded as the error of a last
+// empty Pair. The cursor used by FirstKey and NextKey is not disturbed.
+func (db *DBM) Pairs() iter.Seq2[Pair, error] {
+	return func(yield func(Pair, error) bool) {
+		err := db.walkPages(func(_ int64, p *Page) error {
+			var err error
+			p.forEachPair(func(key, val Datum) bool {
+				if !yield(Pair{Key: cloneDatum(key), Val: cloneDatum(val)}, nil) {
+					err = errStopIteration
+					return false
+				}
+				return true
+			})
+			return err
+		})
+		if err != nil && err != errStopIteration {
+			yield(Pair{}, err)
+		}
+	}
+}
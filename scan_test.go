@@ -0,0 +1,662 @@
+package sdbm_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Pages(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	ch, err := dbm.Pages(context.Background())
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+	seen := make(map[string]string)
+	pages := 0
+	for rec := range ch {
+		if rec.Err != nil {
+			t.Fatalf("Pages() record error = %v", rec.Err)
+		}
+		if rec.PageNo != int64(pages) {
+			t.Errorf("PageNo got = %d, want %d", rec.PageNo, pages)
+		}
+		pages++
+		for _, p := range rec.Pairs {
+			seen[p.Key.String()] = p.Val.String()
+		}
+	}
+	if len(seen) != len(pairs) {
+		t.Fatalf("Pages() pairs got = %d, want %d", len(seen), len(pairs))
+	}
+	for _, p := range pairs {
+		if seen[p.Key.String()] != p.Val.String() {
+			t.Errorf("Pages() %s got = %s, want %s", p.Key, seen[p.Key.String()], p.Val)
+		}
+	}
+}
+
+func TestDBM_Pages_Cancel(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, dbm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := dbm.Pages(ctx)
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+	<-ch
+	cancel()
+	for range ch {
+	}
+	if _, err := dbm.Pages(ctx); err == nil {
+		t.Error("Pages() want error for a canceled context")
+	}
+}
+
+func TestDBM_ReverseForEach(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	ch, err := dbm.Pages(context.Background())
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+	pageOf := make(map[string]int64)
+	for rec := range ch {
+		for _, p := range rec.Pairs {
+			pageOf[p.Key.String()] = rec.PageNo
+		}
+	}
+
+	last := int64(1 << 62)
+	seen := make(map[string]bool)
+	err = dbm.ReverseForEach(func(key, val sdbm.Datum) error {
+		page := pageOf[key.String()]
+		if page > last {
+			t.Errorf("ReverseForEach() visited page %d after page %d", page, last)
+		}
+		last = page
+		if seen[key.String()] {
+			t.Errorf("ReverseForEach() visited %s twice", key)
+		}
+		seen[key.String()] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReverseForEach() error = %v", err)
+	}
+	if len(seen) != len(pairs) {
+		t.Errorf("ReverseForEach() visited got = %d, want %d", len(seen), len(pairs))
+	}
+	if last != 0 {
+		t.Errorf("ReverseForEach() last page got = %d, want 0", last)
+	}
+}
+
+func TestDBM_KeyPageMap(t *testing.T) {
+	pairs := generatePairs("key", "val", 2000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	m, err := dbm.KeyPageMap()
+	if err != nil {
+		t.Fatalf("KeyPageMap() error = %v", err)
+	}
+	if len(m) != len(pairs) {
+		t.Fatalf("KeyPageMap() keys got = %d, want %d", len(m), len(pairs))
+	}
+	pages := make(map[int64]int)
+	for _, p := range pairs {
+		_, depth, err := dbm.LookupCost(p.Key)
+		if err != nil {
+			t.Fatalf("LookupCost() error = %v", err)
+		}
+		want := sdbm.Hash(p.Key) & (1<<depth - 1)
+		if m[p.Key.String()] != want {
+			t.Errorf("KeyPageMap()[%s] got = %d, want %d", p.Key, m[p.Key.String()], want)
+		}
+		pages[want]++
+	}
+	shared := false
+	for _, n := range pages {
+		shared = shared || n > 1
+	}
+	if !shared {
+		t.Error("no two keys share a page")
+	}
+}
+
+func TestDBM_Extremes(t *testing.T) {
+	tests := []struct {
+		name       string
+		pairs      []Pair
+		wantKey    sdbm.Datum
+		wantVal    sdbm.Datum
+		wantKeyLen int
+		wantValLen int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "varied sizes",
+			pairs: append(generatePairs("key", "val", 500),
+				Pair{Key: sdbm.Datum(strings.Repeat("k", 300)), Val: sdbm.Datum("v")},
+				Pair{Key: sdbm.Datum("big"), Val: sdbm.Datum(strings.Repeat("v", 700))},
+			),
+			wantKey:    sdbm.Datum(strings.Repeat("k", 300)),
+			wantVal:    sdbm.Datum(strings.Repeat("v", 700)),
+			wantKeyLen: 300,
+			wantValLen: 700,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dbm := setup(t, tt.pairs...)
+			defer teardown(t, dbm)
+
+			key, val, keyLen, valLen, err := dbm.Extremes()
+			if err != nil {
+				t.Fatalf("Extremes() error = %v", err)
+			}
+			if !reflect.DeepEqual(key, tt.wantKey) {
+				t.Errorf("Extremes() largestKey got = %q, want %q", key, tt.wantKey)
+			}
+			if !reflect.DeepEqual(val, tt.wantVal) {
+				t.Errorf("Extremes() largestVal got = %q, want %q", val, tt.wantVal)
+			}
+			if keyLen != tt.wantKeyLen || valLen != tt.wantValLen {
+				t.Errorf("Extremes() lengths got = %d, %d, want %d, %d", keyLen, valLen, tt.wantKeyLen, tt.wantValLen)
+			}
+		})
+	}
+}
+
+func TestDBM_RawPages(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, dbm)
+
+	pages, err := dbm.Pages(context.Background())
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+	want := 0
+	for range pages {
+		want++
+	}
+
+	// claim more entries than the page can hold.
+	const corrupt = 1
+	f, err := os.OpenFile(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff, 0x7f}, corrupt*sdbm.PBLKSIZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := 0
+	err = dbm.RawPages(func(pageNo int64, raw []byte, valid bool) error {
+		if pageNo != int64(got) {
+			t.Errorf("RawPages() pageNo got = %d, want %d", pageNo, got)
+		}
+		if len(raw) != sdbm.PBLKSIZ {
+			t.Errorf("RawPages() raw length got = %d, want %d", len(raw), sdbm.PBLKSIZ)
+		}
+		if valid != (pageNo != corrupt) {
+			t.Errorf("RawPages() page %d valid got = %v, want %v", pageNo, valid, pageNo != corrupt)
+		}
+		if pageNo == corrupt && (raw[0] != 0xff || raw[1] != 0x7f) {
+			t.Errorf("RawPages() page %d raw got = % x, want the corrupt bytes", pageNo, raw[:2])
+		}
+		got++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RawPages() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("RawPages() pages got = %d, want %d", got, want)
+	}
+}
+
+func TestDBM_TotalFreeBytes(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	free := func() int64 {
+		t.Helper()
+		n, err := dbm.TotalFreeBytes()
+		if err != nil {
+			t.Fatalf("TotalFreeBytes() error = %v", err)
+		}
+		return n
+	}
+
+	before := free()
+	if before <= 0 || before >= sdbm.PBLKSIZ {
+		t.Fatalf("TotalFreeBytes() got = %d, want between 0 and %d", before, sdbm.PBLKSIZ)
+	}
+	if _, err := dbm.Delete(sdbm.Datum("key1")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	afterDelete := free()
+	if want := before + int64(len("key1")+len("val1")+2*sdbm.SHORTSIZE); afterDelete != want {
+		t.Errorf("TotalFreeBytes() after Delete got = %d, want %d", afterDelete, want)
+	}
+	if _, err := dbm.Store(sdbm.Datum("longer-key"), sdbm.Datum("longer-value"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if afterStore := free(); afterStore >= afterDelete {
+		t.Errorf("TotalFreeBytes() after Store got = %d, want less than %d", afterStore, afterDelete)
+	}
+}
+
+func TestDBM_ForEachKey(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	seen := make(map[string]int)
+	var retained []sdbm.Datum
+	err := dbm.ForEachKey(func(key sdbm.Datum) error {
+		seen[key.String()]++
+		retained = append(retained, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachKey() error = %v", err)
+	}
+	if len(seen) != len(pairs) {
+		t.Errorf("ForEachKey() keys got = %d, want %d", len(seen), len(pairs))
+	}
+	for _, p := range pairs {
+		if seen[p.Key.String()] != 1 {
+			t.Errorf("ForEachKey() visited %s %d times, want 1", p.Key, seen[p.Key.String()])
+		}
+	}
+	// the keys are copies, so they survive the scan.
+	for _, key := range retained {
+		if seen[key.String()] != 1 {
+			t.Fatalf("ForEachKey() retained key %q was overwritten", key)
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = dbm.ForEachKey(func(sdbm.Datum) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("ForEachKey() got = %d calls, %v, want 1 call, %v", n, err, stop)
+	}
+}
+
+func TestDBM_Count(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "one page", pairs: generatePairs("key", "val", 10)},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dbm := setup(t, tt.pairs...)
+			defer teardown(t, dbm)
+
+			first, err := dbm.FirstKey()
+			if err != nil {
+				t.Fatalf("FirstKey() error = %v", err)
+			}
+			want, err := dbm.NextKey()
+			if err != nil {
+				t.Fatalf("NextKey() error = %v", err)
+			}
+
+			got, err := dbm.Count()
+			if err != nil {
+				t.Fatalf("Count() error = %v", err)
+			}
+			if got != len(tt.pairs) {
+				t.Errorf("Count() got = %d, want %d", got, len(tt.pairs))
+			}
+
+			// the iteration resumes where it was.
+			if first == nil || want == nil {
+				return
+			}
+			if _, err := dbm.FirstKey(); err != nil {
+				t.Fatalf("FirstKey() error = %v", err)
+			}
+			if _, err := dbm.Count(); err != nil {
+				t.Fatalf("Count() error = %v", err)
+			}
+			if next, err := dbm.NextKey(); err != nil || !reflect.DeepEqual(next, want) {
+				t.Errorf("NextKey() after Count got = %q, %v, want %q", next, err, want)
+			}
+		})
+	}
+}
+
+func TestDBM_Keys(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	dir, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	seen := make(map[string]int)
+	for key, err := range dbm.Keys() {
+		if err != nil {
+			t.Fatalf("Keys() error = %v", err)
+		}
+		seen[key.String()]++
+	}
+	if len(seen) != len(pairs) {
+		t.Errorf("Keys() keys got = %d, want %d", len(seen), len(pairs))
+	}
+	for _, p := range pairs {
+		if seen[p.Key.String()] != 1 {
+			t.Errorf("Keys() yielded %s %d times, want 1", p.Key, seen[p.Key.String()])
+		}
+	}
+
+	n := 0
+	for range dbm.Keys() {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("Keys() after break got = %d keys, want 1", n)
+	}
+
+	// claim more entries than page 1 can hold.
+	f, err := os.OpenFile(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff, 0x7f}, sdbm.PBLKSIZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var last error
+	for key, err := range dbm.Keys() {
+		if last != nil {
+			t.Fatalf("Keys() yielded %q after error %v", key, last)
+		}
+		last = err
+	}
+	if !errors.Is(last, sdbm.ErrInvalidPage) {
+		t.Errorf("Keys() error = %v, want %v", last, sdbm.ErrInvalidPage)
+	}
+}
+
+func TestDBM_Pairs(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	want := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		want[p.Key.String()] = p.Val.String()
+	}
+	var got []sdbm.Pair
+	for p, err := range dbm.Pairs() {
+		if err != nil {
+			t.Fatalf("Pairs() error = %v", err)
+		}
+		got = append(got, p)
+	}
+	if len(got) != len(pairs) {
+		t.Errorf("Pairs() pairs got = %d, want %d", len(got), len(pairs))
+	}
+	// the pairs are copies, so they survive the pages being reused.
+	for _, p := range got {
+		if want[p.Key.String()] != p.Val.String() {
+			t.Errorf("Pairs() got %s = %q, want %q", p.Key, p.Val, want[p.Key.String()])
+		}
+	}
+
+	n := 0
+	for range dbm.Pairs() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("Pairs() after break got = %d pairs, want 3", n)
+	}
+}
+
+func TestDBM_ForEach(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	want := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		want[p.Key.String()] = p.Val.String()
+	}
+	var retained []sdbm.Pair
+	err := dbm.ForEach(func(key, val sdbm.Datum) error {
+		retained = append(retained, sdbm.Pair{Key: key, Val: val})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach() error = %v", err)
+	}
+	if len(retained) != len(pairs) {
+		t.Errorf("ForEach() pairs got = %d, want %d", len(retained), len(pairs))
+	}
+	// the pairs are copies, so they survive the scan.
+	for _, p := range retained {
+		if want[p.Key.String()] != p.Val.String() {
+			t.Errorf("ForEach() got %s = %q, want %q", p.Key, p.Val, want[p.Key.String()])
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = dbm.ForEach(func(key, val sdbm.Datum) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("ForEach() got = %d calls, %v, want 1 call, %v", n, err, stop)
+	}
+}
+
+func TestDBM_ScanPrefix(t *testing.T) {
+	pairs := append(generatePairs("key", "val", 500), generatePairs("other", "val", 500)...)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   int
+	}{
+		{name: "shared prefix", prefix: "key", want: 500},
+		{name: "narrower prefix", prefix: "key1", want: 111},
+		{name: "whole key", prefix: "other499", want: 1},
+		{name: "empty prefix", prefix: "", want: 1000},
+		{name: "no match", prefix: "absent", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			err := dbm.ScanPrefix(sdbm.Datum(tt.prefix), func(key, val sdbm.Datum) error {
+				if !strings.HasPrefix(key.String(), tt.prefix) {
+					t.Errorf("ScanPrefix() got key %q without prefix %q", key, tt.prefix)
+				}
+				got++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ScanPrefix() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ScanPrefix() pairs got = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err := dbm.ScanPrefix(sdbm.Datum("key"), func(key, val sdbm.Datum) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("ScanPrefix() got = %d calls, %v, want 1 call, %v", n, err, stop)
+	}
+}
+
+func TestDBM_ForEachContext(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	err := dbm.ForEachContext(ctx, func(key, val sdbm.Datum) error {
+		n++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ForEachContext() error = %v, want %v", err, context.Canceled)
+	}
+	// the page being visited is finished, the rest are not read.
+	if n == 0 || n == len(pairs) {
+		t.Errorf("ForEachContext() calls got = %d, want those of the first page", n)
+	}
+
+	n = 0
+	err = dbm.ForEachContext(context.Background(), func(key, val sdbm.Datum) error {
+		n++
+		return nil
+	})
+	if err != nil || n != len(pairs) {
+		t.Errorf("ForEachContext() got = %d calls, %v, want %d", n, err, len(pairs))
+	}
+}
+
+func TestDBM_CountContext(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dbm.CountContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("CountContext() error = %v, want %v", err, context.Canceled)
+	}
+	if got, err := dbm.CountContext(context.Background()); err != nil || got != len(pairs) {
+		t.Errorf("CountContext() got = %d, %v, want %d", got, err, len(pairs))
+	}
+}
+
+func TestDBM_RecoverScan(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "invalid offsets"},
+		{name: "checksum mismatch", opts: sdbm.Options{Checksums: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			pairs := generatePairs("key", "val", 3000)
+			for _, p := range pairs {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			pages, err := db.KeyPageMap()
+			if err != nil {
+				t.Fatalf("KeyPageMap() error = %v", err)
+			}
+			base := int64(0)
+			if info, _ := db.FormatInfo(); info.Version > 0 {
+				base = sdbm.PBLKSIZ
+			}
+			teardown(t, db)
+
+			// give page 2 an entry count no page can hold.
+			f, err := os.OpenFile(path+sdbm.PAGFEXT, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt([]byte{0xfe, 0xff}, base+2*sdbm.PBLKSIZ); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := &captureLogger{}
+			opts.Flags, opts.Logger = os.O_RDONLY, logger
+			db, err = sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+			if err := db.ForEach(func(_, _ sdbm.Datum) error { return nil }); err == nil {
+				t.Fatal("ForEach() error = nil, want the corrupt page")
+			}
+
+			got := make(map[string]string)
+			skipped, err := db.RecoverScan(func(key, val sdbm.Datum) error {
+				got[key.String()] = val.String()
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("RecoverScan() error = %v", err)
+			}
+			if !reflect.DeepEqual(skipped, []int64{2}) {
+				t.Errorf("RecoverScan() skipped got = %v, want [2]", skipped)
+			}
+			for _, p := range pairs {
+				val, ok := got[p.Key.String()]
+				if lost := pages[p.Key.String()] == 2; ok == lost || ok && val != p.Val.String() {
+					t.Fatalf("RecoverScan() pair %s got = %q, %v, want it unless on page 2", p.Key, val, ok)
+				}
+			}
+			if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], "skip page 2: ") {
+				t.Errorf("Logger got = %q, want the page skipped", logger.lines)
+			}
+
+			stop := errors.New("stop")
+			if _, err := db.RecoverScan(func(_, _ sdbm.Datum) error { return stop }); !errors.Is(err, stop) {
+				t.Errorf("RecoverScan() error = %v, want %v", err, stop)
+			}
+		})
+	}
+}
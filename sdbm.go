@@ -1,22 +1,26 @@
 package sdbm
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 )
 
-var debug bool
-
 const (
 	// BITSIZ represents the number of bits per byte.
 	BITSIZ = 8
-	// DBLKSIZ defines the block size (in bytes) for a .dir file.
+	// DBLKSIZ defines the default block size (in bytes) for a .dir file.
 	DBLKSIZ = 4096
-	// PBLKSIZ defines the block size (in bytes) for a .pag file.
+	// PBLKSIZ defines the default block size (in bytes) for a .pag file.
 	PBLKSIZ = 1024
-	// PAIRMAX defines the maximum size (in bytes) of a key-value pair.
+	// PAIRMAX defines the maximum size (in bytes) of a key-value pair with
+	// the default block size. Other sizes allow PBLKSIZ-PAIRMAX bytes less
+	// than the block size; see DBM.PairMax.
 	PAIRMAX = 1008
 	// SPLTMAX defines the maximum number of page splits allowed during insertion.
 	SPLTMAX = 10
@@ -37,6 +41,9 @@ const (
 	StoreREPLACE StoreFlags = iota + 1
 	// StoreSEEDUPS indicates that duplicates should be avoided during insertion.
 	StoreSEEDUPS
+	// StoreINSERT indicates that the pair should only be stored if the key is
+	// absent. Store reports false, leaving the existing value, if it is present.
+	StoreINSERT
 )
 
 var (
@@ -46,6 +53,21 @@ var (
 	ErrInvalidPage = errors.New("invalid page")
 	// ErrDBMRDOnly indicates that a write operation was attempted on a read-only database.
 	ErrDBMRDOnly = errors.New("dbm read only")
+	// ErrWriteVerifyFailed indicates that a page read back after being written did not match what was written.
+	ErrWriteVerifyFailed = errors.New("write verify failed")
+	// ErrKeyTooLong indicates that a key is longer than PAIRMAX and therefore cannot be stored.
+	ErrKeyTooLong = errors.New("key too long")
+	// ErrKeyTooBig is ErrKeyTooLong under the name that pairs with ErrValueTooBig.
+	ErrKeyTooBig = ErrKeyTooLong
+	// ErrValueTooBig indicates that a key that fits on its own and its value
+	// together exceed PAIRMAX. It wraps ErrInvalidArgument, which Store
+	// returned for such pairs before.
+	ErrValueTooBig = fmt.Errorf("value too big: %w", ErrInvalidArgument)
+	// ErrSplitOverflow indicates that a page could not make room for a pair
+	// after SPLTMAX splits, because too many keys share its hash bits.
+	ErrSplitOverflow = errors.New("cannot insert after SPLTMAX splits")
+	// ErrNotFetchable indicates that a value stored by AssertFetchable could not be fetched back.
+	ErrNotFetchable = errors.New("key not fetchable")
 )
 
 // IOError records an error along with the operation and file path that caused it.
@@ -67,61 +89,172 @@ func (e *IOError) Unwrap() error {
 	return e.Err
 }
 
+// bad reports whether x is unusable as a key. Only nil is; an empty key is valid.
 func bad(x Datum) bool {
 	return x == nil
 }
 
-func exHash(item Datum) int64 {
+// checkKey validates a key passed to Fetch, Delete or Store.
+// Keys longer than PairMax can never be stored, and rejecting them here
+// keeps the uint16 offset arithmetic in the page code from wrapping.
+func (db *DBM) checkKey(key Datum) error {
+	if bad(key) {
+		return ErrInvalidArgument
+	}
+	if key.Size() > db.PairMax() {
+		return ErrKeyTooLong
+	}
+	return nil
+}
+
+// checkPair fails with the error Store would fail with for key and val
+// before writing anything: for a bad or too long key, on a read-only
+// database, or for a pair too big for a page. With Options.InternValues or
+// Options.OverflowThreshold the page holds a reference in place of a long
+// value, so only the key and the reference have to fit.
+func (db *DBM) checkPair(key, val Datum) error {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return err
+	}
+	if db.rdonly {
+		return ErrDBMRDOnly
+	}
+	n := val.Size()
+	switch {
+	case db.blobs != nil:
+		n = sha256.Size
+	case db.ovf != nil:
+		n = 1 + val.Size()
+		if val.Size() > db.opts.OverflowThreshold || key.Size()+n > db.PairMax() {
+			n = ovfRefSize
+		}
+	}
+	if key.Size()+n > db.PairMax() {
+		return ErrValueTooBig
+	}
+	return nil
+}
+
+// exHash hashes item with Options.HashFunc, or Hash if it is not set.
+func (db *DBM) exHash(item []byte) int64 {
+	if db.opts.HashFunc != nil {
+		return db.opts.HashFunc(item)
+	}
 	return Hash(item)
 }
 
+// normKey returns the canonical form of key given by Options.KeyNormalizer.
+func (db *DBM) normKey(key Datum) Datum {
+	if key == nil || db.opts.KeyNormalizer == nil {
+		return key
+	}
+	return db.opts.KeyNormalizer(key)
+}
+
 func wrapIOErr(op, path string, err error) error {
 	return &IOError{Op: op, Path: path, Err: err}
 }
 
-func offPag(off int64) int64 {
-	return off * PBLKSIZ
+// openFile opens one of the database files, reporting a failure as an
+// IOError that names the file.
+func openFile(name string, flags int, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(name, flags, mode)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, wrapIOErr("open", name, err)
+	}
+	return f, nil
 }
 
-func offDir(off int64) int64 {
-	return off * DBLKSIZ
+// offPag returns the offset of page pagb in the .pag file.
+func (db *DBM) offPag(pagb int64) int64 {
+	return db.pagBase + pagb*int64(db.format.PageSize)
 }
 
-func seekWrite(f *os.File, offset int64, whence int, buf []byte) error {
-	if _, err := f.Seek(offset, whence); err != nil {
-		return wrapIOErr("seek", f.Name(), err)
-	}
-	if _, err := f.Write(buf); err != nil {
-		return wrapIOErr("write", f.Name(), err)
+// offDir returns the offset of block dirb in the .dir file.
+func (db *DBM) offDir(dirb int64) int64 {
+	return dirb * int64(db.format.DirBlockSize)
+}
+
+// newPage returns an empty page of the page size of the database.
+func (db *DBM) newPage() *Page {
+	p := newPage(db.format.PageSize)
+	p.be = db.bigEndian()
+	return p
+}
+
+// bigEndian reports whether the page offsets of the database are big-endian.
+func (db *DBM) bigEndian() bool {
+	return db.format.ByteOrder == binary.BigEndian
+}
+
+// PairMax returns the maximum size in bytes of a key and value stored
+// together, which follows from the page size: PAIRMAX for the default
+// PBLKSIZ, and 16 bytes less than Options.PageSize otherwise.
+func (db *DBM) PairMax() int {
+	return db.format.PageSize - (PBLKSIZ - PAIRMAX)
+}
+
+// writeAt writes buf at offset in f. Page I/O names its offsets instead of
+// relying on the shared file offset, so reads never depend on the I/O done
+// before them.
+func writeAt(f File, offset int64, buf []byte) error {
+	if _, err := f.WriteAt(buf, offset); err != nil {
+		return wrapIOErr("writeat", f.Name(), err)
 	}
 	return nil
 }
 
-func seekRead(f *os.File, offset int64, whence int, buf []byte) error {
-	if _, err := f.Seek(offset, whence); err != nil {
-		return wrapIOErr("seek", f.Name(), err)
+// zeroFill writes zeros to f from its end up to offset, in chunks of at
+// most blk bytes, so that no hole is left before a block written there.
+func zeroFill(f File, offset int64, blk int) error {
+	size, err := f.Size()
+	if err != nil {
+		return wrapIOErr("stat", f.Name(), err)
 	}
-	if _, err := f.Read(buf); err != nil {
-		if !errors.Is(err, io.EOF) {
-			return wrapIOErr("read", f.Name(), err)
+	if size >= offset {
+		return nil
+	}
+	zeros := make([]byte, min(int64(blk), offset-size))
+	for size < offset {
+		n := min(int64(len(zeros)), offset-size)
+		if err := writeAt(f, size, zeros[:n]); err != nil {
+			return err
 		}
+		size += n
 	}
 	return nil
 }
 
-var masks = []int64{
-	000000000000, 000000000001, 000000000003, 000000000007,
-	000000000017, 000000000037, 000000000077, 000000000177,
-	000000000377, 000000000777, 000000001777, 000000003777,
-	000000007777, 000000017777, 000000037777, 000000077777,
-	000000177777, 000000377777, 000000777777, 000001777777,
-	000003777777, 000007777777, 000017777777, 000037777777,
-	000077777777, 000177777777, 000377777777, 000777777777,
-	001777777777, 003777777777, 007777777777, 017777777777,
+// readAt fills buf from offset in f. Whatever lies beyond the end of the
+// file reads as zeros.
+func readAt(f File, offset int64, buf []byte) error {
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return wrapIOErr("readat", f.Name(), err)
+	}
+	clear(buf[n:])
+	return nil
+}
+
+// maxDepth is the deepest the directory trie goes, in hash bits. It is
+// bounded by the bit numbers of the directory, which double with every
+// level and must still fit an int64 at the deepest one.
+const maxDepth = 62
+
+// mask returns the hash mask selecting the page at depth hbit of the trie.
+func mask(hbit int64) int64 {
+	return 1<<hbit - 1
 }
 
 // Datum represents a data item, typically used as a key or value in the SDBM database.
 // It is a byte slice that can hold arbitrary data.
+// A nil Datum is never a valid key, but a zero-length, non-nil Datum such as
+// Datum("") is: it hashes to 0 and is stored, fetched and deleted like any other key.
 type Datum []byte
 
 // Size returns the length of the data item in bytes.
@@ -141,113 +274,564 @@ var Nullitem = Datum(nil)
 // DBM represents a simple database manager for SDBM files.
 // It manages the directory (.dir) and page (.pag) files that store the key-value pairs.
 type DBM struct {
-	dirf   *os.File      // directory file
-	pagf   *os.File      // page file
-	rdonly bool          // read only flag
-	maxbno int64         // size of dirfile in bits
-	curbit int64         // current bit number
-	hmask  int64         // current hash mask
-	blkptr int64         // current block for next key
-	keyptr int           // current key for next key
-	pagbno int64         // current page in pag
-	pag    *Page         // page file block buffer
-	dirbno int64         // current block in dirbuf
-	dirbuf [DBLKSIZ]byte // directory file block buffer
+	dirf    File                // directory file
+	pagf    File                // page file
+	rdonly  bool                // read only flag
+	maxbno  int64               // size of dirfile in bits
+	curbit  int64               // current bit number
+	hmask   int64               // current hash mask
+	blkptr  int64               // current block for next key
+	keyptr  int                 // current key for next key
+	iter    *Page               // copy of page blkptr the keys are returned from
+	gone    map[string]struct{} // keys deleted from page blkptr since it was read
+	pagbno  int64               // current page in pag
+	pag     *Page               // page file block buffer
+	dirbno  int64               // current block in dirbuf
+	dirbuf  []byte              // directory file block buffer
+	dirty   bool                // current page was modified but not written
+	opts    Options             // options the database was opened with
+	blobs   *DBM                // shared value store for Options.InternValues
+	ovf     *overflow           // overflow file for Options.OverflowThreshold
+	wal     *os.File            // write-ahead log for Options.WAL
+	walHook func() error        // called after each log record is synced, by tests
+	cow     *cowState           // open snapshots
+	format  FormatInfo          // format read from the header
+	pagBase int64               // offset of page 0 in the .pag file
+	dirSync bool                // .dir written since the last Options.SyncOnWrite sync
+	pagSync bool                // .pag written since the last Options.SyncOnWrite sync
+	cache   *pageCache          // recently used pages for Options.PageCacheSize
+	file    string              // name the files were opened by, "" for OpenFiles
+	dirSeen int64               // .dir size at the last Options.RefreshOnChange check
+	pagSeen int64               // .pag size at the last Options.RefreshOnChange check
 }
 
 // Open initializes and opens an SDBM database from the specified file.
 // It accepts the file name, flags (such as read/write permissions), and file mode.
 // It returns a DBM pointer and an error if opening the database fails.
 func Open(file string, flags int, mode os.FileMode) (*DBM, error) {
+	return OpenWithOptions(file, Options{Flags: flags, Mode: mode})
+}
+
+// OpenReadOnly opens an existing database read-only, as Options.ReadOnly
+// does: Store, Delete and the other writes fail with ErrDBMRDOnly, without
+// depending on how flags passed to Open combine with os.O_RDONLY.
+func OpenReadOnly(file string) (*DBM, error) {
+	return OpenWithOptions(file, Options{ReadOnly: true})
+}
+
+// MustOpen is Open panicking if the database cannot be opened, for
+// programs that cannot run without it, such as to initialize a package
+// variable. The panic value is an error wrapping the one Open returned.
+func MustOpen(file string, flags int, mode os.FileMode) *DBM {
+	db, err := Open(file, flags, mode)
+	if err != nil {
+		panic(fmt.Errorf("sdbm: open %s: %w", file, err))
+	}
+	return db
+}
+
+// OpenWithOptions initializes and opens an SDBM database from the specified file
+// using the settings in opts.
+// It returns a DBM pointer and an error if opening the database fails.
+func OpenWithOptions(file string, opts Options) (*DBM, error) {
 	if file == "" {
 		return nil, ErrInvalidArgument
 	}
 
-	dirname := file + DIRFEXT
-	pagname := file + PAGFEXT
-
-	db, err := Prep(dirname, pagname, flags, mode)
-	if err != nil {
+	db := &DBM{}
+	if err := db.open(file, opts); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
+// open opens the files of the database named file into a zeroed DBM.
+func (db *DBM) open(file string, opts Options) error {
+	if err := db.init(file+DIRFEXT, file+PAGFEXT, opts); err != nil {
+		return err
+	}
+	db.file = file
+	if opts.InternValues {
+		if err := db.openBlobs(file); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	if opts.OverflowThreshold > 0 {
+		if opts.InternValues {
+			_ = db.Close()
+			return ErrInvalidArgument
+		}
+		if err := db.openOverflow(file); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	if opts.WAL {
+		if err := db.openWAL(file); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	return nil
+}
+
 // Prep prepares the DBM structure by opening the directory (.dir) and page (.pag) files.
 // It adjusts the flags to handle read/write modes and sets the internal read-only flag if necessary.
 // It returns a pointer to the initialized DBM structure and an error if any step fails.
 func Prep(dirname, pagname string, flags int, mode os.FileMode) (*DBM, error) {
+	return prep(dirname, pagname, Options{Flags: flags, Mode: mode})
+}
+
+// OpenFiles opens a database kept in dirf and pagf, its .dir and .pag files,
+// instead of files on disk. Flags and ReadOnly decide whether it is
+// writable, as with OpenWithOptions, and Mode is not used. InternValues,
+// OverflowThreshold and WAL need files of their own, so they fail with
+// ErrInvalidArgument, and so does Reorganize on the database returned.
+// Close closes dirf and pagf.
+func OpenFiles(dirf, pagf File, opts Options) (*DBM, error) {
+	if dirf == nil || pagf == nil || opts.InternValues || opts.OverflowThreshold > 0 || opts.WAL {
+		return nil, ErrInvalidArgument
+	}
+	db := &DBM{}
+	if err := db.attach(dirf, pagf, opts); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func prep(dirname, pagname string, opts Options) (*DBM, error) {
 	db := &DBM{}
+	if err := db.init(dirname, pagname, opts); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// init opens the files of a database into a zeroed DBM.
+func (db *DBM) init(dirname, pagname string, opts Options) error {
+	flags, rdonly := openFlags(opts)
+
+	// open the files in sequence. If we fail anywhere, undo everything.
+	dirf, err := openFile(dirname, flags, opts.Mode)
+	if err != nil {
+		return err
+	}
+	pagf, err := openFile(pagname, flags, opts.Mode)
+	if err != nil {
+		_ = dirf.Close()
+		return err
+	}
+	if !opts.NoLock {
+		if err := lockPag(pagf, rdonly); err != nil {
+			_ = dirf.Close()
+			_ = pagf.Close()
+			return err
+		}
+	}
+	return db.attach(osFile{dirf}, osFile{pagf}, opts)
+}
+
+// openFlags returns the flags to open the files of a database with, and
+// whether the database is read-only.
+func openFlags(opts Options) (flags int, rdonly bool) {
+	flags = opts.Flags
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
 	// adjust user flags so that WRONLY becomes RDWR,
 	// as required by this package. Also set our internal
-	// flag for RDONLY if needed.
+	// flag for RDONLY if needed: O_RDONLY is zero, so it is the
+	// absence of a write mode whatever other flags come with it.
 	if flags&os.O_WRONLY != 0 {
 		flags = (flags &^ os.O_WRONLY) | os.O_RDWR
-	} else if flags == os.O_RDONLY {
-		db.rdonly = true
+	} else if flags&(os.O_RDWR|os.O_WRONLY) == 0 {
+		rdonly = true
 	}
+	return flags, rdonly
+}
 
-	// open the files in sequence, and stat the dirfile.
-	// If we fail anywhere, undo everything, return NULL.
-	var err error
-	db.dirf, err = os.OpenFile(dirname, flags, mode)
-	if err != nil {
-		return nil, err
+// attach sets up a zeroed DBM over open .dir and .pag files, closing them
+// if it fails.
+func (db *DBM) attach(dirf, pagf File, opts Options) error {
+	if err := checkFormatOptions(opts); err != nil {
+		_ = dirf.Close()
+		_ = pagf.Close()
+		return err
 	}
-	db.pagf, err = os.OpenFile(pagname, flags, mode)
+	opts.SyncOnWrite = opts.SyncOnWrite || opts.Sync
+	db.opts = opts
+	db.cow = &cowState{}
+	_, db.rdonly = openFlags(opts)
+	db.dirf = dirf
+	db.pagf = pagf
+
+	// need the dirfile size to establish max bit number.
+	dirSize, err := db.dirf.Size()
 	if err != nil {
 		_ = db.dirf.Close()
-		return nil, err
+		_ = db.pagf.Close()
+		return wrapIOErr("stat", db.dirf.Name(), err)
 	}
-
-	fileInfo, err := db.dirf.Stat()
-	if err != nil {
+	if err := db.readHeader(); err != nil {
 		_ = db.dirf.Close()
 		_ = db.pagf.Close()
-		return nil, err
+		return err
+	}
+
+	if !opts.LazyBuffers {
+		db.acquireBuffers()
 	}
 
-	// need the dirfile size to establish max bit number.
-	//
 	// zero size: either a fresh database, or one with a single,
 	// unsplit data page: dirpage is all zeros.
-	if fileInfo.Size() == 0 {
+	if dirSize == 0 {
 		db.dirbno = 0
 	} else {
 		db.dirbno = -1
 	}
 	db.pagbno = -1
-	db.maxbno = fileInfo.Size() * BITSIZ
-
-	db.pag = &Page{}
+	db.maxbno = dirSize * BITSIZ
+	if opts.PageCacheSize > 1 {
+		db.cache = newPageCache(opts.PageCacheSize)
+	}
 
-	return db, nil
+	return nil
 }
 
 // Close closes the DBM database by closing both the directory (.dir) and page (.pag) files.
 // It returns an error if there is an issue closing either of the files.
 func (db *DBM) Close() error {
+	// a clean close checkpoints, leaving nothing to replay.
+	var errWAL error
+	if db.wal != nil {
+		errWAL = db.Sync()
+		if err := db.wal.Close(); err != nil && errWAL == nil {
+			errWAL = wrapIOErr("close", db.wal.Name(), err)
+		}
+	}
+
 	errDir := db.dirf.Close()
+	db.unlockPag()
 	errPag := db.pagf.Close()
 
+	if db.opts.LazyBuffers {
+		db.Release()
+	}
+
 	if errDir != nil {
 		return wrapIOErr("close", db.dirf.Name(), errDir)
 	}
+
 	if errPag != nil {
 		return wrapIOErr("close", db.pagf.Name(), errPag)
 	}
+	if db.ovf != nil {
+		if err := db.ovf.f.Close(); err != nil {
+			return wrapIOErr("close", db.ovf.f.Name(), err)
+		}
+	}
+	if errWAL != nil {
+		return errWAL
+	}
+	if db.blobs != nil {
+		return db.blobs.Close()
+	}
+	return nil
+}
+
+// Sync commits the contents of the directory (.dir) and page (.pag) files to stable storage.
+func (db *DBM) Sync() error {
+	if err := db.dirf.Sync(); err != nil {
+		return wrapIOErr("sync", db.dirf.Name(), err)
+	}
+	if err := db.pagf.Sync(); err != nil {
+		return wrapIOErr("sync", db.pagf.Name(), err)
+	}
+	if db.ovf != nil {
+		if err := db.ovf.f.Sync(); err != nil {
+			return wrapIOErr("sync", db.ovf.f.Name(), err)
+		}
+	}
+	if db.blobs != nil {
+		if err := db.blobs.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.wal != nil {
+		// everything logged so far is durable in the data files now.
+		return db.resetWAL()
+	}
 	return nil
 }
 
+// syncWritten syncs the files written since it was last called, for
+// Options.SyncOnWrite.
+func (db *DBM) syncWritten() error {
+	if db.dirSync {
+		if err := db.dirf.Sync(); err != nil {
+			return wrapIOErr("sync", db.dirf.Name(), err)
+		}
+		db.dirSync = false
+	}
+	if db.pagSync {
+		if err := db.pagf.Sync(); err != nil {
+			return wrapIOErr("sync", db.pagf.Name(), err)
+		}
+		db.pagSync = false
+	}
+	if db.ovf != nil && db.ovf.written {
+		if err := db.ovf.f.Sync(); err != nil {
+			return wrapIOErr("sync", db.ovf.f.Name(), err)
+		}
+		db.ovf.written = false
+	}
+	return nil
+}
+
+// IsStale reports whether the .dir file has grown since the handle last
+// learned its size. A handle only tracks the directory growth it makes
+// itself, so a reader sharing the files with a writer goes on walking the
+// trie it saw at open and silently misses keys on pages split off since.
+// A stale handle should be reopened with Reopen. Such a reader
+// must be opened with Options.NoLock, since the writer locks the files.
+func (db *DBM) IsStale() (bool, error) {
+	size, err := db.dirf.Size()
+	if err != nil {
+		return false, wrapIOErr("stat", db.dirf.Name(), err)
+	}
+	return size*BITSIZ > db.maxbno, nil
+}
+
+// Reset closes the files of the database, if they are open, and rebinds the
+// handle to the database in file, as Open would. All cached pages, directory
+// blocks and cursor state are discarded, so nothing read from the previous
+// file can leak into the new one. Options other than the flags and mode are
+// kept. Reset fails with ErrInvalidArgument while a Snapshot of the database
+// is open. If Reset fails otherwise, the handle must not be used until a
+// later Reset succeeds.
+func (db *DBM) Reset(file string, flags int, mode os.FileMode) error {
+	if file == "" {
+		return ErrInvalidArgument
+	}
+	db.cow.mu.Lock()
+	n := len(db.cow.snaps)
+	db.cow.mu.Unlock()
+	if n > 0 {
+		return fmt.Errorf("%w: %d snapshots open", ErrInvalidArgument, n)
+	}
+	if db.dirf != nil {
+		if err := db.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
+	}
+
+	opts := db.opts
+	opts.Flags = flags
+	opts.Mode = mode
+
+	// keep the buffers so that pooled handles do not reallocate them.
+	// they are dropped later if the block sizes of the new files differ.
+	pag, dirbuf := db.pag, db.dirbuf
+	if pag != nil {
+		clear(pag.buf)
+	}
+	clear(dirbuf)
+	*db = DBM{pag: pag, dirbuf: dirbuf}
+
+	return db.open(file, opts)
+}
+
+// Reopen closes the files of the database and opens them again with the
+// options it was opened with, so that a reader sharing the files with a
+// writer picks up the pages the writer split off since, as IsStale reports.
+// It is Reset with the same file, except that os.O_CREATE, os.O_EXCL and
+// os.O_TRUNC are dropped from the flags: the files must still exist, and
+// are not emptied. Reopen fails with ErrInvalidArgument on a database
+// opened by OpenFiles or OpenMem, which has no file name to reopen, and
+// while a Snapshot of the database is open.
+func (db *DBM) Reopen() error {
+	if db.file == "" {
+		return fmt.Errorf("%w: no file name to reopen", ErrInvalidArgument)
+	}
+	flags := db.opts.Flags &^ (os.O_CREATE | os.O_EXCL | os.O_TRUNC)
+	return db.Reset(db.file, flags, db.opts.Mode)
+}
+
+// Clear empties the database by truncating its files, keeping the format
+// header if it has one, and forgetting the blocks cached in memory. The
+// value store of Options.InternValues and the overflow file of
+// Options.OverflowThreshold are emptied too, and the write-ahead log of
+// Options.WAL is checkpointed, so nothing from before is replayed. Clear
+// fails with ErrInvalidArgument while a Snapshot of the database is open.
+func (db *DBM) Clear() error {
+	if db.rdonly {
+		return ErrDBMRDOnly
+	}
+
+	db.cow.mu.Lock()
+	defer db.cow.mu.Unlock()
+	if n := len(db.cow.snaps); n > 0 {
+		return fmt.Errorf("%w: %d snapshots open", ErrInvalidArgument, n)
+	}
+	if err := db.dirf.Truncate(0); err != nil {
+		return wrapIOErr("truncate", db.dirf.Name(), err)
+	}
+	if err := db.pagf.Truncate(db.pagBase); err != nil {
+		return wrapIOErr("truncate", db.pagf.Name(), err)
+	}
+	db.dirSync, db.pagSync = true, true
+	db.forget(0)
+
+	if db.blobs != nil {
+		if err := db.blobs.Clear(); err != nil {
+			return err
+		}
+	}
+	if db.ovf != nil {
+		if err := db.ovf.f.Truncate(0); err != nil {
+			return wrapIOErr("truncate", db.ovf.f.Name(), err)
+		}
+		db.ovf.end, db.ovf.free, db.ovf.written = 0, nil, true
+	}
+	if db.wal != nil {
+		return db.Sync()
+	}
+	return nil
+}
+
+// forget drops the blocks cached in memory and the iteration position, after
+// the files were replaced by a .dir file of dirSize bytes and its pages.
+func (db *DBM) forget(dirSize int64) {
+	db.maxbno = dirSize * BITSIZ
+	db.curbit = 0
+	db.hmask = 0
+	db.blkptr = 0
+	db.keyptr = 0
+	db.iter = nil
+	clear(db.gone)
+	db.pagbno = -1
+	db.dirty = false
+	if db.cache != nil {
+		db.cache.reset()
+	}
+	if db.pag != nil {
+		clear(db.pag.buf)
+	}
+	if db.dirbuf != nil {
+		clear(db.dirbuf)
+		db.dirbno = -1
+	}
+}
+
 // Fetch retrieves the value associated with the given key from the database.
 // It returns the value and an error if the key is invalid or if there is a problem accessing the page.
 func (db *DBM) Fetch(key Datum) (Datum, error) {
-	if bad(key) {
-		return Nullitem, ErrInvalidArgument
+	val, err := db.fetch(key)
+	if err != nil || val == nil {
+		return val, err
+	}
+	if db.blobs != nil {
+		return db.resolveBlob(val)
+	}
+	if db.ovf != nil {
+		return db.resolveOverflow(val)
+	}
+	return val, nil
+}
+
+// FetchInto copies the value of key into dst and returns its length,
+// reporting whether key was present. dst is never grown: if the value is
+// longer than dst, nothing is copied and the returned length is the size
+// dst needs, so the caller can check n > len(dst), resize and retry. With a
+// large enough dst, reads of plain values do not allocate.
+func (db *DBM) FetchInto(key Datum, dst []byte) (n int, found bool, err error) {
+	val, err := db.Fetch(key)
+	if err != nil || val == nil {
+		return 0, false, err
+	}
+	if val.Size() <= len(dst) {
+		copy(dst, val)
+	}
+	return val.Size(), true, nil
+}
+
+// FetchAll returns every value stored under key, in the order they were
+// stored, or nil if key is absent. Fetch only finds the first of them, but
+// a key stored more than once without StoreREPLACE, StoreSEEDUPS or
+// StoreINSERT keeps all its pairs, which all land in the same page. The
+// values are copies, not slices of the page buffer.
+func (db *DBM) FetchAll(key Datum) ([]Datum, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return nil, err
+	}
+	if err := db.getPage(db.exHash(key)); err != nil {
+		return nil, err
 	}
 
-	hash := exHash(key)
+	var vals []Datum
+	db.pag.forEachPair(func(k, v Datum) bool {
+		if bytes.Equal(k, key) {
+			vals = append(vals, bytes.Clone(v))
+		}
+		return true
+	})
+	for i, val := range vals {
+		var err error
+		switch {
+		case db.blobs != nil:
+			val, err = db.resolveBlob(val)
+		case db.ovf != nil:
+			val, err = db.resolveOverflow(val)
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// WarmupKeys fetches each of keys, typically the hot set recorded in an
+// access log, so that their pages are read from storage before the first
+// real request needs them. Only the pages holding keys are touched, unlike a
+// full scan. It stops at the first key that cannot be fetched.
+func (db *DBM) WarmupKeys(keys []Datum) error {
+	for _, key := range keys {
+		if _, err := db.Fetch(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exists reports whether key is present in the database. Unlike Fetch it
+// builds no value, so nothing aliasing the page buffer is handed out.
+// It returns ErrInvalidArgument for a nil key.
+func (db *DBM) Exists(key Datum) (bool, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return false, err
+	}
+
+	hash := db.exHash(key)
+	if err := db.getPage(hash); err != nil {
+		return false, err
+	}
+
+	return db.pag.DupPair(key), nil
+}
+
+// fetch returns the value stored in the page for key, as written.
+func (db *DBM) fetch(key Datum) (Datum, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return Nullitem, err
+	}
+
+	hash := db.exHash(key)
 	if err := db.getPage(hash); err != nil {
 		return Nullitem, err
 	}
@@ -258,26 +842,73 @@ func (db *DBM) Fetch(key Datum) (Datum, error) {
 // Delete removes the key-value pair associated with the given key from the database.
 // It returns a boolean indicating success or failure, and an error if the key is invalid,
 // the database is read-only, or there is a problem accessing the page.
-func (db *DBM) Delete(key Datum) (bool, error) {
-	if bad(key) {
-		return false, ErrInvalidArgument
+func (db *DBM) Delete(key Datum) (ok bool, err error) {
+	if db.opts.SyncOnWrite {
+		defer func() {
+			if err == nil {
+				err = db.syncWritten()
+			}
+			if err != nil {
+				ok = false
+			}
+		}()
+	}
+	if db.wal != nil {
+		if err := db.logWAL(batchOp{key: key, del: true}); err != nil {
+			return false, err
+		}
+	}
+	if db.blobs != nil {
+		return db.deleteInterned(key)
+	}
+	if db.ovf != nil {
+		return db.deleteOverflow(key)
+	}
+
+	ok, err = db.del(key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// update the page file
+	if err := db.flush(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// del removes the pair for key from its page in memory and marks the page
+// dirty. The page is written by the next flush.
+func (db *DBM) del(key Datum) (bool, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return false, err
 	}
 	if db.rdonly {
 		return false, ErrDBMRDOnly
 	}
 
-	hash := exHash(key)
+	hash := db.exHash(key)
 	if err := db.getPage(hash); err != nil {
 		return false, err
 	}
-	if !db.pag.DelPair(key) {
+	if !db.pag.DupPair(key) {
 		return false, nil
 	}
-
-	// update the page file
-	if err := seekWrite(db.pagf, offPag(db.pagbno), io.SeekStart, db.pag.buf[:]); err != nil {
+	if err := db.audit(AuditDelete, key, 0); err != nil {
 		return false, err
 	}
+	_ = db.pag.DelPair(key)
+	db.dirty = true
+
+	// the key may still be ahead of an iteration over its page.
+	if db.iter != nil && db.pagbno == db.blkptr {
+		if db.gone == nil {
+			db.gone = make(map[string]struct{})
+		}
+		db.gone[string(key)] = struct{}{}
+	}
 
 	return true, nil
 }
@@ -285,10 +916,50 @@ func (db *DBM) Delete(key Datum) (bool, error) {
 // Store inserts or updates a key-value pair in the database.
 // If the key already exists and StoreREPLACE is specified, the value is replaced.
 // If StoreSEEDUPS is specified, duplicates are not allowed.
+// If StoreINSERT is specified and the key already exists, nothing is stored and false is returned.
 // It returns a boolean indicating success and an error if the operation fails or if the database is read-only.
-func (db *DBM) Store(key, val Datum, flags StoreFlags) (bool, error) {
-	if bad(key) {
-		return false, ErrInvalidArgument
+func (db *DBM) Store(key, val Datum, flags StoreFlags) (ok bool, err error) {
+	if db.opts.SyncOnWrite {
+		defer func() {
+			if err == nil {
+				err = db.syncWritten()
+			}
+			if err != nil {
+				ok = false
+			}
+		}()
+	}
+	if db.wal != nil {
+		if err := db.logWAL(batchOp{key: key, val: val, flags: flags}); err != nil {
+			return false, err
+		}
+	}
+	if db.blobs != nil {
+		return db.storeInterned(key, val, flags)
+	}
+	if db.ovf != nil {
+		return db.storeOverflow(key, val, flags)
+	}
+
+	ok, err = db.store(key, val, flags)
+	if err != nil {
+		return false, err
+	}
+
+	// update the page file
+	if err := db.flush(); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// store inserts the pair into its page in memory, splitting the page if
+// needed, and marks the page dirty. The page is written by the next flush.
+func (db *DBM) store(key, val Datum, flags StoreFlags) (bool, error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return false, err
 	}
 
 	if db.rdonly {
@@ -297,23 +968,41 @@ func (db *DBM) Store(key, val Datum, flags StoreFlags) (bool, error) {
 
 	need := key.Size() + val.Size()
 
-	// is the pair too big (or too small) for this database ??
-	if need < 0 || need > PAIRMAX {
-		return false, ErrInvalidArgument
+	// is the pair too big for this database ??
+	if need > db.PairMax() {
+		return false, ErrValueTooBig
+	}
+	if db.checksums() {
+		// the checksum takes room in the page, but never so much that a
+		// pair of PairMax bytes does not fit in an empty page.
+		need += crcSize
 	}
 
-	hash := exHash(key)
+	hash := db.exHash(key)
 	if err := db.getPage(hash); err != nil {
 		return false, err
 	}
 
+	if flags == StoreSEEDUPS && db.pag.DupPair(key) {
+		// success
+		return true, nil
+	}
+	if flags == StoreINSERT && db.pag.DupPair(key) {
+		return false, nil
+	}
+
+	// an interned or overflowed value is audited by storeInterned or
+	// storeOverflow, with its length rather than that of its reference.
+	if db.blobs == nil && db.ovf == nil {
+		if err := db.audit(AuditStore, key, val.Size()); err != nil {
+			return false, err
+		}
+	}
+
 	// if we need to replace, delete the key/data pair
 	// first. If it is not there, ignore.
 	if flags == StoreREPLACE {
 		_ = db.pag.DelPair(key)
-	} else if flags == StoreSEEDUPS && db.pag.DupPair(key) {
-		// success
-		return true, nil
 	}
 
 	// if we do not have enough room, we have to split.
@@ -323,16 +1012,258 @@ func (db *DBM) Store(key, val Datum, flags StoreFlags) (bool, error) {
 		}
 	}
 
-	// we have enough room or split is successful. insert the key,
-	// and update the page file.
+	// we have enough room or split is successful. insert the key.
 	db.pag.PutPair(key, val)
+	db.dirty = true
+	delete(db.gone, string(key))
+
+	// success
+	return true, nil
+}
 
-	if err := seekWrite(db.pagf, offPag(db.pagbno), io.SeekStart, db.pag.buf[:]); err != nil {
+// flush writes the current page if it has been modified since it was read.
+func (db *DBM) flush() error {
+	if !db.dirty {
+		return nil
+	}
+	if err := db.writePage(db.pagbno, db.pag); err != nil {
+		return err
+	}
+	db.dirty = false
+	return nil
+}
+
+// writePage writes p as page pagb of the .pag file. With
+// Options.VerifyWrites it then reads the page back and compares it.
+func (db *DBM) writePage(pagb int64, p *Page) error {
+	if db.checksums() {
+		p.seal()
+	}
+	if db.opts.ZeroFill {
+		if err := zeroFill(db.pagf, db.offPag(pagb), len(p.buf)); err != nil {
+			return err
+		}
+	}
+	err := db.cowWrite(false, db.offPag(pagb), len(p.buf), func() error {
+		return writeAt(db.pagf, db.offPag(pagb), p.buf)
+	})
+	if err != nil {
+		return err
+	}
+	db.pagSync = true
+	if db.cache != nil {
+		db.cache.put(pagb, p)
+	}
+	if !db.opts.VerifyWrites {
+		return nil
+	}
+	check := make([]byte, len(p.buf))
+	if err := readAt(db.pagf, db.offPag(pagb), check); err != nil {
+		return err
+	}
+	if !bytes.Equal(check, p.buf) {
+		return fmt.Errorf("%w: page %d", ErrWriteVerifyFailed, pagb)
+	}
+	return nil
+}
+
+// StoreIfChanged stores val under key, replacing any existing value, unless
+// the key already holds exactly val. It reports whether it wrote anything,
+// so idempotent upserts do not cause page writes. The comparison and the
+// write share a single page lookup.
+func (db *DBM) StoreIfChanged(key, val Datum) (bool, error) {
+	if db.rdonly {
+		return false, ErrDBMRDOnly
+	}
+	fetch := db.fetch
+	if db.blobs != nil || db.ovf != nil {
+		fetch = db.Fetch
+	}
+	cur, err := fetch(key)
+	if err != nil {
 		return false, err
 	}
+	if cur != nil && bytes.Equal(cur, val) {
+		return false, nil
+	}
+	return db.Store(key, val, StoreREPLACE)
+}
 
-	// success
-	return true, nil
+// StoreAndGet stores val under key as Store does and also returns a copy of
+// the value key held before, reporting whether it held one. Under
+// StoreREPLACE the old value is the one replaced; under StoreSEEDUPS and
+// StoreINSERT it is the value left in place. The old value is read from the
+// page Store then updates, so the page is read only once.
+func (db *DBM) StoreAndGet(key, val Datum, flags StoreFlags) (old Datum, existed bool, err error) {
+	if db.rdonly {
+		return nil, false, ErrDBMRDOnly
+	}
+	old, err = db.Fetch(key)
+	if err != nil {
+		return nil, false, err
+	}
+	// the page buffer is overwritten by the store.
+	old = cloneDatum(old)
+	if _, err := db.Store(key, val, flags); err != nil {
+		return nil, false, err
+	}
+	return old, old != nil, nil
+}
+
+// DeleteAndGet removes key as Delete does and returns a copy of the value
+// it held, reporting whether it was present. The value is read from the
+// page Delete then updates, so the page is read only once.
+func (db *DBM) DeleteAndGet(key Datum) (val Datum, ok bool, err error) {
+	if db.rdonly {
+		return nil, false, ErrDBMRDOnly
+	}
+	val, err = db.Fetch(key)
+	if err != nil || val == nil {
+		return nil, false, err
+	}
+	// the page buffer is overwritten by the delete.
+	val = cloneDatum(val)
+	if ok, err = db.Delete(key); err != nil || !ok {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// DeleteAll removes every pair stored under key, the duplicates FetchAll
+// returns, and reports how many there were. Delete only removes the first
+// of them. The pairs all live in one page, which is written once.
+func (db *DBM) DeleteAll(key Datum) (n int, err error) {
+	if db.opts.SyncOnWrite {
+		defer func() {
+			if err == nil {
+				err = db.syncWritten()
+			}
+		}()
+	}
+	norm := db.normKey(key)
+	if err := db.checkKey(norm); err != nil {
+		return 0, err
+	}
+	if db.rdonly {
+		return 0, ErrDBMRDOnly
+	}
+	if err := db.getPage(db.exHash(norm)); err != nil {
+		return 0, err
+	}
+	// the stored values of interned and overflowed pairs are references,
+	// released once the pairs are gone.
+	var refs []Datum
+	db.pag.forEachPair(func(k, v Datum) bool {
+		if bytes.Equal(k, norm) {
+			refs = append(refs, cloneDatum(v))
+		}
+		return true
+	})
+	if db.wal != nil {
+		for range refs {
+			if err := db.logWAL(batchOp{key: key, del: true}); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for {
+		ok, err := db.del(key)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := db.flush(); err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		switch {
+		case db.blobs != nil:
+			err = db.adjustBlob(ref, nil, -1)
+		case db.ovf != nil:
+			err = db.releaseOverflow(ref)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Increment adds delta to the counter stored under key and returns the new
+// total. A counter is stored as a little-endian int64, and an absent key
+// counts from 0; a key holding a value of any other size than 8 bytes fails
+// with ErrInvalidArgument and is left alone. The counter is read from and
+// written back to the same page, so the page is read and written once.
+// Like the other methods, Increment is not safe for concurrent use; see
+// SafeDBM.
+func (db *DBM) Increment(key Datum, delta int64) (int64, error) {
+	if db.rdonly {
+		return 0, ErrDBMRDOnly
+	}
+	cur, err := db.Fetch(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if cur != nil {
+		if cur.Size() != 8 {
+			return 0, ErrInvalidArgument
+		}
+		n = int64(binary.LittleEndian.Uint64(cur))
+	}
+	n += delta
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(n))
+	if _, err := db.Store(key, buf[:], StoreREPLACE); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// AssertFetchable checks that key round-trips through the database: it
+// stores a sentinel value under key, fetches it back and compares it,
+// failing with ErrNotFetchable if the value read differs. The value key held
+// before, if any, is stored again afterwards, and an absent key is deleted,
+// so the database is left as it was. It is meant for debugging hashes, page
+// layouts and unusual keys, not for use on a live database.
+func (db *DBM) AssertFetchable(key Datum) error {
+	if db.rdonly {
+		return ErrDBMRDOnly
+	}
+	prev, err := db.Fetch(key)
+	if err != nil {
+		return err
+	}
+	prev = cloneDatum(prev)
+
+	sentinel := Datum("sdbm: AssertFetchable sentinel")
+	if bytes.Equal(prev, sentinel) {
+		sentinel = append(sentinel, '!')
+	}
+	if _, err := db.Store(key, sentinel, StoreREPLACE); err != nil {
+		return err
+	}
+	got, err := db.Fetch(key)
+	if err == nil && !bytes.Equal(got, sentinel) {
+		err = fmt.Errorf("%w: got %q, want %q", ErrNotFetchable, got, sentinel)
+	}
+
+	// restore the prior state whether or not the round trip worked.
+	if prev != nil {
+		_, rerr := db.Store(key, prev, StoreREPLACE)
+		return errors.Join(err, rerr)
+	}
+	_, rerr := db.Delete(key)
+	return errors.Join(err, rerr)
 }
 
 // makeRoom - make room by splitting the overfull page
@@ -340,16 +1271,21 @@ func (db *DBM) Store(key, val Datum, flags StoreFlags) (bool, error) {
 // giving up.
 func (db *DBM) makeRoom(hash int64, need int) error {
 	var newp int64
-	var twin [PBLKSIZ]byte
-	pag := db.pag.buf[:]
-	newPag := &Page{
-		buf: twin,
-	}
+	pag := db.pag.buf
+	newPag := db.newPage()
 	smax := SPLTMAX
 
 	for smax--; smax > 0; smax-- {
+		// no hash bits are left to tell the pairs apart.
+		if db.hmask == mask(maxDepth) {
+			break
+		}
+
 		// split the current page
-		db.pag.SplPage(newPag, db.hmask+1)
+		db.pag.splPage(newPag, db.hmask+1, db.exHash)
+		if db.opts.Logger != nil {
+			db.opts.Logger.Printf("split page %d: %d/%d pairs", db.pagbno, db.pag.getN()/2, newPag.getN()/2)
+		}
 
 		//  address of the new page
 		newp = (hash & db.hmask) | (db.hmask + 1)
@@ -361,13 +1297,13 @@ func (db *DBM) makeRoom(hash int64, need int) error {
 		// still looking at the page of interest. current page is not updated
 		// here, as dbm_store will do so, after it inserts the incoming pair.
 		if hash&(db.hmask+1) != 0 {
-			if err := seekWrite(db.pagf, offPag(db.pagbno), io.SeekStart, db.pag.buf[:]); err != nil {
+			if err := db.writePage(db.pagbno, db.pag); err != nil {
 				return err
 			}
 			db.pagbno = newp
-			copy(pag, newPag.buf[:])
+			copy(pag, newPag.buf)
 		} else {
-			if err := seekWrite(db.pagf, offPag(newp), io.SeekStart, newPag.buf[:]); err != nil {
+			if err := db.writePage(newp, newPag); err != nil {
 				return err
 			}
 		}
@@ -392,47 +1328,68 @@ func (db *DBM) makeRoom(hash int64, need int) error {
 		}
 		db.hmask |= db.hmask + 1
 
-		if err := seekWrite(db.pagf, offPag(db.pagbno), io.SeekStart, db.pag.buf[:]); err != nil {
+		if err := db.writePage(db.pagbno, db.pag); err != nil {
 			return err
 		}
 	}
 
 	// if we are here, this is real bad news. After SPLTMAX splits,
 	// we still cannot fit the key. say goodnight.
-	if debug {
-		fmt.Println("sdbm: cannot insert after SPLTMAX attempts.")
+	if db.opts.Logger != nil {
+		db.opts.Logger.Printf("cannot insert after SPLTMAX attempts")
 	}
 
-	return nil
+	return ErrSplitOverflow
 }
 
 // FirstKey retrieves the first key in the database.
-// This function initializes the reading of the first page (page 0) and sets the current pointers (pagbno, blkptr, keyptr) to 0.
+// This function initializes the reading of the first page (page 0) and sets the current pointers (blkptr, keyptr) to 0.
 // If an error occurs while reading the page, it returns an error.
-// Note: These routines may fail if deletions are not accounted for, due to an ndbm bug.
+// The returned key is a copy unless Options.ZeroCopyIteration is set.
+// Keys may be deleted while iterating, the one just returned included:
+// every key still present is returned exactly once.
 func (db *DBM) FirstKey() (Datum, error) {
-	// start at page 0
-	if err := seekRead(db.pagf, offPag(0), io.SeekStart, db.pag.buf[:]); err != nil {
-		return Nullitem, err
-	}
-	db.pagbno = 0
 	db.blkptr = 0
 	db.keyptr = 0
-
+	if _, err := db.readIter(); err != nil {
+		return Nullitem, err
+	}
 	return db.getNext()
 }
 
 // NextKey retrieves the next key in the database after FirstKey or after the last key retrieved by a previous call to NextKey.
 // If the current page has more keys, it returns the next one; otherwise, it moves to the next page to continue searching for keys.
-// Note: These routines may fail if deletions are not accounted for, due to an ndbm bug.
+// The returned key is a copy unless Options.ZeroCopyIteration is set.
 func (db *DBM) NextKey() (Datum, error) {
 	return db.getNext()
 }
 
-// all important binary trie traversal.
-func (db *DBM) getPage(hash int64) error {
-	var dbit, hbit int64
-	for dbit < db.maxbno && db.getDBit(dbit) {
+// lookup walks the directory trie for hash. It returns the bit number of
+// the leaf it reached and the hash mask selecting the page at that depth.
+func (db *DBM) lookup(hash int64) (dbit, hmask int64) {
+	dbit, hbit, _ := db.descend(hash)
+	return dbit, mask(hbit)
+}
+
+// descend is the trie walk behind lookup. Besides the leaf bit number it
+// returns the depth reached, in hash bits, and the number of directory bits
+// consulted on the way.
+func (db *DBM) descend(hash int64) (dbit, hbit int64, probes int) {
+	dbit, hbit, probes = walkTrie(hash, db.maxbno, db.getDBit)
+	if db.opts.Logger != nil {
+		db.opts.Logger.Printf("dbit: %d...", dbit)
+	}
+	return dbit, hbit, probes
+}
+
+// walkTrie walks the directory trie for hash, reading the bits below maxbno
+// with getDBit. It stops at maxDepth whatever the directory says.
+func walkTrie(hash, maxbno int64, getDBit func(dbit int64) bool) (dbit, hbit int64, probes int) {
+	for dbit < maxbno && hbit < maxDepth {
+		probes++
+		if !getDBit(dbit) {
+			break
+		}
 		if hash&(1<<hbit) != 0 {
 			dbit = 2*dbit + 2
 		} else {
@@ -440,115 +1397,258 @@ func (db *DBM) getPage(hash int64) error {
 		}
 		hbit++
 	}
-	if debug {
-		fmt.Printf("dbit: %d...\n", dbit)
+	return dbit, hbit, probes
+}
+
+// LookupCost reports the cost of locating the page of key: the number of
+// directory bits consulted while walking the trie, and the depth of the
+// trie, in hash bits, at the page reached. Deep paths point at clustering
+// of the hash values of keys.
+func (db *DBM) LookupCost(key Datum) (dirReads int, trieDepth int, err error) {
+	key = db.normKey(key)
+	if err := db.checkKey(key); err != nil {
+		return 0, 0, err
 	}
+	_, hbit, probes := db.descend(db.exHash(key))
+	return probes, int(hbit), nil
+}
 
-	db.curbit = dbit
-	db.hmask = masks[hbit]
+// DirBits returns the bits of the directory, bit n telling whether the
+// page at node n of the trie was split. The nodes are numbered level by
+// level from the root, the children of node n being 2n+1 and 2n+2. It is a
+// debugging aid: the directory of a deep trie is large and mostly clear.
+func (db *DBM) DirBits() ([]bool, error) {
+	bits := make([]bool, db.maxbno)
+	blk := int64(db.format.DirBlockSize)
+	for dirb := int64(0); dirb*blk*BITSIZ < db.maxbno; dirb++ {
+		if err := db.readDirBlock(dirb); err != nil {
+			return nil, err
+		}
+		for i, c := range db.dirbuf {
+			for j := range int64(BITSIZ) {
+				if n := (dirb*blk+int64(i))*BITSIZ + j; n < db.maxbno {
+					bits[n] = c&(1<<j) != 0
+				}
+			}
+		}
+	}
+	return bits, nil
+}
+
+// pageOf returns the number of the page that holds hash.
+func (db *DBM) pageOf(hash int64) int64 {
+	_, hmask := db.lookup(hash)
+	return hash & hmask
+}
+
+// all important binary trie traversal.
+func (db *DBM) getPage(hash int64) error {
+	db.acquireBuffers()
+	if db.opts.RefreshOnChange {
+		if err := db.refresh(); err != nil {
+			return err
+		}
+	}
+	db.curbit, db.hmask = db.lookup(hash)
 
 	pagb := hash & db.hmask
 
 	// see if the block we need is already in memory.
 	// note: this lookaside cache has about 10% hit rate.
 	if pagb != db.pagbno {
+		// write out the page we are leaving if it was modified.
+		if err := db.flush(); err != nil {
+			return err
+		}
+		if db.cache != nil && db.cache.get(pagb, db.pag) {
+			db.pagbno = pagb
+			return nil
+		}
 		// note: here, we assume a "hole" is read as 0s.
-		// if not, must zero pag first.
-		if err := seekRead(db.pagf, offPag(pagb), io.SeekStart, db.pag.buf[:]); err != nil {
+		// if not, Options.ZeroFill must be set so that no hole is left.
+		if err := readAt(db.pagf, db.offPag(pagb), db.pag.buf); err != nil {
 			return err
 		}
-		if !db.pag.ChkPage() {
-			return ErrInvalidPage
+		if err := db.checkPage(pagb, db.pag); err != nil {
+			return err
 		}
 		db.pagbno = pagb
+		if db.cache != nil {
+			db.cache.put(pagb, db.pag)
+		}
 
-		if debug {
-			fmt.Printf("pag read: %d\n", pagb)
+		if db.opts.Logger != nil {
+			db.opts.Logger.Printf("pag read: %d", pagb)
 		}
 	}
 
 	return nil
 }
 
-func (db *DBM) getDBit(dbit int64) bool {
-	c := dbit / BITSIZ
-	dirb := c / DBLKSIZ
-
-	if dirb != db.dirbno {
-		if err := seekRead(db.dirf, offDir(dirb), io.SeekStart, db.dirbuf[:]); err != nil {
-			return false
+// refresh drops the blocks cached in memory if the .dir or .pag file has
+// changed size since it was last called, for Options.RefreshOnChange. The
+// directory is then read again up to its new size. Unlike forget, it keeps
+// the iteration position.
+func (db *DBM) refresh() error {
+	dirSize, pagSize, err := db.fileSizes()
+	if err != nil || dirSize == db.dirSeen && pagSize == db.pagSeen {
+		return err
+	}
+	// a page left to write may grow the file itself.
+	if db.dirty {
+		if err := db.flush(); err != nil {
+			return err
 		}
-		db.dirbno = dirb
-
-		if debug {
-			fmt.Printf("dir read: %d\n", dirb)
+		if dirSize, pagSize, err = db.fileSizes(); err != nil {
+			return err
 		}
 	}
+	db.dirSeen, db.pagSeen = dirSize, pagSize
+	db.maxbno = dirSize * BITSIZ
+	db.pagbno = -1
+	db.dirbno = -1
+	if db.cache != nil {
+		db.cache.reset()
+	}
+	return nil
+}
+
+// fileSizes returns the sizes of the .dir and .pag files.
+func (db *DBM) fileSizes() (dirSize, pagSize int64, err error) {
+	if dirSize, err = db.dirf.Size(); err != nil {
+		return 0, 0, wrapIOErr("stat", db.dirf.Name(), err)
+	}
+	if pagSize, err = db.pagf.Size(); err != nil {
+		return 0, 0, wrapIOErr("stat", db.pagf.Name(), err)
+	}
+	return dirSize, pagSize, nil
+}
 
-	return int(db.dirbuf[c%DBLKSIZ]&(1<<(dbit%BITSIZ))) != 0
+func (db *DBM) getDBit(dbit int64) bool {
+	c := dbit / BITSIZ
+	if err := db.readDirBlock(c / int64(db.format.DirBlockSize)); err != nil {
+		return false
+	}
+	return int(db.dirbuf[c%int64(len(db.dirbuf))]&(1<<(dbit%BITSIZ))) != 0
 }
 
 func (db *DBM) setDBit(dbit int64) error {
 	c := dbit / BITSIZ
-	dirb := c / DBLKSIZ
+	dirb := c / int64(db.format.DirBlockSize)
+	if err := db.readDirBlock(dirb); err != nil {
+		return err
+	}
 
-	if dirb != db.dirbno {
-		if err := seekRead(db.dirf, offDir(dirb), io.SeekStart, db.dirbuf[:]); err != nil {
-			return err
-		}
-		db.dirbno = dirb
+	db.dirbuf[c%int64(len(db.dirbuf))] |= 1 << (dbit % BITSIZ)
 
-		if debug {
-			fmt.Printf("dir read: %d\n", dirb)
-		}
+	// the block written may lie well past the end of the directory once
+	// the trie is deep, so maxbno grows to its end rather than by a block.
+	if end := (dirb + 1) * int64(len(db.dirbuf)) * BITSIZ; end > db.maxbno {
+		db.maxbno = end
 	}
 
-	db.dirbuf[c%DBLKSIZ] |= 1 << (dbit % BITSIZ)
+	return db.writeDirBlock(dirb)
+}
 
-	if dbit >= db.maxbno {
-		db.maxbno += DBLKSIZ * BITSIZ
+// clearDBit clears directory bit dbit, undoing the split it recorded.
+func (db *DBM) clearDBit(dbit int64) error {
+	c := dbit / BITSIZ
+	dirb := c / int64(db.format.DirBlockSize)
+	if err := db.readDirBlock(dirb); err != nil {
+		return err
 	}
 
-	if err := seekWrite(db.dirf, offDir(dirb), io.SeekStart, db.dirbuf[:]); err != nil {
+	db.dirbuf[c%int64(len(db.dirbuf))] &^= 1 << (dbit % BITSIZ)
+
+	return db.writeDirBlock(dirb)
+}
+
+// readDirBlock makes dirbuf hold directory block dirb.
+func (db *DBM) readDirBlock(dirb int64) error {
+	db.acquireBuffers()
+	if dirb == db.dirbno {
+		return nil
+	}
+	if err := readAt(db.dirf, db.offDir(dirb), db.dirbuf); err != nil {
 		return err
 	}
+	db.dirbno = dirb
+
+	if db.opts.Logger != nil {
+		db.opts.Logger.Printf("dir read: %d", dirb)
+	}
+	return nil
+}
+
+// writeDirBlock writes dirbuf as directory block dirb.
+func (db *DBM) writeDirBlock(dirb int64) error {
+	if db.opts.ZeroFill {
+		if err := zeroFill(db.dirf, db.offDir(dirb), len(db.dirbuf)); err != nil {
+			return err
+		}
+	}
+	err := db.cowWrite(true, db.offDir(dirb), len(db.dirbuf), func() error {
+		return writeAt(db.dirf, db.offDir(dirb), db.dirbuf)
+	})
+	if err != nil {
+		return err
+	}
+	db.dirSync = true
 
 	return nil
 }
 
 // getNext - get the next key in the page, and if done with
 // the page, try the next page in sequence.
+//
+// The keys are taken from a copy of the page made when the iteration
+// reached it, so that pairs deleted meanwhile do not shift the position.
+// The deleted keys not yet returned are skipped.
 func (db *DBM) getNext() (Datum, error) {
-	var key Datum
+	if db.iter == nil {
+		if _, err := db.readIter(); err != nil {
+			return Nullitem, err
+		}
+	}
 
 	for {
 		db.keyptr++
-		key = db.pag.GetNKey(db.keyptr)
+		key := db.iter.GetNKey(db.keyptr)
 		if key != nil {
-			return key, nil
+			if _, ok := db.gone[string(key)]; ok {
+				continue
+			}
+			if db.opts.ZeroCopyIteration {
+				return key, nil
+			}
+			return cloneDatum(key), nil
 		}
 
 		// we either run out, or there is nothing on this page...
-		// try the next one... If we lost our position on the
-		// file, we will have to seek.
+		// try the next one.
 		db.keyptr = 0
-		if db.pagbno != db.blkptr {
-			db.blkptr++
-			if _, err := db.pagf.Seek(offPag(db.blkptr), io.SeekStart); err != nil {
-				return Nullitem, wrapIOErr("seek", db.pagf.Name(), err)
-			}
-		}
-
-		db.pagbno = db.blkptr
-		if _, err := db.pagf.Read(db.pag.buf[:]); err != nil {
-			if errors.Is(err, io.EOF) {
-				return Nullitem, nil
-			}
-			return Nullitem, wrapIOErr("read", db.pagf.Name(), err)
+		db.blkptr++
+		if ok, err := db.readIter(); err != nil || !ok {
+			return Nullitem, err
 		}
+	}
+}
 
-		if !db.pag.ChkPage() {
-			return Nullitem, ErrInvalidPage
-		}
+// readIter reads page blkptr into the iteration buffer. It reports false
+// for a page past the end of the file, which reads as an empty page.
+func (db *DBM) readIter() (bool, error) {
+	if db.iter == nil || len(db.iter.buf) != db.format.PageSize || db.iter.be != db.bigEndian() {
+		db.iter = db.newPage()
+	}
+	clear(db.gone)
+	// the page in memory may be modified and not written yet.
+	if err := db.flush(); err != nil {
+		return false, err
+	}
+	clear(db.iter.buf)
+	ok, err := db.readPage(db.blkptr, db.iter)
+	if err != nil || !ok {
+		return false, err
 	}
+	return true, db.checkPage(db.blkptr, db.iter)
 }
@@ -3,10 +3,15 @@ package sdbm_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/vvatanabe/go-sdbm"
@@ -19,7 +24,7 @@ type Pair struct {
 	Val sdbm.Datum
 }
 
-func setup(t *testing.T, initialData ...Pair) (string, *sdbm.DBM) {
+func setup(t testing.TB, initialData ...Pair) (string, *sdbm.DBM) {
 	t.Helper()
 	dir := t.TempDir()
 	path := filepath.Join(dir, DBMFile)
@@ -39,7 +44,7 @@ func setup(t *testing.T, initialData ...Pair) (string, *sdbm.DBM) {
 	return dir, db
 }
 
-func teardown(t *testing.T, dbm *sdbm.DBM) {
+func teardown(t testing.TB, dbm *sdbm.DBM) {
 	t.Helper()
 	err := dbm.Close()
 	if err != nil {
@@ -85,6 +90,68 @@ func TestDBM_IOError(t *testing.T) {
 	}
 }
 
+func TestOpen_IOError(t *testing.T) {
+	tests := []struct {
+		name    string
+		ext     string
+		corrupt func(t *testing.T, path string)
+	}{
+		{
+			name: "unreadable pag file",
+			ext:  sdbm.PAGFEXT,
+			corrupt: func(t *testing.T, path string) {
+				if os.Geteuid() == 0 {
+					t.Skip("permissions are not enforced for root")
+				}
+				if err := os.Chmod(path, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "pag file is a directory",
+			ext:  sdbm.PAGFEXT,
+			corrupt: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Mkdir(path, 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "dir file is a directory",
+			ext:  sdbm.DIRFEXT,
+			corrupt: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Mkdir(path, 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t)
+			teardown(t, dbm)
+			path := filepath.Join(dir, DBMFile)
+			tt.corrupt(t, path+tt.ext)
+
+			_, err := sdbm.Open(path, os.O_RDWR, 0644)
+			var ioerr *sdbm.IOError
+			if !errors.As(err, &ioerr) {
+				t.Fatalf("Open() error = %v, want an IOError", err)
+			}
+			if ioerr.Op != "open" || ioerr.Path != path+tt.ext {
+				t.Errorf("Open() error got = %v, want open of %s", err, path+tt.ext)
+			}
+		})
+	}
+}
+
 func TestOpen_EmptyDBM(t *testing.T) {
 	_, dbm := setup(t)
 	defer teardown(t, dbm)
@@ -147,6 +214,100 @@ func TestOpen_InvalidArgument(t *testing.T) {
 	}
 }
 
+func TestMustOpen(t *testing.T) {
+	dbm := sdbm.MustOpen(filepath.Join(t.TempDir(), DBMFile), os.O_RDWR|os.O_CREATE, 0644)
+	teardown(t, dbm)
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, sdbm.ErrInvalidArgument) {
+			t.Errorf("MustOpen() panic = %v, want an error wrapping %v", err, sdbm.ErrInvalidArgument)
+		}
+	}()
+	sdbm.MustOpen("", 0, 0)
+	t.Error("MustOpen() want panic")
+}
+
+func TestDBM_KeyTooLong(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	key := bytes.Repeat([]byte("k"), 70*1024)
+
+	got, err := dbm.Fetch(key)
+	if !errors.Is(err, sdbm.ErrKeyTooLong) {
+		t.Errorf("Fetch() error = %v, wantErr %v", err, sdbm.ErrKeyTooLong)
+	}
+	if !reflect.DeepEqual(got, sdbm.Nullitem) {
+		t.Errorf("Fetch() got = %v, want %v", got, sdbm.Nullitem)
+	}
+	if _, err := dbm.Delete(key); !errors.Is(err, sdbm.ErrKeyTooLong) {
+		t.Errorf("Delete() error = %v, wantErr %v", err, sdbm.ErrKeyTooLong)
+	}
+	if _, err := dbm.Store(key, sdbm.Datum("val"), 0); !errors.Is(err, sdbm.ErrKeyTooLong) {
+		t.Errorf("Store() error = %v, wantErr %v", err, sdbm.ErrKeyTooLong)
+	}
+}
+
+func TestDBM_Reset(t *testing.T) {
+	_, dbm := setup(t, generatePairs("a", "x", 10)...)
+	defer teardown(t, dbm)
+	dir2, dbm2 := setup(t, generatePairs("b", "y", 10)...)
+	teardown(t, dbm2)
+
+	// warm the page cache with the first database.
+	if got, err := dbm.Fetch(sdbm.Datum("a1")); err != nil || got.String() != "x1" {
+		t.Fatalf("Fetch() got = %v, %v, want x1", got, err)
+	}
+
+	if err := dbm.Reset(filepath.Join(dir2, DBMFile), os.O_RDWR, 0644); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	got, err := dbm.Fetch(sdbm.Datum("a1"))
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, sdbm.Nullitem) {
+		t.Errorf("Fetch() got = %v, want %v", got, sdbm.Nullitem)
+	}
+	got, err = dbm.Fetch(sdbm.Datum("b1"))
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.String() != "y1" {
+		t.Errorf("Fetch() got = %v, want %v", got, "y1")
+	}
+	key, err := dbm.FirstKey()
+	if err != nil {
+		t.Fatalf("FirstKey() error = %v", err)
+	}
+	if key.String() != "b1" {
+		t.Errorf("FirstKey() got = %v, want %v", key, "b1")
+	}
+
+	if err := dbm.Reset("", os.O_RDWR, 0644); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Reset() error = %v, wantErr %v", err, sdbm.ErrInvalidArgument)
+	}
+
+	snap, err := dbm.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := dbm.Reset(filepath.Join(dir2, DBMFile), os.O_RDWR, 0644); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Reset() with a snapshot open error = %v, wantErr %v", err, sdbm.ErrInvalidArgument)
+	}
+	// the handle is left untouched.
+	if got, err := dbm.Fetch(sdbm.Datum("b1")); err != nil || got.String() != "y1" {
+		t.Errorf("Fetch() after a refused Reset() got = %v, %v, want y1", got, err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("Snapshot.Close() error = %v", err)
+	}
+	if err := dbm.Reset(filepath.Join(dir2, DBMFile), os.O_RDWR, 0644); err != nil {
+		t.Errorf("Reset() after Snapshot.Close() error = %v", err)
+	}
+}
+
 func TestDBM_Fetch(t *testing.T) {
 	_, dbm := setup(t, generatePairs("key", "val", 10)...)
 	defer teardown(t, dbm)
@@ -225,10 +386,11 @@ func TestDBM_Store(t *testing.T) {
 		flags sdbm.StoreFlags
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    bool
-		wantErr bool
+		name      string
+		args      args
+		want      bool
+		wantErr   bool
+		wantErrIs error
 	}{
 		{
 			name: "key is nil",
@@ -237,8 +399,20 @@ func TestDBM_Store(t *testing.T) {
 				val:   nil,
 				flags: 0,
 			},
-			want:    false,
-			wantErr: true,
+			want:      false,
+			wantErr:   true,
+			wantErrIs: sdbm.ErrInvalidArgument,
+		},
+		{
+			name: "key is too big",
+			args: args{
+				key:   bytes.Repeat([]byte("a"), sdbm.PAIRMAX+1),
+				val:   sdbm.Datum("v"),
+				flags: 0,
+			},
+			want:      false,
+			wantErr:   true,
+			wantErrIs: sdbm.ErrKeyTooBig,
 		},
 		{
 			name: "pair is too big",
@@ -247,8 +421,9 @@ func TestDBM_Store(t *testing.T) {
 				val:   sdbm.Datum("v"),
 				flags: 0,
 			},
-			want:    false,
-			wantErr: true,
+			want:      false,
+			wantErr:   true,
+			wantErrIs: sdbm.ErrValueTooBig,
 		},
 		{
 			name: "key is duplicated and flags is 0",
@@ -290,6 +465,26 @@ func TestDBM_Store(t *testing.T) {
 			want:    true,
 			wantErr: false,
 		},
+		{
+			name: "key is duplicated and flags is INSERT",
+			args: args{
+				key:   sdbm.Datum("key4"),
+				val:   sdbm.Datum("replaced4"),
+				flags: sdbm.StoreINSERT,
+			},
+			want:    false,
+			wantErr: false,
+		},
+		{
+			name: "key is not duplicated and flags is INSERT",
+			args: args{
+				key:   sdbm.Datum("key12"),
+				val:   sdbm.Datum("val12"),
+				flags: sdbm.StoreINSERT,
+			},
+			want:    true,
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -298,6 +493,9 @@ func TestDBM_Store(t *testing.T) {
 				t.Errorf("Store() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Store() error = %v, want %v", err, tt.wantErrIs)
+			}
 			if got != tt.want {
 				t.Errorf("Store() got = %v, want %v", got, tt.want)
 			}
@@ -375,11 +573,11 @@ func TestDBM_Delete(t *testing.T) {
 
 func TestDBM_RDOnlyDBM(t *testing.T) {
 	dir, dbm := setup(t, generatePairs("key", "val", 10)...)
-	defer teardown(t, dbm)
+	teardown(t, dbm)
 
 	dbm2, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, os.FileMode(0644))
 	if err != nil {
-		t.Errorf("failed to open db: %v", err)
+		t.Fatalf("failed to open db: %v", err)
 	}
 	defer func() {
 		_ = dbm2.Close()
@@ -398,11 +596,11 @@ func TestDBM_RDOnlyDBM(t *testing.T) {
 
 func TestDBM_WRONLYDBM(t *testing.T) {
 	dir, dbm := setup(t, generatePairs("key", "val", 100)...)
-	defer teardown(t, dbm)
+	teardown(t, dbm)
 
 	dbm2, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_WRONLY, os.FileMode(0644))
 	if err != nil {
-		t.Errorf("failed to open db: %v", err)
+		t.Fatalf("failed to open db: %v", err)
 	}
 	defer func() {
 		_ = dbm2.Close()
@@ -420,6 +618,36 @@ func TestDBM_WRONLYDBM(t *testing.T) {
 	}
 }
 
+func TestDBM_FirstKey_EmptyFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "never written"},
+		// the page buffer still holds a page when the file is emptied.
+		{name: "emptied after use", pairs: generatePairs("key", "val", 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t, tt.pairs...)
+			defer teardown(t, dbm)
+			if err := os.Truncate(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), 0); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				key, err := dbm.FirstKey()
+				if err != nil {
+					t.Fatalf("FirstKey() error = %v", err)
+				}
+				if key != nil {
+					t.Errorf("FirstKey() got = %q, want nil", key)
+				}
+			}
+		})
+	}
+}
+
 func TestDBM_FirstKey(t *testing.T) {
 	pairs := generatePairs("key", "val", 10)
 	_, dbm := setup(t, pairs...)
@@ -453,6 +681,80 @@ func TestDBM_NextKey(t *testing.T) {
 	}
 }
 
+func TestDBM_NextKey_Deletions(t *testing.T) {
+	tests := []struct {
+		name string
+		// victim returns the key to delete once key is returned.
+		victim func(key string) string
+	}{
+		{name: "the key returned", victim: func(key string) string { return key }},
+		{name: "a key ahead or behind", victim: func(key string) string {
+			n, _ := strconv.Atoi(strings.TrimPrefix(key, "key"))
+			return "key" + strconv.Itoa(1001-n)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs := generatePairs("key", "val", 1000)
+			_, dbm := setup(t, pairs...)
+			defer teardown(t, dbm)
+
+			seen := make(map[string]int)
+			deleted := make(map[string]bool)
+			for key, err := dbm.FirstKey(); key != nil || err != nil; key, err = dbm.NextKey() {
+				if err != nil {
+					t.Fatalf("NextKey() error = %v", err)
+				}
+				if deleted[key.String()] {
+					t.Errorf("NextKey() returned %s after it was deleted", key)
+				}
+				seen[key.String()]++
+				victim := tt.victim(key.String())
+				if deleted[victim] {
+					continue
+				}
+				if ok, err := dbm.Delete(sdbm.Datum(victim)); err != nil || !ok {
+					t.Fatalf("Delete(%s) got = %v, %v, want true", victim, ok, err)
+				}
+				deleted[victim] = true
+			}
+			for _, p := range pairs {
+				n := seen[p.Key.String()]
+				if n > 1 || n == 0 && !deleted[p.Key.String()] {
+					t.Errorf("NextKey() returned %s %d times", p.Key, n)
+				}
+			}
+		})
+	}
+}
+
+func TestDBM_NextKey_RetainedKeys(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	var keys []sdbm.Datum
+	key, err := dbm.FirstKey()
+	for ; key != nil && err == nil; key, err = dbm.NextKey() {
+		keys = append(keys, key)
+	}
+	if err != nil {
+		t.Fatalf("NextKey() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		seen[key.String()] = true
+	}
+	if len(seen) != len(pairs) {
+		t.Errorf("distinct retained keys got = %d, want %d", len(seen), len(pairs))
+	}
+	for _, pair := range pairs {
+		if !seen[pair.Key.String()] {
+			t.Errorf("retained keys missing %s", pair.Key)
+		}
+	}
+}
+
 func TestDBM_ManyPairs_Fetch(t *testing.T) {
 	size := 100000
 	pairs := generatePairs("key", "val", size)
@@ -499,3 +801,1272 @@ func TestDBM_ManyPairs_Delete(t *testing.T) {
 		}
 	}
 }
+
+func TestDBM_KeyNormalizer(t *testing.T) {
+	dbm, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), sdbm.Options{
+		Flags: os.O_RDWR | os.O_CREATE,
+		Mode:  0644,
+		KeyNormalizer: func(key sdbm.Datum) sdbm.Datum {
+			return bytes.ToLower(key)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+
+	if _, err := dbm.Store(sdbm.Datum("Alice"), sdbm.Datum("first"), sdbm.StoreSEEDUPS); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	ok, err := dbm.Store(sdbm.Datum("alice"), sdbm.Datum("second"), sdbm.StoreSEEDUPS)
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if !ok {
+		t.Errorf("Store() got = %v, want %v", ok, true)
+	}
+
+	for _, key := range []string{"alice", "ALICE", "Alice"} {
+		got, err := dbm.Fetch(sdbm.Datum(key))
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if got.String() != "first" {
+			t.Errorf("Fetch(%s) got = %v, want %v", key, got, "first")
+		}
+	}
+
+	n := 0
+	for key, err := dbm.FirstKey(); key != nil; key, err = dbm.NextKey() {
+		if err != nil {
+			t.Fatalf("NextKey() error = %v", err)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("stored keys got = %d, want %d", n, 1)
+	}
+}
+
+func TestDBM_VerifyWrites(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		dbm, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), sdbm.Options{
+			Flags:        os.O_RDWR | os.O_CREATE,
+			Mode:         0644,
+			VerifyWrites: verify,
+		})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		if _, err := dbm.Store(sdbm.Datum("key1"), sdbm.Datum("val1"), 0); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+
+		sdbm.CorruptPagReads(dbm)
+		_, err = dbm.Store(sdbm.Datum("key2"), sdbm.Datum("val2"), 0)
+		if verify && !errors.Is(err, sdbm.ErrWriteVerifyFailed) {
+			t.Errorf("Store() error = %v, wantErr %v", err, sdbm.ErrWriteVerifyFailed)
+		}
+		if !verify && err != nil {
+			t.Errorf("Store() error = %v", err)
+		}
+		teardown(t, dbm)
+	}
+}
+
+func TestDBM_EmptyKey(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	empty := sdbm.Datum("")
+	if ok, err := dbm.Store(empty, sdbm.Datum("empty"), sdbm.StoreREPLACE); err != nil || !ok {
+		t.Fatalf("Store() got = %v, %v, want true", ok, err)
+	}
+	got, err := dbm.Fetch(empty)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.String() != "empty" {
+		t.Errorf("Fetch() got = %v, want %v", got, "empty")
+	}
+
+	found := false
+	for key, err := dbm.FirstKey(); key != nil; key, err = dbm.NextKey() {
+		if err != nil {
+			t.Fatalf("NextKey() error = %v", err)
+		}
+		if key.Size() == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("NextKey() did not return the empty key")
+	}
+
+	if ok, err := dbm.Delete(empty); err != nil || !ok {
+		t.Fatalf("Delete() got = %v, %v, want true", ok, err)
+	}
+	got, err = dbm.Fetch(empty)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, sdbm.Nullitem) {
+		t.Errorf("Fetch() got = %v, want %v", got, sdbm.Nullitem)
+	}
+	if got, err := dbm.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+		t.Errorf("Fetch() got = %v, %v, want %v", got, err, "val1")
+	}
+}
+
+func TestDBM_LookupCost(t *testing.T) {
+	_, small := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, small)
+	_, large := setup(t, generatePairs("key", "val", 10000)...)
+	defer teardown(t, large)
+
+	key := sdbm.Datum("key1")
+	smallReads, smallDepth, err := small.LookupCost(key)
+	if err != nil {
+		t.Fatalf("LookupCost() error = %v", err)
+	}
+	largeReads, largeDepth, err := large.LookupCost(key)
+	if err != nil {
+		t.Fatalf("LookupCost() error = %v", err)
+	}
+	if smallDepth != 0 {
+		t.Errorf("LookupCost() depth of an unsplit db got = %d, want 0", smallDepth)
+	}
+	if largeDepth <= smallDepth || largeReads <= smallReads {
+		t.Errorf("LookupCost() got = %d reads depth %d, want more than %d reads depth %d",
+			largeReads, largeDepth, smallReads, smallDepth)
+	}
+	if largeReads != largeDepth+1 {
+		t.Errorf("LookupCost() reads got = %d, want depth+1 = %d", largeReads, largeDepth+1)
+	}
+
+	if _, _, err := large.LookupCost(nil); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("LookupCost() error = %v, wantErr %v", err, sdbm.ErrInvalidArgument)
+	}
+}
+
+// sparseFile is a File that keeps only the blocks written to it, so that
+// pages and directory blocks far apart cost no more than the bytes stored.
+type sparseFile struct {
+	name   string
+	blocks map[int64][]byte
+	size   int64
+}
+
+const sparseBlock = 512
+
+func (f *sparseFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), f.size-off))
+	for i := 0; i < n; {
+		pos := off + int64(i)
+		b, rel := f.blocks[pos/sparseBlock], pos%sparseBlock
+		m := min(n-i, int(sparseBlock-rel))
+		if b == nil {
+			clear(p[i : i+m])
+		} else {
+			copy(p[i:i+m], b[rel:])
+		}
+		i += m
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *sparseFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.blocks == nil {
+		f.blocks = make(map[int64][]byte)
+	}
+	for i := 0; i < len(p); {
+		pos := off + int64(i)
+		b, rel := f.blocks[pos/sparseBlock], pos%sparseBlock
+		if b == nil {
+			b = make([]byte, sparseBlock)
+			f.blocks[pos/sparseBlock] = b
+		}
+		i += copy(b[rel:], p[i:])
+	}
+	f.size = max(f.size, off+int64(len(p)))
+	return len(p), nil
+}
+
+func (f *sparseFile) Truncate(size int64) error {
+	for blk := range f.blocks {
+		if blk*sparseBlock >= size {
+			delete(f.blocks, blk)
+		}
+	}
+	if b := f.blocks[size/sparseBlock]; b != nil {
+		clear(b[size%sparseBlock:])
+	}
+	f.size = size
+	return nil
+}
+
+func (f *sparseFile) Name() string         { return f.name }
+func (f *sparseFile) Size() (int64, error) { return f.size, nil }
+func (f *sparseFile) Sync() error          { return nil }
+func (f *sparseFile) Close() error         { return nil }
+
+func TestDBM_DeepTrie(t *testing.T) {
+	tests := []struct {
+		name      string
+		hash      int64 // hash of the second key; the first hashes to 0
+		wantErr   error
+		wantDepth int
+	}{
+		{name: "keys apart in bit 40", hash: 1 << 40, wantDepth: 41},
+		{name: "keys apart in the sign bit only", hash: -1 << 63, wantErr: sdbm.ErrSplitOverflow, wantDepth: 62},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := func(key []byte) int64 {
+				if string(key) == "b" {
+					return tt.hash
+				}
+				return 0
+			}
+			dirf, pagf := &sparseFile{name: "sparse.dir"}, &sparseFile{name: "sparse.pag"}
+			db, err := sdbm.OpenFiles(dirf, pagf, sdbm.Options{Flags: os.O_RDWR, HashFunc: hash})
+			if err != nil {
+				t.Fatalf("OpenFiles() error = %v", err)
+			}
+			defer teardown(t, db)
+
+			// the two pairs do not fit a page together, and every split
+			// before the bit telling them apart leaves both in the same page.
+			a := sdbm.Datum(strings.Repeat("a", 600))
+			b := sdbm.Datum(strings.Repeat("b", 600))
+			if _, err := db.Store(sdbm.Datum("a"), a, sdbm.StoreINSERT); err != nil {
+				t.Fatalf("Store(a) error = %v", err)
+			}
+			for range 10 {
+				if _, err = db.Store(sdbm.Datum("b"), b, sdbm.StoreINSERT); !errors.Is(err, sdbm.ErrSplitOverflow) {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Store(b) error = %v, want %v", err, tt.wantErr)
+			}
+
+			for _, key := range []string{"a", "b"} {
+				if _, depth, err := db.LookupCost(sdbm.Datum(key)); err != nil || depth != tt.wantDepth {
+					t.Errorf("LookupCost(%s) depth got = %d, %v, want %d", key, depth, err, tt.wantDepth)
+				}
+			}
+			if got, err := db.Fetch(sdbm.Datum("a")); err != nil || !bytes.Equal(got, a) {
+				t.Errorf("Fetch(a) got %d bytes, %v, want %d bytes", got.Size(), err, a.Size())
+			}
+			want := b
+			if tt.wantErr != nil {
+				want = sdbm.Nullitem
+			}
+			if got, err := db.Fetch(sdbm.Datum("b")); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("Fetch(b) got %d bytes, %v, want %d bytes", got.Size(), err, want.Size())
+			}
+		})
+	}
+}
+
+func TestDBM_DirBits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, DirBlockSize: 256})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	if bits, err := db.DirBits(); err != nil || len(bits) != 0 {
+		t.Fatalf("DirBits() of an empty db got = %d bits, %v, want none", len(bits), err)
+	}
+
+	for _, p := range generatePairs("key", "a longer value", 20000) {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	bits, err := db.DirBits()
+	if err != nil {
+		t.Fatalf("DirBits() error = %v", err)
+	}
+	if len(bits)%(256*sdbm.BITSIZ) != 0 || !bits[0] {
+		t.Fatalf("DirBits() got = %d bits, root %v, want whole blocks with the root set", len(bits), bits[0])
+	}
+	last := int64(-1)
+	for n := 1; n < len(bits); n++ {
+		if bits[n] {
+			last = int64(n)
+			if !bits[(n-1)/2] {
+				t.Errorf("DirBits() bit %d is set but its parent %d is not", n, (n-1)/2)
+			}
+		}
+	}
+	if last < 256*sdbm.BITSIZ {
+		t.Fatalf("DirBits() last set bit got = %d, want one past the first block", last)
+	}
+
+	// clearing a bit rewrites its own block only.
+	if err := sdbm.ClearDBit(db, last); err != nil {
+		t.Fatalf("clearDBit() error = %v", err)
+	}
+	if err := sdbm.ClearDBit(db, 0); err != nil {
+		t.Fatalf("clearDBit() error = %v", err)
+	}
+	got, err := db.DirBits()
+	if err != nil {
+		t.Fatalf("DirBits() error = %v", err)
+	}
+	bits[last], bits[0] = false, false
+	if !slices.Equal(got, bits) {
+		t.Error("DirBits() after clearDBit() differs in other bits than those cleared")
+	}
+}
+
+func TestDBM_StoreIfChanged(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+	writes := sdbm.CountPagWrites(dbm)
+
+	tests := []struct {
+		name       string
+		key        string
+		val        string
+		want       bool
+		wantWrites int
+	}{
+		{name: "value is unchanged", key: "key1", val: "val1", want: false, wantWrites: 0},
+		{name: "value is changed", key: "key1", val: "changed", want: true, wantWrites: 1},
+		{name: "key is absent", key: "key11", val: "val11", want: true, wantWrites: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*writes = 0
+			got, err := dbm.StoreIfChanged(sdbm.Datum(tt.key), sdbm.Datum(tt.val))
+			if err != nil {
+				t.Fatalf("StoreIfChanged() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("StoreIfChanged() got = %v, want %v", got, tt.want)
+			}
+			if *writes != tt.wantWrites {
+				t.Errorf("page writes got = %d, want %d", *writes, tt.wantWrites)
+			}
+			val, err := dbm.Fetch(sdbm.Datum(tt.key))
+			if err != nil || val.String() != tt.val {
+				t.Errorf("Fetch() got = %v, %v, want %v", val, err, tt.val)
+			}
+		})
+	}
+}
+
+func TestDBM_WarmupKeys(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 2000)...)
+	defer teardown(t, dbm)
+	reads := sdbm.CountPagReads(dbm)
+
+	m, err := dbm.KeyPageMap()
+	if err != nil {
+		t.Fatalf("KeyPageMap() error = %v", err)
+	}
+	hot, cold := sdbm.Datum("key7"), sdbm.Datum("")
+	for i := 0; cold.Size() == 0; i++ {
+		if key := "key" + strconv.Itoa(i); m[key] != m[hot.String()] {
+			cold = sdbm.Datum(key)
+		}
+	}
+
+	if err := dbm.WarmupKeys([]sdbm.Datum{cold, hot}); err != nil {
+		t.Fatalf("WarmupKeys() error = %v", err)
+	}
+	*reads = 0
+	if _, err := dbm.Fetch(hot); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if *reads != 0 {
+		t.Errorf("page reads after warmup got = %d, want 0", *reads)
+	}
+
+	if err := dbm.WarmupKeys([]sdbm.Datum{hot, nil, cold}); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("WarmupKeys() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+}
+
+func TestDBM_AssertFetchable(t *testing.T) {
+	_, dbm := setup(t, append(generatePairs("key", "val", 1000),
+		Pair{Key: sdbm.Datum("existing"), Val: sdbm.Datum("kept")})...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name    string
+		key     sdbm.Datum
+		want    sdbm.Datum
+		wantErr error
+	}{
+		{name: "absent key", key: sdbm.Datum("absent")},
+		{name: "existing key", key: sdbm.Datum("existing"), want: sdbm.Datum("kept")},
+		{name: "empty key", key: sdbm.Datum("")},
+		{name: "binary key", key: sdbm.Datum("\x00\xff\x00key\x00")},
+		{name: "long key", key: bytes.Repeat([]byte("k"), 900)},
+		{name: "nil key", key: nil, wantErr: sdbm.ErrInvalidArgument},
+		{name: "too long key", key: bytes.Repeat([]byte("k"), sdbm.PAIRMAX+1), wantErr: sdbm.ErrKeyTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dbm.AssertFetchable(tt.key); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AssertFetchable() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got, err := dbm.Fetch(tt.key)
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fetch() after AssertFetchable got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, p := range generatePairs("key", "val", 1000) {
+		if got, err := dbm.Fetch(p.Key); err != nil || !reflect.DeepEqual(got, p.Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+		}
+	}
+}
+
+func TestDBM_IsStale(t *testing.T) {
+	dir, writer := setup(t)
+	defer teardown(t, writer)
+	reader, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{NoLock: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, reader)
+
+	isStale := func(db *sdbm.DBM) bool {
+		t.Helper()
+		stale, err := db.IsStale()
+		if err != nil {
+			t.Fatalf("IsStale() error = %v", err)
+		}
+		return stale
+	}
+	if isStale(reader) {
+		t.Error("IsStale() before any write got = true, want false")
+	}
+
+	// enough pairs to split pages and grow the directory.
+	for _, p := range generatePairs("key", "val", 2000) {
+		if _, err := writer.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if !isStale(reader) {
+		t.Error("IsStale() after the writer grew the directory got = false, want true")
+	}
+	if isStale(writer) {
+		t.Error("IsStale() of the writer got = true, want false")
+	}
+}
+
+func TestDBM_Reopen(t *testing.T) {
+	dir, writer := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, writer)
+	reader, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDONLY | os.O_CREATE, ReadOnly: true, NoLock: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, reader)
+
+	pairs := generatePairs("key", "val", 2000)
+	for _, p := range pairs {
+		if _, err := writer.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if stale, err := reader.IsStale(); err != nil || !stale {
+		t.Fatalf("IsStale() got = %v, %v, want true", stale, err)
+	}
+
+	if err := reader.Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	if stale, err := reader.IsStale(); err != nil || stale {
+		t.Errorf("IsStale() after Reopen() got = %v, %v, want false", stale, err)
+	}
+	for _, p := range pairs {
+		if got, err := reader.Fetch(p.Key); err != nil || got.String() != p.Val.String() {
+			t.Fatalf("Fetch(%s) after Reopen() got = %q, %v, want %q", p.Key, got, err, p.Val)
+		}
+	}
+	// the options are kept.
+	if _, err := reader.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Store() after Reopen() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+
+	mem, err := sdbm.OpenMem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown(t, mem)
+	if err := mem.Reopen(); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Reopen() of an in-memory db error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+}
+
+func TestOpenWithOptions_RefreshOnChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        sdbm.Options
+		wantMissing bool
+	}{
+		{name: "off", wantMissing: true},
+		{name: "on", opts: sdbm.Options{RefreshOnChange: true}},
+		{name: "on with PageCacheSize", opts: sdbm.Options{RefreshOnChange: true, PageCacheSize: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, writer := setup(t, generatePairs("key", "val", 10)...)
+			defer teardown(t, writer)
+			opts := tt.opts
+			opts.ReadOnly, opts.NoLock = true, true
+			reader, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, reader)
+			if got, err := reader.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+				t.Fatalf("Fetch() got = %q, %v, want val1", got, err)
+			}
+
+			// enough pairs to split pages and grow both files.
+			pairs := generatePairs("key", "val", 2000)
+			for _, p := range pairs {
+				if _, err := writer.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			missing := 0
+			for _, p := range pairs {
+				got, err := reader.Fetch(p.Key)
+				if err != nil {
+					t.Fatalf("Fetch(%s) error = %v", p.Key, err)
+				}
+				if got == nil {
+					missing++
+				} else if got.String() != p.Val.String() {
+					t.Fatalf("Fetch(%s) got = %q, want %q", p.Key, got, p.Val)
+				}
+			}
+			if (missing > 0) != tt.wantMissing {
+				t.Errorf("Fetch() missed %d of %d keys stored by the writer, want missing = %v", missing, len(pairs), tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestDBM_Exists(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name    string
+		key     sdbm.Datum
+		want    bool
+		wantErr error
+	}{
+		{name: "present", key: sdbm.Datum("key500"), want: true},
+		{name: "absent", key: sdbm.Datum("missing")},
+		{name: "empty key", key: sdbm.Datum("")},
+		{name: "nil key", key: nil, wantErr: sdbm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dbm.Exists(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Exists() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Exists() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBM_Store_SplitOverflow(t *testing.T) {
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+
+	// these keys agree in the low 32 bits of their hash, so no number of
+	// splits separates them, and together they do not fit in a page.
+	keys := []string{"axjgjzaxjgjz", "axjgjzziieqj", "ziieqjaxjgjz", "ziieqjziieqj"}
+	val := bytes.Repeat([]byte("v"), 300)
+	for i, key := range keys {
+		if got, want := sdbm.Hash([]byte(key))&0xffffffff, sdbm.Hash([]byte(keys[0]))&0xffffffff; got != want {
+			t.Fatalf("Hash(%s) got = %#x, want %#x", key, got, want)
+		}
+		ok, err := dbm.Store(sdbm.Datum(key), val, sdbm.StoreREPLACE)
+		if i < 3 {
+			if err != nil || !ok {
+				t.Fatalf("Store(%s) got = %v, %v, want true, nil", key, ok, err)
+			}
+			continue
+		}
+		if !errors.Is(err, sdbm.ErrSplitOverflow) || ok {
+			t.Fatalf("Store(%s) got = %v, %v, want false, %v", key, ok, err, sdbm.ErrSplitOverflow)
+		}
+	}
+
+	for i, key := range keys {
+		got, err := dbm.Fetch(sdbm.Datum(key))
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if want := i < 3; (got != nil) != want {
+			t.Errorf("Fetch(%s) found got = %v, want %v", key, got != nil, want)
+		}
+	}
+}
+
+func TestOpenWithOptions_HashFunc(t *testing.T) {
+	fnvHash := func(key []byte) int64 {
+		h := fnv.New64a()
+		_, _ = h.Write(key)
+		return int64(h.Sum64())
+	}
+	path := filepath.Join(t.TempDir(), DBMFile)
+	open := func(hash func([]byte) int64) *sdbm.DBM {
+		t.Helper()
+		db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, HashFunc: hash})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		return db
+	}
+
+	pairs := generatePairs("key", "val", 2000)
+	db := open(fnvHash)
+	for _, p := range pairs {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	teardown(t, db)
+
+	db = open(fnvHash)
+	for _, p := range pairs {
+		if got, err := db.Fetch(p.Key); err != nil || !reflect.DeepEqual(got, p.Val) {
+			t.Fatalf("Fetch(%s) got = %q, %v, want %q", p.Key, got, err, p.Val)
+		}
+	}
+	teardown(t, db)
+
+	// the default hash places the keys elsewhere.
+	db = open(nil)
+	defer teardown(t, db)
+	missing := 0
+	for _, p := range pairs {
+		got, err := db.Fetch(p.Key)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if got == nil {
+			missing++
+		}
+	}
+	if missing == 0 {
+		t.Error("Fetch() with the default hash found every key, want some missing")
+	}
+}
+
+func TestOpenWithOptions_ReadOnly(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 10)...)
+	teardown(t, dbm)
+	path := filepath.Join(dir, DBMFile)
+
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	if got, err := db.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+		t.Errorf("Fetch() got = %q, %v, want val1", got, err)
+	}
+	if _, err := db.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Store() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+	if _, err := db.Delete(sdbm.Datum("key1")); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Delete() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+
+	_, err = sdbm.OpenWithOptions(filepath.Join(dir, "missing"), sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, ReadOnly: true})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenWithOptions() of a missing database error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 10)...)
+	teardown(t, dbm)
+
+	db, err := sdbm.OpenReadOnly(filepath.Join(dir, DBMFile))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	if got, err := db.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+		t.Errorf("Fetch() got = %q, %v, want val1", got, err)
+	}
+	if _, err := db.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Store() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+	if _, err := db.Delete(sdbm.Datum("key1")); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Delete() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+
+	if _, err := sdbm.OpenReadOnly(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenReadOnly() of a missing database error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestOpen_RDOnlyCombined(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 10)...)
+	teardown(t, dbm)
+
+	tests := []struct {
+		name  string
+		flags int
+	}{
+		{name: "O_SYNC", flags: os.O_RDONLY | os.O_SYNC},
+		{name: "O_APPEND", flags: os.O_RDONLY | os.O_APPEND},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sdbm.Open(filepath.Join(dir, DBMFile), tt.flags, 0)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+			if got, err := db.Fetch(sdbm.Datum("key1")); err != nil || got.String() != "val1" {
+				t.Errorf("Fetch() got = %q, %v, want val1", got, err)
+			}
+			if _, err := db.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+				t.Errorf("Store() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+			}
+			if _, err := db.Delete(sdbm.Datum("key1")); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+				t.Errorf("Delete() error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+			}
+		})
+	}
+}
+
+func TestOpenWithOptions_SyncOnWrite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, SyncOnWrite: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	dirSyncs, pagSyncs := sdbm.CountSyncs(db)
+
+	if _, err := db.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if *dirSyncs != 0 || *pagSyncs != 1 {
+		t.Errorf("syncs after Store() got = %d/%d, want 0/1", *dirSyncs, *pagSyncs)
+	}
+
+	// Sync is the same option.
+	alias, err := sdbm.OpenWithOptions(filepath.Join(dir, "alias"), sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, Sync: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	_, aliasSyncs := sdbm.CountSyncs(alias)
+	if _, err := alias.Store(sdbm.Datum("key"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if *aliasSyncs != 1 {
+		t.Errorf("syncs after Store() with Sync got = %d, want 1", *aliasSyncs)
+	}
+	teardown(t, alias)
+
+	if _, err := db.Delete(sdbm.Datum("missing")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if *dirSyncs != 0 || *pagSyncs != 1 {
+		t.Errorf("syncs after Delete() of a missing key got = %d/%d, want 0/1", *dirSyncs, *pagSyncs)
+	}
+	if _, err := db.Delete(sdbm.Datum("key")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if *dirSyncs != 0 || *pagSyncs != 2 {
+		t.Errorf("syncs after Delete() got = %d/%d, want 0/2", *dirSyncs, *pagSyncs)
+	}
+
+	// enough pairs to split pages, which writes the directory.
+	for _, p := range generatePairs("key", "val", 200) {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if *dirSyncs == 0 {
+		t.Error("Store() never synced the .dir file after a split")
+	}
+	if *pagSyncs != 202 {
+		t.Errorf("syncs of the .pag file got = %d, want 202", *pagSyncs)
+	}
+}
+
+type captureLogger struct{ lines []string }
+
+func (l *captureLogger) Printf(format string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestOpenWithOptions_Logger(t *testing.T) {
+	dir := t.TempDir()
+	logger := &captureLogger{}
+	db, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+
+	for _, p := range generatePairs("key", "val", 200) {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, prefix := range []string{"split page ", "pag read: "} {
+		if !slices.ContainsFunc(logger.lines, func(line string) bool { return strings.HasPrefix(line, prefix) }) {
+			t.Errorf("no %q line logged in %d lines", prefix, len(logger.lines))
+		}
+	}
+}
+
+func TestDBM_Store_Insert(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "plain"},
+		{name: "InternValues", opts: sdbm.Options{InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+		{name: "WAL", opts: sdbm.Options{WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			if ok, err := db.Store(sdbm.Datum("key"), sdbm.Datum("first"), sdbm.StoreINSERT); err != nil || !ok {
+				t.Fatalf("Store() of an absent key got = %v, %v, want true, nil", ok, err)
+			}
+			if ok, err := db.Store(sdbm.Datum("key"), sdbm.Datum("second"), sdbm.StoreINSERT); err != nil || ok {
+				t.Fatalf("Store() of a present key got = %v, %v, want false, nil", ok, err)
+			}
+			if got, err := db.Fetch(sdbm.Datum("key")); err != nil || got.String() != "first" {
+				t.Errorf("Fetch() got = %q, %v, want first", got, err)
+			}
+			if n, err := db.Count(); err != nil || n != 1 {
+				t.Errorf("Count() got = %d, %v, want 1", n, err)
+			}
+		})
+	}
+}
+
+func TestDBM_StoreAndGet(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name        string
+		key         string
+		val         string
+		flags       sdbm.StoreFlags
+		wantOld     sdbm.Datum
+		wantExisted bool
+		wantVal     string
+	}{
+		{name: "replace a present key", key: "key1", val: "changed", flags: sdbm.StoreREPLACE, wantOld: sdbm.Datum("val1"), wantExisted: true, wantVal: "changed"},
+		{name: "replace an absent key", key: "key11", val: "val11", flags: sdbm.StoreREPLACE, wantOld: nil, wantExisted: false, wantVal: "val11"},
+		{name: "seedups a present key", key: "key2", val: "changed", flags: sdbm.StoreSEEDUPS, wantOld: sdbm.Datum("val2"), wantExisted: true, wantVal: "val2"},
+		{name: "insert a present key", key: "key3", val: "changed", flags: sdbm.StoreINSERT, wantOld: sdbm.Datum("val3"), wantExisted: true, wantVal: "val3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, existed, err := dbm.StoreAndGet(sdbm.Datum(tt.key), sdbm.Datum(tt.val), tt.flags)
+			if err != nil {
+				t.Fatalf("StoreAndGet() error = %v", err)
+			}
+			if !reflect.DeepEqual(old, tt.wantOld) || existed != tt.wantExisted {
+				t.Errorf("StoreAndGet() got = %q, %v, want %q, %v", old, existed, tt.wantOld, tt.wantExisted)
+			}
+			if got, _ := dbm.Fetch(sdbm.Datum(tt.key)); got.String() != tt.wantVal {
+				t.Errorf("Fetch() got = %q, want %q", got, tt.wantVal)
+			}
+		})
+	}
+}
+
+func TestDBM_DeleteAndGet(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+	reads := sdbm.CountPagReads(dbm)
+
+	val, ok, err := dbm.DeleteAndGet(sdbm.Datum("key1"))
+	if err != nil || !ok || val.String() != "val1" {
+		t.Errorf("DeleteAndGet() got = %q, %v, %v, want val1, true, nil", val, ok, err)
+	}
+	if *reads > 1 {
+		t.Errorf("DeleteAndGet() page reads got = %d, want at most 1", *reads)
+	}
+	if got, _ := dbm.Fetch(sdbm.Datum("key1")); got != nil {
+		t.Errorf("Fetch() after DeleteAndGet() got = %q, want nil", got)
+	}
+
+	val, ok, err = dbm.DeleteAndGet(sdbm.Datum("key1"))
+	if err != nil || ok || val != nil {
+		t.Errorf("DeleteAndGet() of an absent key got = %q, %v, %v, want nil, false, nil", val, ok, err)
+	}
+}
+
+func TestDBM_Increment(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name       string
+		key        string
+		delta      int64
+		want       int64
+		wantWrites int
+		wantErr    error
+	}{
+		{name: "absent key", key: "hits", delta: 1, want: 1, wantWrites: 1},
+		{name: "present key", key: "hits", delta: 41, want: 42, wantWrites: 1},
+		{name: "negative delta", key: "hits", delta: -50, want: -8, wantWrites: 1},
+		{name: "value is not a counter", key: "key1", delta: 1, wantErr: sdbm.ErrInvalidArgument},
+		{name: "key is nil", key: "", delta: 1, wantErr: sdbm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key sdbm.Datum
+			if tt.key != "" {
+				key = sdbm.Datum(tt.key)
+			}
+			writes := sdbm.CountPagWrites(dbm)
+			got, err := dbm.Increment(key, tt.delta)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Increment() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Increment() got = %d, want %d", got, tt.want)
+			}
+			if *writes != tt.wantWrites {
+				t.Errorf("Increment() page writes got = %d, want %d", *writes, tt.wantWrites)
+			}
+		})
+	}
+	if got, _ := dbm.Fetch(sdbm.Datum("key1")); got.String() != "val1" {
+		t.Errorf("Fetch(key1) got = %q, want val1", got)
+	}
+}
+
+func TestDBM_FetchInto(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "value", 10)...)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name      string
+		key       string
+		dstLen    int
+		wantN     int
+		wantFound bool
+		wantDst   string
+	}{
+		{name: "dst is large enough", key: "key1", dstLen: 16, wantN: 6, wantFound: true, wantDst: "value1"},
+		{name: "dst is exactly large enough", key: "key1", dstLen: 6, wantN: 6, wantFound: true, wantDst: "value1"},
+		{name: "dst is too small", key: "key1", dstLen: 3, wantN: 6, wantFound: true, wantDst: ""},
+		{name: "key is absent", key: "key11", dstLen: 16, wantN: 0, wantFound: false, wantDst: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := make([]byte, tt.dstLen)
+			n, found, err := dbm.FetchInto(sdbm.Datum(tt.key), dst)
+			if err != nil {
+				t.Fatalf("FetchInto() error = %v", err)
+			}
+			if n != tt.wantN || found != tt.wantFound {
+				t.Errorf("FetchInto() got = %d, %v, want %d, %v", n, found, tt.wantN, tt.wantFound)
+			}
+			if got := string(bytes.TrimRight(dst, "\x00")); got != tt.wantDst {
+				t.Errorf("FetchInto() dst got = %q, want %q", got, tt.wantDst)
+			}
+		})
+	}
+
+	key, dst := sdbm.Datum("key1"), make([]byte, 16)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, _, err := dbm.FetchInto(key, dst); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("FetchInto() allocs got = %v, want 0", allocs)
+	}
+}
+
+func TestDBM_FetchAll(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "plain"},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			// the duplicates are split across pages with the rest.
+			want := []string{"first", "a rather longer second value", ""}
+			for i, p := range generatePairs("key", "val", 1000) {
+				if i%300 == 0 && i/300 < len(want) {
+					if _, err := db.Store(sdbm.Datum("dup"), sdbm.Datum(want[i/300]), 0); err != nil {
+						t.Fatalf("Store() error = %v", err)
+					}
+				}
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+
+			got, err := db.FetchAll(sdbm.Datum("dup"))
+			if err != nil {
+				t.Fatalf("FetchAll() error = %v", err)
+			}
+			var gotStr []string
+			for _, v := range got {
+				gotStr = append(gotStr, string(v))
+			}
+			if !slices.Equal(gotStr, want) {
+				t.Errorf("FetchAll() got = %q, want %q", gotStr, want)
+			}
+
+			got, err = db.FetchAll(sdbm.Datum("key1"))
+			if err != nil || len(got) != 1 || string(got[0]) != "val1" {
+				t.Errorf("FetchAll(key1) got = %q, %v, want [val1]", got, err)
+			}
+			got, err = db.FetchAll(sdbm.Datum("absent"))
+			if err != nil || got != nil {
+				t.Errorf("FetchAll(absent) got = %q, %v, want nil", got, err)
+			}
+		})
+	}
+}
+
+func TestDBM_DeleteAll(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "plain"},
+		{name: "InternValues", opts: sdbm.Options{InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+		{name: "WAL", opts: sdbm.Options{WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, db)
+
+			for _, val := range []string{"first", "a rather longer second value", "third"} {
+				if _, err := db.Store(sdbm.Datum("dup"), sdbm.Datum(val), 0); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			if _, err := db.Store(sdbm.Datum("other"), sdbm.Datum("value"), sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			if n, err := db.DeleteAll(sdbm.Datum("dup")); err != nil || n != 3 {
+				t.Fatalf("DeleteAll() got = %d, %v, want 3", n, err)
+			}
+			if got, err := db.FetchAll(sdbm.Datum("dup")); err != nil || got != nil {
+				t.Errorf("FetchAll() after DeleteAll() got = %q, %v, want nil", got, err)
+			}
+			if got, err := db.Fetch(sdbm.Datum("other")); err != nil || string(got) != "value" {
+				t.Errorf("Fetch(other) got = %q, %v, want value", got, err)
+			}
+			if n, err := db.DeleteAll(sdbm.Datum("dup")); err != nil || n != 0 {
+				t.Errorf("DeleteAll() of an absent key got = %d, %v, want 0", n, err)
+			}
+			if _, err := db.DeleteAll(nil); !errors.Is(err, sdbm.ErrInvalidArgument) {
+				t.Errorf("DeleteAll() of a nil key error = %v, want %v", err, sdbm.ErrInvalidArgument)
+			}
+		})
+	}
+
+	dir, db := setup(t, Pair{Key: sdbm.Datum("dup"), Val: sdbm.Datum("value")})
+	teardown(t, db)
+	db, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, db)
+	if _, err := db.DeleteAll(sdbm.Datum("dup")); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("DeleteAll() on a read-only db error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+}
+
+func TestDBM_Clear(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdbm.Options
+	}{
+		{name: "plain"},
+		{name: "FormatHeader", opts: sdbm.Options{FormatHeader: true}},
+		{name: "InternValues", opts: sdbm.Options{InternValues: true}},
+		{name: "OverflowThreshold", opts: sdbm.Options{OverflowThreshold: 8}},
+		{name: "WAL", opts: sdbm.Options{WAL: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DBMFile)
+			opts := tt.opts
+			opts.Flags, opts.Mode = os.O_RDWR|os.O_CREATE, 0644
+			db, err := sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			for _, p := range generatePairs("key", "a longer value", 2000) {
+				if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+
+			if err := db.Clear(); err != nil {
+				t.Fatalf("Clear() error = %v", err)
+			}
+			if key, err := db.FirstKey(); err != nil || key != nil {
+				t.Errorf("FirstKey() after Clear() got = %q, %v, want nil, nil", key, err)
+			}
+			if got, err := db.Fetch(sdbm.Datum("key1")); err != nil || got != nil {
+				t.Errorf("Fetch() after Clear() got = %q, %v, want nil, nil", got, err)
+			}
+			if fi, err := os.Stat(path + sdbm.DIRFEXT); err != nil || fi.Size() != 0 {
+				t.Errorf("Clear() left a .dir file of %v bytes, %v", fi.Size(), err)
+			}
+
+			// the database is usable afterwards, and stays empty when reopened.
+			if _, err := db.Store(sdbm.Datum("new"), sdbm.Datum("val"), sdbm.StoreREPLACE); err != nil {
+				t.Fatalf("Store() after Clear() error = %v", err)
+			}
+			teardown(t, db)
+			db, err = sdbm.OpenWithOptions(path, opts)
+			if err != nil {
+				t.Fatalf("failed to reopen db: %v", err)
+			}
+			defer teardown(t, db)
+			if n, err := db.Count(); err != nil || n != 1 {
+				t.Errorf("Count() after reopening got = %d, %v, want 1", n, err)
+			}
+			if got, err := db.Fetch(sdbm.Datum("new")); err != nil || got.String() != "val" {
+				t.Errorf("Fetch(new) after reopening got = %q, %v, want val", got, err)
+			}
+		})
+	}
+}
+
+func TestDBM_Clear_Refused(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 10)...)
+	snap, err := dbm.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := dbm.Clear(); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Clear() with a snapshot open error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+	snap.Close()
+	teardown(t, dbm)
+
+	ro, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, ro)
+	if err := ro.Clear(); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Clear() on a read-only database error = %v, want %v", err, sdbm.ErrDBMRDOnly)
+	}
+	if n, err := ro.Count(); err != nil || n != 10 {
+		t.Errorf("Count() after a refused Clear() got = %d, %v, want 10", n, err)
+	}
+}
+
+func TestDBM_PageIO_IOError(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     func(db *sdbm.DBM) error
+		wantOp string
+	}{
+		{
+			name: "Fetch reads the page at its offset",
+			op: func(db *sdbm.DBM) error {
+				_, err := db.Fetch(sdbm.Datum("key1"))
+				return err
+			},
+			wantOp: "readat",
+		},
+		{
+			name: "FirstKey reads page 0 at its offset",
+			op: func(db *sdbm.DBM) error {
+				_, err := db.FirstKey()
+				return err
+			},
+			wantOp: "readat",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t, generatePairs("key", "val", 10)...)
+			teardown(t, dbm)
+			db, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			sdbm.Abandon(db)
+
+			var ioerr *sdbm.IOError
+			if err := tt.op(db); !errors.As(err, &ioerr) || ioerr.Op != tt.wantOp {
+				t.Errorf("error = %v, want an IOError with Op %q", err, tt.wantOp)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package sdbmhttp serves an SDBM database read-only over HTTP.
+package sdbmhttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+// Handler returns an http.Handler that serves db read-only:
+//
+//	GET /       lists every key, percent-encoded, one per line.
+//	GET /{key}  returns the value stored under the percent-decoded key,
+//	            or 404 Not Found if the key is not present.
+//
+// A key the database cannot hold, such as one longer than its PairMax, is
+// rejected with 400 Bad Request. The empty key cannot be fetched, since its
+// path is the listing; it is listed as an empty line.
+//
+// Other methods are rejected with 405 Method Not Allowed. Requests are
+// served one at a time, since a DBM is not safe for concurrent use; the
+// database must not be used elsewhere while the handler is serving.
+func Handler(db *sdbm.DBM) http.Handler {
+	return &handler{db: db}
+}
+
+type handler struct {
+	mu sync.Mutex
+	db *sdbm.DBM
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if path == "" {
+		h.serveKeys(w)
+		return
+	}
+	key, err := url.PathUnescape(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serveValue(w, r, sdbm.Datum(key))
+}
+
+func (h *handler) serveKeys(w http.ResponseWriter) {
+	var buf bytes.Buffer
+
+	h.mu.Lock()
+	key, err := h.db.FirstKey()
+	for ; err == nil && key != nil; key, err = h.db.NextKey() {
+		buf.WriteString(url.PathEscape(key.String()))
+		buf.WriteByte('\n')
+	}
+	h.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (h *handler) serveValue(w http.ResponseWriter, r *http.Request, key sdbm.Datum) {
+	h.mu.Lock()
+	val, err := h.db.Fetch(key)
+	// the value aliases the page buffer; copy it before unlocking.
+	val = bytes.Clone(val)
+	h.mu.Unlock()
+
+	if errors.Is(err, sdbm.ErrKeyTooLong) || errors.Is(err, sdbm.ErrInvalidArgument) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if val == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(val)
+}
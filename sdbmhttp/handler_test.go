@@ -0,0 +1,89 @@
+package sdbmhttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+	"github.com/vvatanabe/go-sdbm/sdbmhttp"
+)
+
+func TestHandler(t *testing.T) {
+	db, err := sdbm.Open(filepath.Join(t.TempDir(), "sdbm_test"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	for _, kv := range [][2]string{{"key1", "val1"}, {"a/b c", "val2"}} {
+		if _, err := db.Store(sdbm.Datum(kv[0]), sdbm.Datum(kv[1]), 0); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(sdbmhttp.Handler(db))
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("GET %s read error = %v", path, err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "hit", path: "/key1", wantCode: http.StatusOK, wantBody: "val1"},
+		{name: "hit with escaped key", path: "/a%2Fb%20c", wantCode: http.StatusOK, wantBody: "val2"},
+		{name: "miss", path: "/key2", wantCode: http.StatusNotFound},
+		{name: "key too long", path: "/" + strings.Repeat("k", db.PairMax()+1), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := get(tt.path)
+			if code != tt.wantCode {
+				t.Errorf("GET %s code got = %d, want %d", tt.path, code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && body != tt.wantBody {
+				t.Errorf("GET %s body got = %q, want %q", tt.path, body, tt.wantBody)
+			}
+		})
+	}
+
+	code, body := get("/")
+	if code != http.StatusOK {
+		t.Fatalf("GET / code got = %d, want %d", code, http.StatusOK)
+	}
+	keys := strings.Fields(body)
+	sort.Strings(keys)
+	if want := []string{"a%2Fb%20c", "key1"}; strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("GET / keys got = %v, want %v", keys, want)
+	}
+
+	resp, err := http.Post(srv.URL+"/key1", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST code got = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,263 @@
+package sdbm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// cowState tracks the open snapshots of a database. Its mutex orders every
+// write to the .dir and .pag files against the creation and closing of
+// snapshots; readers of a snapshot never take it.
+type cowState struct {
+	mu    sync.Mutex
+	snaps map[*Snapshot]struct{}
+}
+
+// blockID names a block of the .dir or .pag file by its byte offset.
+type blockID struct {
+	dir bool
+	off int64
+}
+
+// Snapshot is a consistent, read-only view of a database as of the moment
+// it was taken. Writes made through the database afterwards are not visible
+// to it: before the database overwrites a block, it preserves the old
+// contents for every open snapshot, and the snapshot reads the preserved
+// copy instead of the file. Preserved blocks are shared between snapshots
+// and are reclaimed once every snapshot that holds them is closed.
+//
+// A Snapshot may be used by any number of goroutines at once, concurrently
+// with a goroutine writing to the database, without further locking. It
+// sees the pages as last written, so a pair stored by a WriteBatch or
+// TxGroup still being committed may be missing from it. Like the methods
+// other than Store, Fetch and Delete, it sees the records written under
+// Options.InternValues and Options.OverflowThreshold rather than the values.
+// Close every snapshot before closing the database.
+type Snapshot struct {
+	db      *DBM
+	dirf    File
+	pagf    File
+	dirSize int64    // size of the .dir file when the snapshot was taken
+	pagSize int64    // size of the .pag file when the snapshot was taken
+	saved   sync.Map // blockID -> []byte, blocks overwritten since
+}
+
+// Snapshot returns a snapshot of the current contents of the database.
+// It may be called concurrently with writes to the database.
+func (db *DBM) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{db: db, dirf: db.dirf, pagf: db.pagf}
+
+	db.cow.mu.Lock()
+	defer db.cow.mu.Unlock()
+	var err error
+	if s.dirSize, err = s.dirf.Size(); err != nil {
+		return nil, wrapIOErr("stat", s.dirf.Name(), err)
+	}
+	if s.pagSize, err = s.pagf.Size(); err != nil {
+		return nil, wrapIOErr("stat", s.pagf.Name(), err)
+	}
+
+	if db.cow.snaps == nil {
+		db.cow.snaps = make(map[*Snapshot]struct{})
+	}
+	db.cow.snaps[s] = struct{}{}
+	return s, nil
+}
+
+// Close releases the snapshot and the blocks preserved for it.
+func (s *Snapshot) Close() error {
+	s.db.cow.mu.Lock()
+	delete(s.db.cow.snaps, s)
+	s.db.cow.mu.Unlock()
+	s.saved.Clear()
+	return nil
+}
+
+// cowWrite runs write, which overwrites the size bytes at off in the .dir
+// file if dir is set and in the .pag file otherwise, after preserving the
+// current contents of those bytes for every open snapshot that has not
+// preserved them yet.
+func (db *DBM) cowWrite(dir bool, off int64, size int, write func() error) error {
+	db.cow.mu.Lock()
+	defer db.cow.mu.Unlock()
+
+	id := blockID{dir: dir, off: off}
+	var old []byte
+	for s := range db.cow.snaps {
+		if off >= s.limit(dir) {
+			// the snapshot reads the block as zeros anyway.
+			continue
+		}
+		if _, ok := s.saved.Load(id); ok {
+			continue
+		}
+		if old == nil {
+			f := db.pagf
+			if dir {
+				f = db.dirf
+			}
+			old = make([]byte, size)
+			if err := readFull(f, old, off); err != nil {
+				return err
+			}
+		}
+		s.saved.Store(id, old)
+	}
+	return write()
+}
+
+func (s *Snapshot) limit(dir bool) int64 {
+	if dir {
+		return s.dirSize
+	}
+	return s.pagSize
+}
+
+// readFull reads len(buf) bytes at off from f, reading whatever lies beyond
+// the end of the file as zeros.
+func readFull(f File, buf []byte, off int64) error {
+	n, err := f.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return wrapIOErr("readat", f.Name(), err)
+	}
+	clear(buf[n:])
+	return nil
+}
+
+// read fills buf with the block at off as it was when the snapshot was
+// taken. The file is read before the preserved copies are consulted: a
+// block is preserved before it is overwritten, so if no copy exists once
+// the read is done, the read saw the old contents.
+func (s *Snapshot) read(dir bool, off int64, buf []byte) error {
+	limit := s.limit(dir)
+	if off >= limit {
+		clear(buf)
+		return nil
+	}
+	f := s.pagf
+	if dir {
+		f = s.dirf
+	}
+	if err := readFull(f, buf, off); err != nil {
+		return err
+	}
+	if old, ok := s.saved.Load(blockID{dir: dir, off: off}); ok {
+		copy(buf, old.([]byte))
+	}
+	if end := limit - off; end < int64(len(buf)) {
+		clear(buf[end:])
+	}
+	return nil
+}
+
+// readPage reads page pagb as it was when the snapshot was taken.
+func (s *Snapshot) readPage(pagb int64, p *Page) error {
+	if err := s.read(false, s.db.offPag(pagb), p.buf); err != nil {
+		return err
+	}
+	return s.db.checkPage(pagb, p)
+}
+
+// Fetch returns a copy of the value key held when the snapshot was taken,
+// or nil if it held none.
+func (s *Snapshot) Fetch(key Datum) (Datum, error) {
+	return s.db.fetchVia(key, s.dirSize*BITSIZ, s.read)
+}
+
+// ForEach calls fn with copies of every pair the database held when the
+// snapshot was taken, in page order, stopping at the first error returned
+// by fn, which ForEach then returns.
+func (s *Snapshot) ForEach(fn func(key, val Datum) error) error {
+	p := s.db.newPage()
+	for pagb := int64(0); s.db.offPag(pagb) < s.pagSize; pagb++ {
+		if err := s.readPage(pagb, p); err != nil {
+			return err
+		}
+		for _, pair := range copyPairs(p) {
+			if err := fn(pair.Key, pair.Val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SnapshotTo copies the .dir and .pag files of the database to new files
+// named after path, and opens the copy read-only, as a database frozen as
+// of the copy that needs no care from the original. Unlike Snapshot, it
+// duplicates the files on disk and takes time proportional to their size,
+// during which writes to the database wait. The files must not exist yet.
+// The copy is opened with the options of the database, and is closed
+// independently of it. Databases with Options.InternValues or
+// Options.OverflowThreshold keep values in further files, so SnapshotTo
+// fails for them with ErrInvalidArgument.
+func (db *DBM) SnapshotTo(path string) (*DBM, error) {
+	if path == "" || db.blobs != nil || db.ovf != nil {
+		return nil, ErrInvalidArgument
+	}
+	if err := db.flush(); err != nil {
+		return nil, err
+	}
+	if err := db.copyFiles(path+DIRFEXT, path+PAGFEXT); err != nil {
+		return nil, err
+	}
+	opts := db.opts
+	opts.ReadOnly = true
+	opts.WAL = false
+	snap, err := OpenWithOptions(path, opts)
+	if err != nil {
+		_ = os.Remove(path + DIRFEXT)
+		_ = os.Remove(path + PAGFEXT)
+		return nil, err
+	}
+	return snap, nil
+}
+
+// copyFiles copies the .dir and .pag files to new files with the given
+// names, ordered against writes like the creation of a Snapshot.
+func (db *DBM) copyFiles(dirname, pagname string) error {
+	db.cow.mu.Lock()
+	defer db.cow.mu.Unlock()
+	perm := os.FileMode(0600)
+	if f, ok := db.pagf.(osFile); ok {
+		if fi, err := f.Stat(); err == nil {
+			perm = fi.Mode().Perm()
+		}
+	}
+	if err := copyFile(dirname, db.dirf, perm); err != nil {
+		return err
+	}
+	if err := copyFile(pagname, db.pagf, perm); err != nil {
+		_ = os.Remove(dirname)
+		return err
+	}
+	return nil
+}
+
+// copyFile copies src to a new, synced file named name, which is removed
+// again if the copy fails.
+func copyFile(name string, src File, perm os.FileMode) error {
+	size, err := src.Size()
+	if err != nil {
+		return wrapIOErr("stat", src.Name(), err)
+	}
+	dst, err := openFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, io.NewSectionReader(src, 0, size))
+	if err != nil {
+		err = wrapIOErr("copy", name, err)
+	} else if err = dst.Sync(); err != nil {
+		err = wrapIOErr("sync", name, err)
+	}
+	if cerr := dst.Close(); cerr != nil && err == nil {
+		err = wrapIOErr("close", name, cerr)
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package sdbm_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestSnapshot_Isolation(t *testing.T) {
+	pairs := generatePairs("key", "val", 500)
+	_, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	snap, err := dbm.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	defer snap.Close()
+
+	// enough new keys to split pages and grow the directory.
+	for _, p := range pairs {
+		if _, err := dbm.Store(p.Key, sdbm.Datum("changed"), sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, p := range generatePairs("new", "val", 2000) {
+		if _, err := dbm.Store(p.Key, p.Val, 0); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if _, err := dbm.Delete(sdbm.Datum("key1")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	for _, p := range pairs {
+		got, err := snap.Fetch(p.Key)
+		if err != nil {
+			t.Fatalf("Snapshot.Fetch() error = %v", err)
+		}
+		if got.String() != p.Val.String() {
+			t.Errorf("Snapshot.Fetch(%s) got = %q, want %q", p.Key, got, p.Val)
+		}
+	}
+	if got, err := snap.Fetch(sdbm.Datum("new1")); err != nil || got != nil {
+		t.Errorf("Snapshot.Fetch(new1) got = %q, %v, want nil, nil", got, err)
+	}
+
+	n := 0
+	err = snap.ForEach(func(key, val sdbm.Datum) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Snapshot.ForEach() error = %v", err)
+	}
+	if n != len(pairs) {
+		t.Errorf("Snapshot.ForEach() pairs got = %d, want %d", n, len(pairs))
+	}
+
+	if got, err := dbm.Fetch(sdbm.Datum("key2")); err != nil || got.String() != "changed" {
+		t.Errorf("Fetch(key2) got = %q, %v, want changed", got, err)
+	}
+}
+
+func TestSnapshot_ConcurrentWriter(t *testing.T) {
+	const n = 300
+	key := func(i int) sdbm.Datum { return sdbm.Datum("key" + strconv.Itoa(i)) }
+	gen := func(g int) string { return fmt.Sprintf("gen%04d", g) }
+
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+	for i := 0; i < n; i++ {
+		if _, err := dbm.Store(key(i), sdbm.Datum(gen(0)), sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	// the writer rewrites every key with the next generation, in key order,
+	// so any consistent view holds one generation for a prefix of the keys
+	// and the previous one for the rest.
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer done.Store(true)
+		for g := 1; g <= 20; g++ {
+			for i := 0; i < n; i++ {
+				val := sdbm.Datum(gen(g) + string(make([]byte, i%50)))
+				if _, err := dbm.Store(key(i), val, sdbm.StoreREPLACE); err != nil {
+					t.Errorf("Store() error = %v", err)
+					return
+				}
+			}
+		}
+	}()
+
+	errc := make(chan error, 4)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				if err := checkSnapshot(dbm, n, key); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
+func checkSnapshot(dbm *sdbm.DBM, n int, key func(int) sdbm.Datum) error {
+	snap, err := dbm.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	vals := make([]string, n)
+	for i := range vals {
+		val, err := snap.Fetch(key(i))
+		if err != nil {
+			return err
+		}
+		if val.Size() < 7 {
+			return fmt.Errorf("Snapshot.Fetch(%s) got = %q", key(i), val)
+		}
+		vals[i] = val[:7].String()
+	}
+	// generations never increase along the keys and differ by at most one.
+	for i := 1; i < n; i++ {
+		if vals[i] > vals[i-1] {
+			return fmt.Errorf("inconsistent snapshot: %s=%s after %s=%s", key(i), vals[i], key(i-1), vals[i-1])
+		}
+	}
+	if vals[0] != vals[n-1] && vals[0] != fmt.Sprintf("gen%04d", atoiGen(vals[n-1])+1) {
+		return fmt.Errorf("inconsistent snapshot: %s=%s, %s=%s", key(0), vals[0], key(n-1), vals[n-1])
+	}
+	// a second read of the same snapshot sees exactly the same values.
+	for i := range vals {
+		val, err := snap.Fetch(key(i))
+		if err != nil {
+			return err
+		}
+		if val.Size() < 7 || val[:7].String() != vals[i] {
+			return errors.New("snapshot changed between reads")
+		}
+	}
+	return nil
+}
+
+func atoiGen(gen string) int {
+	g, _ := strconv.Atoi(gen[len("gen"):])
+	return g
+}
+
+func TestDBM_SnapshotTo(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	dir, dbm := setup(t, pairs...)
+	defer teardown(t, dbm)
+
+	path := filepath.Join(dir, "copy")
+	snap, err := dbm.SnapshotTo(path)
+	if err != nil {
+		t.Fatalf("SnapshotTo() error = %v", err)
+	}
+	defer teardown(t, snap)
+
+	for _, p := range pairs {
+		if _, err := dbm.Store(p.Key, sdbm.Datum("changed"), sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, p := range generatePairs("new", "val", 2000) {
+		if _, err := dbm.Store(p.Key, p.Val, 0); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	for _, p := range pairs {
+		if got, err := snap.Fetch(p.Key); err != nil || got.String() != p.Val.String() {
+			t.Fatalf("Fetch(%s) of the copy got = %q, %v, want %q", p.Key, got, err, p.Val)
+		}
+	}
+	if n, err := snap.Count(); err != nil || n != len(pairs) {
+		t.Errorf("Count() of the copy got = %d, %v, want %d", n, err, len(pairs))
+	}
+	if _, err := snap.Store(sdbm.Datum("key1"), sdbm.Datum("val1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrDBMRDOnly) {
+		t.Errorf("Store() to the copy error = %v, want ErrDBMRDOnly", err)
+	}
+
+	// existing files are neither overwritten nor removed.
+	if _, err := dbm.SnapshotTo(path); !errors.Is(err, os.ErrExist) {
+		t.Errorf("SnapshotTo() over existing files error = %v, want os.ErrExist", err)
+	}
+	if _, err := os.Stat(path + sdbm.DIRFEXT); err != nil {
+		t.Errorf("copy after a failed SnapshotTo(): %v", err)
+	}
+}
+
+func TestDBM_SnapshotTo_Mem(t *testing.T) {
+	pairs := generatePairs("key", "val", 1000)
+	dbm := setupMem(t, pairs...)
+	defer teardown(t, dbm)
+
+	snap, err := dbm.SnapshotTo(filepath.Join(t.TempDir(), "copy"))
+	if err != nil {
+		t.Fatalf("SnapshotTo() error = %v", err)
+	}
+	defer teardown(t, snap)
+	for _, p := range pairs {
+		if got, err := snap.Fetch(p.Key); err != nil || got.String() != p.Val.String() {
+			t.Fatalf("Fetch(%s) of the copy got = %q, %v, want %q", p.Key, got, err, p.Val)
+		}
+	}
+}
+
+func TestDBM_SnapshotTo_InvalidArgument(t *testing.T) {
+	dir := t.TempDir()
+	dbm, err := sdbm.OpenWithOptions(filepath.Join(dir, DBMFile), sdbm.Options{Flags: os.O_RDWR | os.O_CREATE, Mode: 0644, OverflowThreshold: 100})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+	if _, err := dbm.SnapshotTo(filepath.Join(dir, "copy")); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("SnapshotTo() error = %v, want ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,152 @@
+package sdbm
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+)
+
+// Stats holds size and fill metrics of a database, as reported by DBM.Stats.
+type Stats struct {
+	PagFileSize int64 // size of the .pag file in bytes
+	DirFileSize int64 // size of the .dir file in bytes
+	PageCount   int64 // pages in the .pag file, holes included
+	PairCount   int64 // pairs stored
+	// AverageFill is the mean fraction of the page size in use, by the offset
+	// table and the pairs, over the pages that hold at least one pair.
+	// It is 0 for an empty database.
+	AverageFill float64
+}
+
+// Stats scans the .pag file once and reports its size and fill metrics.
+// A low AverageFill over many pages suggests the database would shrink
+// if it were rebuilt. The cursor used by FirstKey and NextKey is not
+// disturbed.
+func (db *DBM) Stats() (Stats, error) {
+	var s Stats
+	var err error
+	if s.PagFileSize, err = db.pagf.Size(); err != nil {
+		return Stats{}, wrapIOErr("stat", db.pagf.Name(), err)
+	}
+	if s.DirFileSize, err = db.dirf.Size(); err != nil {
+		return Stats{}, wrapIOErr("stat", db.dirf.Name(), err)
+	}
+
+	var used, filled int64
+	err = db.walkPages(func(_ int64, p *Page) error {
+		s.PageCount++
+		if n := int64(p.getN()); n > 0 {
+			s.PairCount += n / 2
+			used += int64(len(p.buf) - p.free())
+			filled++
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	if filled > 0 {
+		s.AverageFill = float64(used) / float64(filled*int64(db.format.PageSize))
+	}
+	return s, nil
+}
+
+// DiskUsage returns the sizes of the .pag and .dir files, without reading
+// them. They are the lengths of the files, holes included, so a file grown
+// sparse by page splits may take less space on disk than reported. The
+// value store of Options.InternValues, the overflow file and the
+// write-ahead log are not counted. The cursor used by FirstKey and NextKey
+// and the page cache are not disturbed.
+func (db *DBM) DiskUsage() (pagBytes, dirBytes int64, err error) {
+	if pagBytes, err = db.pagf.Size(); err != nil {
+		return 0, 0, wrapIOErr("stat", db.pagf.Name(), err)
+	}
+	if dirBytes, err = db.dirf.Size(); err != nil {
+		return 0, 0, wrapIOErr("stat", db.dirf.Name(), err)
+	}
+	return pagBytes, dirBytes, nil
+}
+
+// approxSamples is the number of pages ApproxCount reads.
+const approxSamples = 16
+
+// ApproxCount estimates the number of pairs in the database without
+// scanning the .pag file. It counts the leaves of the trie from the set
+// bits of the .dir file, one per split plus the root, and multiplies that
+// by the mean number of pairs in approxSamples leaves picked uniformly at
+// random, so it reads the .dir file and only approxSamples pages. It
+// returns the estimate and the number of leaves, the pages in use.
+//
+// Every leaf is as likely to be sampled, however unbalanced the trie, so
+// the estimate is not skewed toward the shallow pages that take a large
+// share of the hashes. It is still a sample: its error grows with how
+// unevenly the pairs are spread over the pages. With keys hashing evenly it
+// is usually within a few tens of percent of the exact Count, but a
+// database with a few crowded pages among many empty ones, such as after
+// many deletions, can be misjudged by far more. Use Count where the exact
+// figure matters. The cursor used by FirstKey and NextKey is not
+// disturbed.
+func (db *DBM) ApproxCount() (pairs int, pages int, err error) {
+	if err := db.flush(); err != nil {
+		return 0, 0, err
+	}
+	splits := 0
+	blk := int64(db.format.DirBlockSize)
+	for dirb := int64(0); dirb*blk*BITSIZ < db.maxbno; dirb++ {
+		if err := db.readDirBlock(dirb); err != nil {
+			return 0, 0, err
+		}
+		for _, c := range db.dirbuf {
+			splits += bits.OnesCount8(c)
+		}
+	}
+	pages = splits + 1
+
+	// draw the ranks of the leaves to sample, then find their pages in one
+	// walk of the trie. A fixed seed keeps the estimate of unchanged files
+	// stable.
+	rnd := rand.New(rand.NewPCG(uint64(pages), 0))
+	ranks := make([]int, approxSamples)
+	for i := range ranks {
+		ranks[i] = rnd.IntN(pages)
+	}
+	slices.Sort(ranks)
+	pagbs := make([]int64, 0, approxSamples)
+	leaf := 0
+	db.walkLeaves(0, 0, 0, func(pagb int64) bool {
+		for len(pagbs) < len(ranks) && ranks[len(pagbs)] == leaf {
+			pagbs = append(pagbs, pagb)
+		}
+		leaf++
+		return len(pagbs) < len(ranks)
+	})
+	if len(pagbs) == 0 {
+		return 0, pages, nil
+	}
+
+	p := db.newPage()
+	sampled := 0
+	for _, pagb := range pagbs {
+		clear(p.buf)
+		if _, err := db.readPage(pagb, p); err != nil {
+			return 0, 0, err
+		}
+		if err := db.checkPage(pagb, p); err != nil {
+			return 0, 0, err
+		}
+		sampled += int(p.getN()) / 2
+	}
+	return sampled * pages / len(pagbs), pages, nil
+}
+
+// walkLeaves calls fn with the page of each leaf of the subtree of the trie
+// below directory bit dbit, whose pages hold the hashes equal to pagb in
+// their low hbit bits, from left to right. It stops once fn returns false,
+// and reports whether it went through the whole subtree.
+func (db *DBM) walkLeaves(dbit, hbit, pagb int64, fn func(pagb int64) bool) bool {
+	if dbit >= db.maxbno || hbit >= maxDepth || !db.getDBit(dbit) {
+		return fn(pagb)
+	}
+	return db.walkLeaves(2*dbit+1, hbit+1, pagb, fn) &&
+		db.walkLeaves(2*dbit+2, hbit+1, pagb|1<<hbit, fn)
+}
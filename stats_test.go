@@ -0,0 +1,178 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Stats(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "one page", pairs: generatePairs("key", "val", 10)},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t, tt.pairs...)
+			defer teardown(t, dbm)
+
+			got, err := dbm.Stats()
+			if err != nil {
+				t.Fatalf("Stats() error = %v", err)
+			}
+			pag, err := os.Stat(filepath.Join(dir, DBMFile+sdbm.PAGFEXT))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dirfi, err := os.Stat(filepath.Join(dir, DBMFile+sdbm.DIRFEXT))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.PagFileSize != pag.Size() || got.DirFileSize != dirfi.Size() {
+				t.Errorf("Stats() sizes got = %d/%d, want %d/%d", got.PagFileSize, got.DirFileSize, pag.Size(), dirfi.Size())
+			}
+			if want := (pag.Size() + sdbm.PBLKSIZ - 1) / sdbm.PBLKSIZ; got.PageCount != want {
+				t.Errorf("Stats() PageCount got = %d, want %d", got.PageCount, want)
+			}
+			if got.PairCount != int64(len(tt.pairs)) {
+				t.Errorf("Stats() PairCount got = %d, want %d", got.PairCount, len(tt.pairs))
+			}
+			switch {
+			case len(tt.pairs) == 0 && got.AverageFill != 0:
+				t.Errorf("Stats() AverageFill got = %v, want 0", got.AverageFill)
+			case len(tt.pairs) > 0 && (got.AverageFill <= 0 || got.AverageFill > 1):
+				t.Errorf("Stats() AverageFill got = %v, want in (0, 1]", got.AverageFill)
+			}
+		})
+	}
+
+	// the fill of a single page follows from its contents.
+	_, dbm := setup(t, Pair{Key: sdbm.Datum("key"), Val: sdbm.Datum("value")})
+	defer teardown(t, dbm)
+	got, err := dbm.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	want := float64(3*sdbm.SHORTSIZE+len("key")+len("value")) / sdbm.PBLKSIZ
+	if got.AverageFill != want {
+		t.Errorf("Stats() AverageFill got = %v, want %v", got.AverageFill, want)
+	}
+}
+
+func TestDBM_ApproxCount(t *testing.T) {
+	// skewed sends the hashes of the "hot" keys to one sixteenth of the
+	// hash space, so that the trie grows deep there and stays shallow
+	// elsewhere.
+	skewed := func(key []byte) int64 {
+		h := sdbm.Hash(key)
+		if bytes.HasPrefix(key, []byte("hot")) {
+			h = h<<4 | 0xf
+		}
+		return h
+	}
+	tests := []struct {
+		name  string
+		pairs []Pair
+		hash  func(key []byte) int64
+	}{
+		{name: "empty"},
+		{name: "one page", pairs: generatePairs("key", "val", 10)},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+		{name: "more pages", pairs: generatePairs("key", "a longer value", 20000)},
+		{
+			name:  "unbalanced trie",
+			pairs: append(generatePairs("cold", "val", 200), generatePairs("hot", "a longer value", 20000)...),
+			hash:  skewed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbm, err := sdbm.OpenWithOptions(filepath.Join(t.TempDir(), DBMFile), sdbm.Options{
+				Flags:    os.O_RDWR | os.O_CREATE,
+				Mode:     0644,
+				HashFunc: tt.hash,
+			})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, dbm)
+			for _, p := range tt.pairs {
+				if _, err := dbm.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+
+			got, pages, err := dbm.ApproxCount()
+			if err != nil {
+				t.Fatalf("ApproxCount() error = %v", err)
+			}
+			bits, err := dbm.DirBits()
+			if err != nil {
+				t.Fatal(err)
+			}
+			splits := 0
+			for _, b := range bits {
+				if b {
+					splits++
+				}
+			}
+			if pages != splits+1 {
+				t.Errorf("ApproxCount() pages got = %d, want %d", pages, splits+1)
+			}
+			want := len(tt.pairs)
+			if lo, hi := want*2/3, want*3/2; got < lo || got > hi {
+				t.Errorf("ApproxCount() got = %d, want within [%d, %d]", got, lo, hi)
+			}
+		})
+	}
+}
+
+func TestDBM_DiskUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t, tt.pairs...)
+			if err := dbm.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// read-only databases report their sizes too.
+			dbm, err := sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, dbm)
+
+			pagBytes, dirBytes, err := dbm.DiskUsage()
+			if err != nil {
+				t.Fatalf("DiskUsage() error = %v", err)
+			}
+			pag, err := os.Stat(filepath.Join(dir, DBMFile+sdbm.PAGFEXT))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dirfi, err := os.Stat(filepath.Join(dir, DBMFile+sdbm.DIRFEXT))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pagBytes != pag.Size() || dirBytes != dirfi.Size() {
+				t.Errorf("DiskUsage() got = %d/%d, want %d/%d", pagBytes, dirBytes, pag.Size(), dirfi.Size())
+			}
+			if len(tt.pairs) > 0 && (pagBytes == 0 || dirBytes == 0) {
+				t.Errorf("DiskUsage() got = %d/%d, want both files written", pagBytes, dirBytes)
+			}
+		})
+	}
+}
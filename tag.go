@@ -0,0 +1,38 @@
+package sdbm
+
+// maxTagLen is the longest tag StoreTagged accepts; its length is stored in one byte.
+const maxTagLen = 255
+
+// StoreTagged stores val under key together with a short tag, such as a
+// content type, as Store does with flags. The stored value is framed as a
+// one-byte tag length, the tag and then val, and the frame counts against
+// PAIRMAX. An empty tag is allowed; a tag longer than 255 bytes returns
+// ErrInvalidArgument. Values stored this way must be read back with FetchTagged.
+func (db *DBM) StoreTagged(key, val Datum, tag string, flags StoreFlags) (bool, error) {
+	if len(tag) > maxTagLen {
+		return false, ErrInvalidArgument
+	}
+	framed := make(Datum, 1+len(tag)+val.Size())
+	framed[0] = byte(len(tag))
+	copy(framed[1:], tag)
+	copy(framed[1+len(tag):], val)
+	return db.Store(key, framed, flags)
+}
+
+// FetchTagged retrieves the value and tag stored under key by StoreTagged.
+// It reports false if the key is not present, and returns ErrInvalidArgument
+// if the stored value is too short to carry its tag.
+func (db *DBM) FetchTagged(key Datum) (Datum, string, bool, error) {
+	framed, err := db.Fetch(key)
+	if err != nil {
+		return Nullitem, "", false, err
+	}
+	if framed == nil {
+		return Nullitem, "", false, nil
+	}
+	if framed.Size() < 1 || framed.Size() < 1+int(framed[0]) {
+		return Nullitem, "", false, ErrInvalidArgument
+	}
+	n := 1 + int(framed[0])
+	return framed[n:], string(framed[1:n]), true, nil
+}
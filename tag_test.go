@@ -0,0 +1,50 @@
+package sdbm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_FetchTagged(t *testing.T) {
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name    string
+		key     string
+		val     string
+		tag     string
+		wantErr error
+	}{
+		{name: "with tag", key: "doc", val: `{"a":1}`, tag: "application/json"},
+		{name: "without tag", key: "plain", val: "text", tag: ""},
+		{name: "empty value", key: "empty", val: "", tag: "text/plain"},
+		{name: "tag too long", key: "long", val: "v", tag: strings.Repeat("t", 256), wantErr: sdbm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dbm.StoreTagged(sdbm.Datum(tt.key), sdbm.Datum(tt.val), tt.tag, sdbm.StoreREPLACE)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StoreTagged() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			val, tag, ok, err := dbm.FetchTagged(sdbm.Datum(tt.key))
+			if err != nil {
+				t.Fatalf("FetchTagged() error = %v", err)
+			}
+			if !ok || val.String() != tt.val || tag != tt.tag {
+				t.Errorf("FetchTagged() got = %q, %q, %v, want %q, %q, true", val, tag, ok, tt.val, tt.tag)
+			}
+		})
+	}
+
+	_, _, ok, err := dbm.FetchTagged(sdbm.Datum("missing"))
+	if err != nil || ok {
+		t.Errorf("FetchTagged() got = %v, %v, want a miss", ok, err)
+	}
+}
@@ -0,0 +1,344 @@
+package sdbm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// txSuffix is appended to the name of a .pag file to name the intent log
+// written for it while a TxGroup commits.
+const txSuffix = ".tx"
+
+var txMagic = []byte("sdbmtx1\n")
+
+// testHookTx, if set, is called at each step of TxGroup.Commit so tests can
+// simulate a crash by returning an error.
+var testHookTx func(step string, i int) error
+
+// TxGroup stages writes to several databases and commits them so that
+// either all of them or none take effect, even across a crash.
+//
+// Commit runs a two-phase protocol. In the prepare phase it writes the
+// staged operations of every database to an intent log next to the
+// database's .pag file and syncs it. It then atomically creates the marker
+// file, which is the commit point, applies the operations, syncs the
+// databases, and removes the intent logs and finally the marker.
+//
+// After a crash, RecoverTxGroup must be called with the same marker and all
+// participating databases before they are used. If the marker exists the
+// commit is rolled forward by replaying the intent logs; otherwise any
+// intent logs are discarded, rolling the commit back.
+//
+// This is best-effort atomicity, not full transactions: readers may see a
+// partially applied commit until it finishes, the databases are not locked
+// against other writers, and durability relies on fsync and on rename
+// being atomic on the file system holding the marker.
+type TxGroup struct {
+	marker string
+	dbs    []*DBM
+	ops    map[*DBM][]batchOp
+}
+
+// NewTxGroup returns an empty TxGroup that uses marker as its commit marker file.
+func NewTxGroup(marker string) *TxGroup {
+	return &TxGroup{marker: marker, ops: make(map[*DBM][]batchOp)}
+}
+
+func (g *TxGroup) add(db *DBM, op batchOp) {
+	if _, ok := g.ops[db]; !ok {
+		g.dbs = append(g.dbs, db)
+	}
+	g.ops[db] = append(g.ops[db], op)
+}
+
+// Put stages storing val under key in db, replacing any existing value.
+// The key and value are copied.
+func (g *TxGroup) Put(db *DBM, key, val Datum) {
+	g.add(db, batchOp{key: cloneDatum(key), val: cloneDatum(val), flags: StoreREPLACE})
+}
+
+// Delete stages removing key from db. The key is copied.
+func (g *TxGroup) Delete(db *DBM, key Datum) {
+	g.add(db, batchOp{key: cloneDatum(key), del: true})
+}
+
+// Commit applies every staged operation as described on TxGroup. The staged
+// operations are checked first, as Store and Delete would check them, and a
+// key or pair they would reject fails Commit before anything is written. The
+// staged operations are discarded once Commit succeeds.
+func (g *TxGroup) Commit() error {
+	// an operation that cannot be applied must fail here, before the
+	// commit point, or recovery would retry it forever.
+	for _, db := range g.dbs {
+		if db.rdonly {
+			return ErrDBMRDOnly
+		}
+		for _, op := range g.ops[db] {
+			var err error
+			if op.del {
+				err = db.checkKey(db.normKey(op.key))
+			} else {
+				err = db.checkPair(op.key, op.val)
+			}
+			if err != nil {
+				return fmt.Errorf("sdbm: staged operation on key %q: %w", op.key, err)
+			}
+		}
+	}
+
+	// prepare
+	var logs []string
+	for i, db := range g.dbs {
+		path := db.pagf.Name() + txSuffix
+		if err := writeIntentLog(path, g.ops[db]); err != nil {
+			return err
+		}
+		logs = append(logs, path)
+		if err := g.hook("prepare", i); err != nil {
+			return err
+		}
+	}
+
+	// commit point
+	if err := writeSynced(g.marker, []byte(strings.Join(logs, "\n"))); err != nil {
+		return err
+	}
+	if err := g.hook("commit", 0); err != nil {
+		return err
+	}
+
+	for _, db := range g.dbs {
+		if err := applyIntents(db, g.ops[db]); err != nil {
+			return err
+		}
+	}
+	for _, path := range logs {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(g.marker); err != nil {
+		return err
+	}
+
+	g.dbs = nil
+	clear(g.ops)
+	return nil
+}
+
+func (g *TxGroup) hook(step string, i int) error {
+	if testHookTx == nil {
+		return nil
+	}
+	return testHookTx(step, i)
+}
+
+// RecoverTxGroup finishes or undoes a TxGroup commit with the given marker
+// that was interrupted by a crash. dbs must include every database that
+// took part in the commit.
+func RecoverTxGroup(marker string, dbs ...*DBM) error {
+	_, err := os.Stat(marker)
+	committed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, db := range dbs {
+		path := db.pagf.Name() + txSuffix
+		ops, err := readIntentLog(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil && committed {
+			return err
+		}
+		if committed {
+			if err := applyIntents(db, ops); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	if committed {
+		return os.Remove(marker)
+	}
+	return nil
+}
+
+// applyIntents applies ops to db and syncs it. Applying the same operations
+// twice leaves the same result, which makes replay safe.
+func applyIntents(db *DBM, ops []batchOp) error {
+	for _, op := range ops {
+		var err error
+		if op.del {
+			_, err = db.Delete(op.key)
+		} else {
+			_, err = db.Store(op.key, op.val, StoreREPLACE)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return db.Sync()
+}
+
+// writeSynced writes data to path through a temporary file that is synced
+// and renamed into place, so path either does not exist or is complete.
+func writeSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs the directory dir, so that a file renamed into it survives
+// a crash. Windows cannot sync a directory, and its renames need no sync.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
+
+// Operation bytes of the records in intent logs and write-ahead logs.
+const (
+	opPut       = 0 // a put that replaces an existing value, as StoreREPLACE does
+	opDelete    = 1
+	opPutNoDup  = 2 // a put that keeps an existing value, as StoreSEEDUPS does
+	opPutInsert = 3 // a put that keeps an existing value, as StoreINSERT does
+	opPutDup    = 4 // a put that adds a pair beside existing ones, as no flags do
+)
+
+// opKinds maps the flags of a put to its operation byte, and back.
+var opKinds = map[StoreFlags]byte{
+	StoreREPLACE: opPut,
+	StoreSEEDUPS: opPutNoDup,
+	StoreINSERT:  opPutInsert,
+	0:            opPutDup,
+}
+
+// appendOp appends the record for op to buf: an operation byte followed by
+// the length-prefixed key and value.
+func appendOp(buf *bytes.Buffer, op batchOp) {
+	kind := opKinds[op.flags]
+	if op.del {
+		kind = opDelete
+	}
+	buf.WriteByte(kind)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(op.key.Size()))
+	buf.Write(op.key)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(op.val.Size()))
+	buf.Write(op.val)
+}
+
+// readOp reads a record written by appendOp. It returns io.EOF if r ends
+// before the record, and io.ErrUnexpectedEOF if r ends inside it.
+func readOp(r *bufio.Reader) (batchOp, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return batchOp{}, err
+	}
+	readDatum := func() (Datum, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, noEOF(err)
+		}
+		d := make(Datum, n)
+		_, err := io.ReadFull(r, d)
+		return d, noEOF(err)
+	}
+	key, err := readDatum()
+	if err != nil {
+		return batchOp{}, err
+	}
+	val, err := readDatum()
+	if err != nil {
+		return batchOp{}, err
+	}
+	if kind == opDelete {
+		return batchOp{key: key, del: true}, nil
+	}
+	for flags, k := range opKinds {
+		if k == kind {
+			return batchOp{key: key, val: val, flags: flags}, nil
+		}
+	}
+	return batchOp{}, fmt.Errorf("sdbm: unknown operation %d", kind)
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writeIntentLog writes ops to path as a sequence of records written by appendOp.
+func writeIntentLog(path string, ops []batchOp) error {
+	var buf bytes.Buffer
+	buf.Write(txMagic)
+	for _, op := range ops {
+		appendOp(&buf, op)
+	}
+	return writeSynced(path, buf.Bytes())
+}
+
+func readIntentLog(path string) ([]batchOp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(txMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, txMagic) {
+		return nil, fmt.Errorf("sdbm: bad intent log %s", path)
+	}
+	var ops []batchOp
+	for {
+		op, err := readOp(r)
+		if errors.Is(err, io.EOF) {
+			return ops, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("sdbm: truncated intent log %s: %w", path, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+}
@@ -0,0 +1,150 @@
+package sdbm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestTxGroup_Commit(t *testing.T) {
+	dir, db1 := setup(t, generatePairs("key", "val", 10)...)
+	defer teardown(t, db1)
+	_, db2 := setup(t)
+	defer teardown(t, db2)
+
+	g := sdbm.NewTxGroup(filepath.Join(dir, "tx.marker"))
+	g.Put(db1, sdbm.Datum("a"), sdbm.Datum("1"))
+	g.Delete(db1, sdbm.Datum("key1"))
+	g.Put(db2, sdbm.Datum("b"), sdbm.Datum("2"))
+	if err := g.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	assertFetch(t, db1, "a", sdbm.Datum("1"))
+	assertFetch(t, db1, "key1", sdbm.Nullitem)
+	assertFetch(t, db2, "b", sdbm.Datum("2"))
+}
+
+func TestTxGroup_Commit_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     sdbm.Datum
+		val     sdbm.Datum
+		del     bool
+		wantErr error
+	}{
+		{name: "key too long", key: make(sdbm.Datum, sdbm.PAIRMAX+1), val: sdbm.Datum("v"), wantErr: sdbm.ErrKeyTooLong},
+		{name: "delete of a key too long", key: make(sdbm.Datum, sdbm.PAIRMAX+1), del: true, wantErr: sdbm.ErrKeyTooLong},
+		{name: "value too big", key: sdbm.Datum("b"), val: make(sdbm.Datum, sdbm.PAIRMAX), wantErr: sdbm.ErrValueTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, db1 := setup(t)
+			defer teardown(t, db1)
+			dir2, db2 := setup(t)
+			defer teardown(t, db2)
+			marker := filepath.Join(dir, "tx.marker")
+
+			g := sdbm.NewTxGroup(marker)
+			g.Put(db1, sdbm.Datum("a"), sdbm.Datum("1"))
+			if tt.del {
+				g.Delete(db2, tt.key)
+			} else {
+				g.Put(db2, tt.key, tt.val)
+			}
+			if err := g.Commit(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Commit() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, path := range []string{
+				marker,
+				filepath.Join(dir, DBMFile) + sdbm.PAGFEXT + ".tx",
+				filepath.Join(dir2, DBMFile) + sdbm.PAGFEXT + ".tx",
+			} {
+				if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("Commit() left %s behind: %v", path, err)
+				}
+			}
+			assertFetch(t, db1, "a", sdbm.Nullitem)
+		})
+	}
+
+	// a value too big for a page is accepted when it overflows.
+	dir, db := setup(t)
+	defer teardown(t, db)
+	ovf := openOverflow(t, filepath.Join(dir, "ovf"))
+	defer teardown(t, ovf)
+	big := make(sdbm.Datum, sdbm.PAIRMAX)
+	g := sdbm.NewTxGroup(filepath.Join(dir, "tx.marker"))
+	g.Put(ovf, sdbm.Datum("b"), big)
+	if err := g.Commit(); err != nil {
+		t.Fatalf("Commit() with an overflow db error = %v", err)
+	}
+	assertFetch(t, ovf, "b", big)
+}
+
+func TestTxGroup_Recover(t *testing.T) {
+	crash := errors.New("crash")
+	tests := []struct {
+		name      string
+		step      string
+		committed bool
+	}{
+		{name: "crash between prepares rolls back", step: "prepare", committed: false},
+		{name: "crash after the commit point rolls forward", step: "commit", committed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, db1 := setup(t)
+			defer teardown(t, db1)
+			_, db2 := setup(t)
+			defer teardown(t, db2)
+			marker := filepath.Join(dir, "tx.marker")
+
+			sdbm.SetTxHook(func(step string, i int) error {
+				if step == tt.step && i == 0 {
+					return crash
+				}
+				return nil
+			})
+			g := sdbm.NewTxGroup(marker)
+			g.Put(db1, sdbm.Datum("a"), sdbm.Datum("1"))
+			g.Put(db2, sdbm.Datum("b"), sdbm.Datum("2"))
+			err := g.Commit()
+			sdbm.SetTxHook(nil)
+			if !errors.Is(err, crash) {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, crash)
+			}
+
+			if err := sdbm.RecoverTxGroup(marker, db1, db2); err != nil {
+				t.Fatalf("RecoverTxGroup() error = %v", err)
+			}
+			wantA, wantB := sdbm.Nullitem, sdbm.Nullitem
+			if tt.committed {
+				wantA, wantB = sdbm.Datum("1"), sdbm.Datum("2")
+			}
+			assertFetch(t, db1, "a", wantA)
+			assertFetch(t, db2, "b", wantB)
+
+			// recovering again is a no-op.
+			if err := sdbm.RecoverTxGroup(marker, db1, db2); err != nil {
+				t.Fatalf("RecoverTxGroup() error = %v", err)
+			}
+			assertFetch(t, db1, "a", wantA)
+		})
+	}
+}
+
+func assertFetch(t *testing.T, db *sdbm.DBM, key string, want sdbm.Datum) {
+	t.Helper()
+	got, err := db.Fetch(sdbm.Datum(key))
+	if err != nil {
+		t.Fatalf("Fetch(%s) error = %v", key, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch(%s) got = %v, want %v", key, got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package sdbm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts values of type T to and from the bytes stored in a database.
+type Codec[T any] struct {
+	Marshal   func(v T) (Datum, error)
+	Unmarshal func(d Datum) (T, error)
+}
+
+// JSONCodec returns a Codec storing values of type T as JSON, with
+// encoding/json.
+func JSONCodec[T any]() Codec[T] {
+	return Codec[T]{
+		Marshal: func(v T) (Datum, error) { return json.Marshal(v) },
+		Unmarshal: func(d Datum) (T, error) {
+			var v T
+			err := json.Unmarshal(d, &v)
+			return v, err
+		},
+	}
+}
+
+// TypedDBM wraps a DBM with codecs for its keys and values, so callers work
+// with their own types instead of Datums. Every key and value passes through
+// the codecs, which centralizes serialization in one place.
+type TypedDBM[K, V any] struct {
+	db   *DBM
+	keys Codec[K]
+	vals Codec[V]
+}
+
+// NewTypedDBM returns a TypedDBM storing pairs in db, converting keys with
+// keys and values with vals.
+func NewTypedDBM[K, V any](db *DBM, keys Codec[K], vals Codec[V]) *TypedDBM[K, V] {
+	return &TypedDBM[K, V]{db: db, keys: keys, vals: vals}
+}
+
+// Get returns the value stored under key and reports whether there was one.
+func (t *TypedDBM[K, V]) Get(key K) (V, bool, error) {
+	var zero V
+	k, err := t.keys.Marshal(key)
+	if err != nil {
+		return zero, false, err
+	}
+	d, err := t.db.Fetch(k)
+	if err != nil || d == nil {
+		return zero, false, err
+	}
+	v, err := t.vals.Unmarshal(cloneDatum(d))
+	if err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// Set stores val under key, replacing any existing value. Unless the
+// database stores large values elsewhere, a pair whose marshaled key and
+// value together exceed PairMax fails with ErrValueTooBig.
+func (t *TypedDBM[K, V]) Set(key K, val V) error {
+	k, err := t.keys.Marshal(key)
+	if err != nil {
+		return err
+	}
+	v, err := t.vals.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if limit := t.db.PairMax(); t.db.blobs == nil && t.db.ovf == nil && k.Size()+v.Size() > limit {
+		return fmt.Errorf("%w: marshaled key (%d bytes) and value (%d bytes) exceed PairMax (%d bytes)",
+			ErrValueTooBig, k.Size(), v.Size(), limit)
+	}
+	_, err = t.db.Store(k, v, StoreREPLACE)
+	return err
+}
+
+// Put is Set, under the name that pairs with Get in other typed stores.
+func (t *TypedDBM[K, V]) Put(key K, val V) error {
+	return t.Set(key, val)
+}
+
+// Delete removes key and reports whether it was present.
+func (t *TypedDBM[K, V]) Delete(key K) (bool, error) {
+	k, err := t.keys.Marshal(key)
+	if err != nil {
+		return false, err
+	}
+	return t.db.Delete(k)
+}
+
+// Range calls fn for every pair in the database, in page order, stopping at
+// the first error returned by fn or by a codec, which Range then returns.
+func (t *TypedDBM[K, V]) Range(fn func(key K, val V) error) error {
+	return t.db.ForEachKey(func(k Datum) error {
+		key, err := t.keys.Unmarshal(k)
+		if err != nil {
+			return err
+		}
+		d, err := t.db.Fetch(k)
+		if err != nil {
+			return err
+		}
+		val, err := t.vals.Unmarshal(cloneDatum(d))
+		if err != nil {
+			return err
+		}
+		return fn(key, val)
+	})
+}
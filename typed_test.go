@@ -0,0 +1,174 @@
+package sdbm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+var stringCodec = sdbm.Codec[string]{
+	Marshal:   func(s string) (sdbm.Datum, error) { return sdbm.Datum(s), nil },
+	Unmarshal: func(d sdbm.Datum) (string, error) { return string(d), nil },
+}
+
+var userCodec = sdbm.Codec[user]{
+	Marshal: func(u user) (sdbm.Datum, error) { return json.Marshal(u) },
+	Unmarshal: func(d sdbm.Datum) (user, error) {
+		var u user
+		err := json.Unmarshal(d, &u)
+		return u, err
+	},
+}
+
+func TestTypedDBM(t *testing.T) {
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+	users := sdbm.NewTypedDBM(dbm, stringCodec, userCodec)
+
+	want := map[string]user{
+		"alice": {Name: "Alice", Age: 30},
+		"bob":   {Name: "Bob", Age: 25},
+		"carol": {Name: "Carol", Age: 41},
+	}
+	for k, u := range want {
+		if err := users.Set(k, u); err != nil {
+			t.Fatalf("Set(%s) error = %v", k, err)
+		}
+	}
+	if err := users.Set("bob", user{Name: "Robert", Age: 26}); err != nil {
+		t.Fatalf("Set(bob) error = %v", err)
+	}
+	want["bob"] = user{Name: "Robert", Age: 26}
+
+	tests := []struct {
+		name   string
+		key    string
+		want   user
+		wantOK bool
+	}{
+		{name: "stored", key: "alice", want: want["alice"], wantOK: true},
+		{name: "replaced", key: "bob", want: want["bob"], wantOK: true},
+		{name: "absent", key: "dave"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := users.Get(tt.key)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Get() got = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	got := make(map[string]user)
+	err := users.Range(func(k string, u user) error {
+		got[k] = u
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("Range() pairs got = %d, want %d", len(got), len(want))
+	}
+	for k, u := range want {
+		if got[k] != u {
+			t.Errorf("Range() %s got = %v, want %v", k, got[k], u)
+		}
+	}
+
+	if ok, err := users.Delete("alice"); err != nil || !ok {
+		t.Errorf("Delete() got = %v, %v, want true, nil", ok, err)
+	}
+	if _, ok, err := users.Get("alice"); err != nil || ok {
+		t.Errorf("Get() after Delete got = %v, %v, want false, nil", ok, err)
+	}
+
+	err = users.Set("big", user{Name: strings.Repeat("x", sdbm.PAIRMAX)})
+	if !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Set() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+}
+
+func TestTypedDBM_Codecs(t *testing.T) {
+	tests := []struct {
+		name string
+		keys sdbm.Codec[int]
+		vals sdbm.Codec[user]
+	}{
+		{name: "JSON", keys: sdbm.JSONCodec[int](), vals: sdbm.JSONCodec[user]()},
+		{name: "gob", keys: sdbm.GobCodec[int](), vals: sdbm.GobCodec[user]()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dbm := setup(t)
+			defer teardown(t, dbm)
+			users := sdbm.NewTypedDBM(dbm, tt.keys, tt.vals)
+
+			want := user{Name: "Alice", Age: 30}
+			if err := users.Put(1, want); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if got, ok, err := users.Get(1); err != nil || !ok || got != want {
+				t.Errorf("Get() got = %v, %v, %v, want %v, true", got, ok, err, want)
+			}
+			if _, ok, err := users.Get(2); err != nil || ok {
+				t.Errorf("Get() of an absent key got = %v, %v, want false", ok, err)
+			}
+			if ok, err := users.Delete(1); err != nil || !ok {
+				t.Errorf("Delete() got = %v, %v, want true", ok, err)
+			}
+		})
+	}
+}
+
+func ExampleTypedDBM() {
+	type account struct {
+		Owner   string
+		Balance int
+	}
+
+	dir, err := os.MkdirTemp("", "sdbm")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sdbm.Open(filepath.Join(dir, "accounts"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	accounts := sdbm.NewTypedDBM(db, sdbm.JSONCodec[string](), sdbm.JSONCodec[account]())
+	if err := accounts.Put("acc-1", account{Owner: "Alice", Balance: 100}); err != nil {
+		log.Fatal(err)
+	}
+	acc, found, err := accounts.Get("acc-1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(acc.Owner, acc.Balance, found)
+
+	_, found, err = accounts.Get("acc-2")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(found)
+	// Output:
+	// Alice 100 true
+	// false
+}
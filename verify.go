@@ -0,0 +1,113 @@
+package sdbm
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMisplacedKey indicates that a key is stored on a page other than the
+// one the directory maps its hash to, so Fetch cannot find it.
+var ErrMisplacedKey = errors.New("key on the wrong page")
+
+// Verify checks the whole .pag file against the directory, the offline
+// equivalent of fsck. Every page must match its checksum, in a database with
+// FeatureChecksums, and pass ChkPage, which also ensures that no value
+// starts beyond its key; every key on a valid page must hash to that page
+// given the directory bits. Verify does not stop at the first problem: it
+// returns all of them joined with errors.Join, each naming its page, or nil
+// if there are none. Read errors stop the scan and are returned as they are.
+func (db *DBM) Verify() error {
+	return db.VerifyContext(context.Background())
+}
+
+// VerifyContext is Verify returning ctx.Err() once ctx is done, checked
+// between pages, rather than the problems found so far.
+func (db *DBM) VerifyContext(ctx context.Context) error {
+	if err := db.flush(); err != nil {
+		return err
+	}
+	var problems []error
+	p := db.newPage()
+	for pagb := int64(0); ; pagb++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, err := db.readPage(pagb, p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := db.checkPage(pagb, p); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		p.forEachPair(func(key, _ Datum) bool {
+			if want := db.pageOf(db.exHash(key)); want != pagb {
+				problems = append(problems, fmt.Errorf("%w: %q on page %d, want page %d", ErrMisplacedKey, key, pagb, want))
+			}
+			return true
+		})
+	}
+	return errors.Join(problems...)
+}
+
+// DumpPage writes a human-readable dump of page pageNo of the .pag file to
+// w: its entry count, the outcome of the checks Verify makes, and for each
+// pair the offsets of the key and the value followed by their bytes, in
+// hex and printable form. The dump follows the offset table only as far as
+// it is consistent, so it also shows where a page fails ChkPage. A page
+// beyond the end of the file is dumped as the empty page it reads as.
+// DumpPage does not disturb the page cache or the cursor used by FirstKey
+// and NextKey.
+func (db *DBM) DumpPage(pageNo int64, w io.Writer) error {
+	if pageNo < 0 {
+		return fmt.Errorf("%w: page %d", ErrInvalidArgument, pageNo)
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	p := db.newPage()
+	ok, err := db.readPage(pageNo, p)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "page %d", pageNo)
+	if !ok {
+		fmt.Fprint(bw, " (beyond the end of the file)")
+	}
+	n := int(p.getN())
+	fmt.Fprintf(bw, "\nentries: %d\n", n)
+	if err := db.checkPage(pageNo, p); err != nil {
+		fmt.Fprintf(bw, "check: %v\n", err)
+	} else {
+		fmt.Fprintf(bw, "check: ok\nfree: %d bytes\n", p.free())
+	}
+
+	off := len(p.buf)
+	for i := 1; i < n; i += 2 {
+		if (i+2)*SHORTSIZE > len(p.buf) {
+			fmt.Fprintf(bw, "pair %d: offset table runs past the page\n", (i+1)/2)
+			break
+		}
+		keyOff, valOff := int(p.getIno(i)), int(p.getIno(i+1))
+		fmt.Fprintf(bw, "pair %d: key [%d, %d) value [%d, %d)\n", (i+1)/2, keyOff, off, valOff, keyOff)
+		if keyOff > off || valOff > keyOff || valOff < (n+1)*SHORTSIZE {
+			fmt.Fprint(bw, "offsets out of order, dump stopped\n")
+			break
+		}
+		fmt.Fprintf(bw, "key:\n%svalue:\n%s", hex.Dump(p.buf[keyOff:off]), hex.Dump(p.buf[valOff:keyOff]))
+		off = valOff
+	}
+	if n%2 != 0 {
+		fmt.Fprint(bw, "odd entry count: last offset has no pair\n")
+	}
+	return bw.Flush()
+}
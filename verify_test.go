@@ -0,0 +1,218 @@
+package sdbm_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_Verify(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{name: "empty"},
+		{name: "one page", pairs: generatePairs("key", "val", 10)},
+		{name: "many pages", pairs: generatePairs("key", "val", 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dbm := setup(t, tt.pairs...)
+			defer teardown(t, dbm)
+
+			if err := dbm.Verify(); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDBM_Verify_Corrupt(t *testing.T) {
+	dir, dbm := setup(t, generatePairs("key", "val", 3000)...)
+	pages, err := dbm.KeyPageMap()
+	if err != nil {
+		t.Fatalf("KeyPageMap() error = %v", err)
+	}
+	var counts [3]int
+	for _, pagb := range pages {
+		if pagb < int64(len(counts)) {
+			counts[pagb]++
+		}
+	}
+	if counts[0] == 0 || counts[1] == 0 || counts[2] == 0 {
+		t.Fatalf("pairs on pages 0-2 got = %v, want all non-zero", counts)
+	}
+	if err := dbm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// swap pages 0 and 1, so that every key on them is misplaced, and give
+	// page 2 an entry count no page can hold.
+	f, err := os.OpenFile(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p0, p1 [sdbm.PBLKSIZ]byte
+	if _, err := f.ReadAt(p0[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadAt(p1[:], sdbm.PBLKSIZ); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(p1[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(p0[:], sdbm.PBLKSIZ); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(binary.LittleEndian.AppendUint16(nil, 0xfffe), 2*sdbm.PBLKSIZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dbm, err = sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+	err = dbm.Verify()
+	if !errors.Is(err, sdbm.ErrMisplacedKey) || !errors.Is(err, sdbm.ErrInvalidPage) {
+		t.Fatalf("Verify() error = %v, want ErrMisplacedKey and ErrInvalidPage", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("Verify() error = %T, want errors joined", err)
+	}
+	if got, want := len(joined.Unwrap()), counts[0]+counts[1]+1; got != want {
+		t.Errorf("Verify() reported %d problems, want %d", got, want)
+	}
+}
+
+func TestDBM_DumpPage(t *testing.T) {
+	dir, dbm := setup(t, Pair{Key: sdbm.Datum("key1"), Val: sdbm.Datum("val1")}, Pair{Key: sdbm.Datum("key2"), Val: sdbm.Datum("val2")})
+	if err := dbm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// point the key of the second pair past the first pair.
+	f, err := os.OpenFile(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(binary.LittleEndian.AppendUint16(nil, sdbm.PBLKSIZ), 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dbm, err = sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer teardown(t, dbm)
+
+	tests := []struct {
+		name    string
+		page    int64
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "corrupt page",
+			page: 0,
+			want: []string{
+				"page 0\n", "entries: 4\n", "check: page 0: invalid page: entry 3: key offset 1024 beyond the previous pair at 1016\n",
+				"pair 1: key [1020, 1024) value [1016, 1020)\n", "|key1|", "|val1|",
+				"pair 2: key [1024, 1016)", "offsets out of order",
+			},
+		},
+		{
+			name: "beyond the end of the file",
+			page: 1,
+			want: []string{"page 1 (beyond the end of the file)\n", "entries: 0\n", "check: ok\n", "free: 1022 bytes\n"},
+		},
+		{name: "negative page", page: -1, wantErr: sdbm.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			err := dbm.DumpPage(tt.page, &sb)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DumpPage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(sb.String(), want) {
+					t.Errorf("DumpPage() got:\n%s\nwant it to contain %q", sb.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDBM_Verify_Problems(t *testing.T) {
+	// page 0 holds key1 at [1020, 1024) and val1 at [1016, 1020).
+	tests := []struct {
+		name   string
+		offset int64
+		patch  uint16
+		want   string
+	}{
+		{name: "entry count too large", offset: 0, patch: 0xfffe, want: "page 0: invalid page: entry count 65534, a page holds at most 511"},
+		{name: "odd entry count", offset: 0, patch: 3, want: "page 0: invalid page: odd entry count 3"},
+		{name: "key beyond the block", offset: 2, patch: 2000, want: "page 0: invalid page: entry 1: key offset 2000 beyond the block size 1024"},
+		{name: "value beyond its key", offset: 4, patch: 1022, want: "page 0: invalid page: entry 2: value offset 1022 beyond its key offset 1020"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dbm := setup(t, Pair{Key: sdbm.Datum("key1"), Val: sdbm.Datum("val1")})
+			if err := dbm.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(filepath.Join(dir, DBMFile+sdbm.PAGFEXT), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt(binary.LittleEndian.AppendUint16(nil, tt.patch), tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			dbm, err = sdbm.Open(filepath.Join(dir, DBMFile), os.O_RDONLY, 0)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer teardown(t, dbm)
+			err = dbm.Verify()
+			if !errors.Is(err, sdbm.ErrInvalidPage) || err.Error() != tt.want {
+				t.Errorf("Verify() error = %v, want %q", err, tt.want)
+			}
+			if _, err := dbm.Fetch(sdbm.Datum("key1")); !errors.Is(err, sdbm.ErrInvalidPage) || err.Error() != tt.want {
+				t.Errorf("Fetch() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBM_VerifyContext(t *testing.T) {
+	_, dbm := setup(t, generatePairs("key", "val", 1000)...)
+	defer teardown(t, dbm)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	if err := dbm.VerifyContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("VerifyContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := dbm.VerifyContext(context.Background()); err != nil {
+		t.Errorf("VerifyContext() error = %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package sdbm
+
+import "encoding/binary"
+
+// versionSize is the size of the version prefix written by StoreVersion.
+const versionSize = 8
+
+// StoreVersion stores val under key together with a version number, as
+// Store does with flags. The version is kept as an 8-byte little-endian
+// prefix of the stored value and counts against PAIRMAX. Values stored this
+// way must be read back with FetchVersion or FetchIfNewer.
+func (db *DBM) StoreVersion(key, val Datum, version uint64, flags StoreFlags) (bool, error) {
+	framed := make(Datum, versionSize+val.Size())
+	binary.LittleEndian.PutUint64(framed, version)
+	copy(framed[versionSize:], val)
+	return db.Store(key, framed, flags)
+}
+
+// FetchVersion retrieves the value and version stored under key by StoreVersion.
+// It reports false if the key is not present, and returns ErrInvalidArgument
+// if the stored value is too short to carry a version.
+func (db *DBM) FetchVersion(key Datum) (Datum, uint64, bool, error) {
+	framed, err := db.Fetch(key)
+	if err != nil {
+		return Nullitem, 0, false, err
+	}
+	if framed == nil {
+		return Nullitem, 0, false, nil
+	}
+	if framed.Size() < versionSize {
+		return Nullitem, 0, false, ErrInvalidArgument
+	}
+	return framed[versionSize:], binary.LittleEndian.Uint64(framed), true, nil
+}
+
+// FetchIfNewer retrieves the value stored under key by StoreVersion only if
+// its version is at least version. Otherwise it reports a miss, so that a
+// cache caller refetches the entry from its source of truth instead of
+// serving a stale one.
+func (db *DBM) FetchIfNewer(key Datum, version uint64) (Datum, bool, error) {
+	val, stored, ok, err := db.FetchVersion(key)
+	if err != nil || !ok || stored < version {
+		return Nullitem, false, err
+	}
+	return val, true, nil
+}
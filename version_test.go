@@ -0,0 +1,44 @@
+package sdbm_test
+
+import (
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func TestDBM_FetchIfNewer(t *testing.T) {
+	_, dbm := setup(t)
+	defer teardown(t, dbm)
+
+	key := sdbm.Datum("key")
+	if _, err := dbm.StoreVersion(key, sdbm.Datum("val"), 5, sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("StoreVersion() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		version uint64
+		want    sdbm.Datum
+		wantOK  bool
+	}{
+		{name: "stored version is newer", version: 4, want: sdbm.Datum("val"), wantOK: true},
+		{name: "stored version is equal", version: 5, want: sdbm.Datum("val"), wantOK: true},
+		{name: "stored version is older", version: 6, want: sdbm.Nullitem, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := dbm.FetchIfNewer(key, tt.version)
+			if err != nil {
+				t.Fatalf("FetchIfNewer() error = %v", err)
+			}
+			if ok != tt.wantOK || got.String() != tt.want.String() {
+				t.Errorf("FetchIfNewer() got = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	_, ok, err := dbm.FetchIfNewer(sdbm.Datum("missing"), 0)
+	if err != nil || ok {
+		t.Errorf("FetchIfNewer() got = %v, %v, want a miss", ok, err)
+	}
+}
@@ -0,0 +1,163 @@
+package sdbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// walSuffix is appended to the database name to name the write-ahead log
+// used by Options.WAL.
+const walSuffix = ".wal"
+
+var walMagic = []byte("sdbmwal1\n")
+
+// openWAL replays the write-ahead log of the database named file, if it
+// holds operations left by a crash, checkpoints them, and opens the log for
+// appending. A read-only database cannot replay and refuses to open while
+// operations are pending.
+func (db *DBM) openWAL(file string) error {
+	path := file + walSuffix
+	ops, err := readWAL(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if db.rdonly {
+		if len(ops) > 0 {
+			return fmt.Errorf("%w: %d operations pending in %s", ErrDBMRDOnly, len(ops), path)
+		}
+		return nil
+	}
+	if err := db.replayWAL(ops); err != nil {
+		return err
+	}
+
+	f, err := openFile(path, os.O_RDWR|os.O_CREATE, db.opts.Mode)
+	if err != nil {
+		return err
+	}
+	db.wal = f
+	// the replayed operations are on the pages; make them durable and
+	// start an empty log.
+	return db.Sync()
+}
+
+// replayWAL applies ops to the database with the flags they were made
+// with. Replaying an operation that had already reached the pages is
+// harmless: a put with StoreREPLACE, StoreSEEDUPS or StoreINSERT and a
+// delete leave the same result twice, and a put that adds a duplicate pair
+// is skipped if the exact pair is already stored. Two adds of the same pair
+// since the last checkpoint therefore leave a single pair when replayed.
+func (db *DBM) replayWAL(ops []batchOp) error {
+	for _, op := range ops {
+		var err error
+		switch {
+		case op.del:
+			_, err = db.Delete(op.key)
+		case op.flags == 0:
+			var stored bool
+			if stored, err = db.hasPair(op.key, op.val); err == nil && !stored {
+				_, err = db.Store(op.key, op.val, 0)
+			}
+		default:
+			_, err = db.Store(op.key, op.val, op.flags)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasPair reports whether key is stored with the value val, among any
+// duplicates of key.
+func (db *DBM) hasPair(key, val Datum) (bool, error) {
+	vals, err := db.FetchAll(key)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(vals, func(v Datum) bool { return bytes.Equal(v, val) }), nil
+}
+
+// readWAL returns the operations recorded in the write-ahead log at path.
+// A record cut short by a crash was never applied and is dropped.
+func readWAL(path string) ([]batchOp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(walMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		// an empty log, or one whose header never made it to disk.
+		return nil, nil
+	}
+	if !bytes.Equal(magic, walMagic) {
+		return nil, fmt.Errorf("sdbm: bad write-ahead log %s", path)
+	}
+
+	var ops []batchOp
+	for {
+		op, err := readOp(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ops, nil
+		}
+		if err != nil {
+			return nil, wrapIOErr("read", path, err)
+		}
+		ops = append(ops, op)
+	}
+}
+
+// logWAL appends op to the write-ahead log and syncs it. Operations that
+// Store or Delete would reject are rejected before they are logged, so
+// that the log only holds operations replay can apply.
+func (db *DBM) logWAL(op batchOp) error {
+	var err error
+	if op.del {
+		err = db.checkKey(db.normKey(op.key))
+	} else {
+		err = db.checkPair(op.key, op.val)
+	}
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	appendOp(&buf, op)
+	if _, err := db.wal.Write(buf.Bytes()); err != nil {
+		return wrapIOErr("write", db.wal.Name(), err)
+	}
+	if err := db.wal.Sync(); err != nil {
+		return wrapIOErr("sync", db.wal.Name(), err)
+	}
+	if db.walHook != nil {
+		return db.walHook()
+	}
+	return nil
+}
+
+// resetWAL empties the write-ahead log once its operations are durable in
+// the data files.
+func (db *DBM) resetWAL() error {
+	if err := db.wal.Truncate(0); err != nil {
+		return wrapIOErr("truncate", db.wal.Name(), err)
+	}
+	// the log is appended to with Write, so it is rewound rather than
+	// written at an offset.
+	if _, err := db.wal.Seek(0, io.SeekStart); err != nil {
+		return wrapIOErr("seek", db.wal.Name(), err)
+	}
+	if _, err := db.wal.Write(walMagic); err != nil {
+		return wrapIOErr("write", db.wal.Name(), err)
+	}
+	if err := db.wal.Sync(); err != nil {
+		return wrapIOErr("sync", db.wal.Name(), err)
+	}
+	return nil
+}
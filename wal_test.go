@@ -0,0 +1,184 @@
+package sdbm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/vvatanabe/go-sdbm"
+)
+
+func openWAL(t *testing.T, path string) *sdbm.DBM {
+	t.Helper()
+	db, err := sdbm.OpenWithOptions(path, sdbm.Options{
+		Flags: os.O_RDWR | os.O_CREATE,
+		Mode:  0644,
+		WAL:   true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	return db
+}
+
+func walSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestDBM_WAL_Replay(t *testing.T) {
+	crash := errors.New("crash")
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openWAL(t, path)
+	for _, p := range generatePairs("key", "val", 10) {
+		if _, err := db.Store(p.Key, p.Val, sdbm.StoreREPLACE); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	// every operation below is logged, then the process dies before the
+	// pages are touched.
+	sdbm.SetWALHook(db, func() error { return crash })
+	if _, err := db.Store(sdbm.Datum("new"), sdbm.Datum("value"), sdbm.StoreREPLACE); !errors.Is(err, crash) {
+		t.Fatalf("Store() error = %v, want %v", err, crash)
+	}
+	if _, err := db.Store(sdbm.Datum("key2"), sdbm.Datum("replaced"), sdbm.StoreREPLACE); !errors.Is(err, crash) {
+		t.Fatalf("Store() error = %v, want %v", err, crash)
+	}
+	if _, err := db.Store(sdbm.Datum("key3"), sdbm.Datum("kept?"), sdbm.StoreSEEDUPS); !errors.Is(err, crash) {
+		t.Fatalf("Store() error = %v, want %v", err, crash)
+	}
+	if _, err := db.Delete(sdbm.Datum("key1")); !errors.Is(err, crash) {
+		t.Fatalf("Delete() error = %v, want %v", err, crash)
+	}
+	sdbm.SetWALHook(db, nil)
+	assertFetch(t, db, "new", sdbm.Nullitem)
+	sdbm.Abandon(db)
+
+	db = openWAL(t, path)
+	defer teardown(t, db)
+	assertFetch(t, db, "new", sdbm.Datum("value"))
+	assertFetch(t, db, "key2", sdbm.Datum("replaced"))
+	assertFetch(t, db, "key3", sdbm.Datum("val3"))
+	assertFetch(t, db, "key1", sdbm.Nullitem)
+	assertFetch(t, db, "key4", sdbm.Datum("val4"))
+	if got, want := walSize(t, path), int64(len("sdbmwal1\n")); got != want {
+		t.Errorf("write-ahead log size after replay got = %d, want %d", got, want)
+	}
+}
+
+func TestDBM_WAL_ReplayFlags(t *testing.T) {
+	crash := errors.New("crash")
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openWAL(t, path)
+	if _, err := db.Store(sdbm.Datum("dup"), sdbm.Datum("1"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := db.Store(sdbm.Datum("ins"), sdbm.Datum("1"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	// "applied" reaches the pages before the crash, so replay meets it again.
+	if _, err := db.Store(sdbm.Datum("dup"), sdbm.Datum("applied"), 0); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	sdbm.SetWALHook(db, func() error { return crash })
+	if _, err := db.Store(sdbm.Datum("dup"), sdbm.Datum("2"), 0); !errors.Is(err, crash) {
+		t.Fatalf("Store() error = %v, want %v", err, crash)
+	}
+	if _, err := db.Store(sdbm.Datum("ins"), sdbm.Datum("2"), sdbm.StoreINSERT); !errors.Is(err, crash) {
+		t.Fatalf("Store() error = %v, want %v", err, crash)
+	}
+	sdbm.SetWALHook(db, nil)
+	sdbm.Abandon(db)
+
+	db = openWAL(t, path)
+	defer teardown(t, db)
+	got, err := db.FetchAll(sdbm.Datum("dup"))
+	if err != nil {
+		t.Fatalf("FetchAll() error = %v", err)
+	}
+	// replaying the StoreREPLACE of "1" moves it after "applied".
+	slices.SortFunc(got, func(a, b sdbm.Datum) int { return bytes.Compare(a, b) })
+	if want := []sdbm.Datum{sdbm.Datum("1"), sdbm.Datum("2"), sdbm.Datum("applied")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FetchAll(dup) after replay got = %q, want %q", got, want)
+	}
+	assertFetch(t, db, "ins", sdbm.Datum("1"))
+}
+
+func TestDBM_WAL_RejectsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openWAL(t, path)
+	defer teardown(t, db)
+	empty := walSize(t, path)
+
+	if _, err := db.Store(sdbm.Datum("a"), make(sdbm.Datum, sdbm.PAIRMAX), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrValueTooBig) {
+		t.Errorf("Store() error = %v, want %v", err, sdbm.ErrValueTooBig)
+	}
+	if _, err := db.Delete(make(sdbm.Datum, sdbm.PAIRMAX+1)); !errors.Is(err, sdbm.ErrKeyTooLong) {
+		t.Errorf("Delete() error = %v, want %v", err, sdbm.ErrKeyTooLong)
+	}
+	if got := walSize(t, path); got != empty {
+		t.Errorf("write-ahead log size got = %d, want %d", got, empty)
+	}
+}
+
+func TestDBM_WAL_TornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openWAL(t, path)
+	sdbm.SetWALHook(db, func() error { return errors.New("crash") })
+	_, _ = db.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE)
+	_, _ = db.Store(sdbm.Datum("b"), sdbm.Datum("2"), sdbm.StoreREPLACE)
+	sdbm.SetWALHook(db, nil)
+	sdbm.Abandon(db)
+
+	// cut the last record short, as a crash in the middle of appending it would.
+	if err := os.Truncate(path+".wal", walSize(t, path)-1); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openWAL(t, path)
+	defer teardown(t, db)
+	assertFetch(t, db, "a", sdbm.Datum("1"))
+	assertFetch(t, db, "b", sdbm.Nullitem)
+}
+
+func TestDBM_WAL_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBMFile)
+	db := openWAL(t, path)
+	empty := walSize(t, path)
+
+	if _, err := db.Store(sdbm.Datum("a"), sdbm.Datum("1"), sdbm.StoreREPLACE); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if got := walSize(t, path); got <= empty {
+		t.Errorf("write-ahead log size after Store got = %d, want more than %d", got, empty)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := walSize(t, path); got != empty {
+		t.Errorf("write-ahead log size after Sync got = %d, want %d", got, empty)
+	}
+	if _, err := db.Store(nil, sdbm.Datum("1"), sdbm.StoreREPLACE); !errors.Is(err, sdbm.ErrInvalidArgument) {
+		t.Errorf("Store() error = %v, want %v", err, sdbm.ErrInvalidArgument)
+	}
+	if got := walSize(t, path); got != empty {
+		t.Errorf("write-ahead log size after an invalid Store got = %d, want %d", got, empty)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	db = openWAL(t, path)
+	defer teardown(t, db)
+	assertFetch(t, db, "a", sdbm.Datum("1"))
+}